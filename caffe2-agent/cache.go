@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2/predict"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneBudget string
+	pruneAll    bool
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local model cache",
+	Long: "Manage the local model cache. The cache is enabled by setting caffe2.cache_dir, " +
+		"the models are otherwise kept in the work directories of the framework.",
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cached models, least recently used first",
+	RunE: func(c *cobra.Command, args []string) error {
+		cache, err := predict.DefaultCache()
+		if err != nil {
+			return err
+		}
+		entries, err := cache.Entries()
+		if err != nil {
+			return err
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Model", "Version", "Size", "Last Use", "In Use", "Work Dir"})
+		var total int64
+		for _, e := range entries {
+			table.Append([]string{e.Name, e.Version, humanize.Bytes(uint64(e.Size)), humanize.Time(e.LastUse), strconv.Itoa(e.Refs), e.WorkDir})
+			total += e.Size
+		}
+		table.SetFooter([]string{"", "", humanize.Bytes(uint64(total)), "", "", fmt.Sprintf("%d models", len(entries))})
+		table.Render()
+		return nil
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Evict the least recently used models until the cache fits its budget",
+	Long: "Evict the least recently used models until the cache fits its budget. " +
+		"The models used by a running agent are never evicted, the cache may then stay over its budget.",
+	RunE: func(c *cobra.Command, args []string) error {
+		cache, err := predict.DefaultCache()
+		if err != nil {
+			return err
+		}
+		budget := int64(-1)
+		if !pruneAll {
+			if pruneBudget == "" {
+				pruneBudget = predict.Config.CacheBudget
+			}
+			if pruneBudget == "" {
+				return errors.New("no cache budget, use --budget or --all")
+			}
+			b, err := humanize.ParseBytes(pruneBudget)
+			if err != nil {
+				return errors.Wrapf(err, "invalid budget %q", pruneBudget)
+			}
+			budget = int64(b)
+		}
+		evicted, err := cache.Prune(budget)
+		for _, e := range evicted {
+			fmt.Printf("evicted %s %s (%s, last used %s)\n", e.Name, e.Version, humanize.Bytes(uint64(e.Size)), e.LastUse.Format(time.RFC3339))
+		}
+		return err
+	},
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the checksums of the cached model files",
+	RunE: func(c *cobra.Command, args []string) error {
+		cache, err := predict.DefaultCache()
+		if err != nil {
+			return err
+		}
+		entries, err := cache.Entries()
+		if err != nil {
+			return err
+		}
+		failed := 0
+		for _, e := range entries {
+			if err := cache.Verify(e); err != nil {
+				fmt.Printf("%s %s: %v\n", e.Name, e.Version, err)
+				failed++
+				continue
+			}
+			fmt.Printf("%s %s: ok\n", e.Name, e.Version)
+		}
+		if failed != 0 {
+			return errors.Errorf("%d of the %d cached models failed verification", failed, len(entries))
+		}
+		return nil
+	},
+}
+
+func init() {
+	cachePruneCmd.Flags().StringVar(&pruneBudget, "budget", "", "size to prune the cache to, e.g. 10GB (defaults to caffe2.cache_budget)")
+	cachePruneCmd.Flags().BoolVar(&pruneAll, "all", false, "evict every cached model")
+	cacheCmd.AddCommand(cacheListCmd, cachePruneCmd, cacheVerifyCmd)
+}
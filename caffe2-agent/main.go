@@ -17,6 +17,7 @@ func main() {
 		fmt.Println(err)
 		os.Exit(-1)
 	}
+	rootCmd.AddCommand(cacheCmd)
 
 	defer tracer.Close()
 	if err := rootCmd.Execute(); err != nil {
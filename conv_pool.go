@@ -0,0 +1,193 @@
+package caffe2
+
+import (
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ConvPoolArgs holds the window arguments shared by the convolution and
+// pooling operators, following caffe2's ConvPoolOpBase.
+type ConvPoolArgs struct {
+	Kernel   []int64
+	Stride   []int64
+	Dilation []int64
+	// Pads holds the head pads of every spatial dimension followed by the tail
+	// pads, i.e. [pad_t, pad_l, pad_b, pad_r] for 2D.
+	Pads          []int64
+	LegacyPad     LegacyPadding
+	GlobalPooling bool
+	Order         string
+	Group         int64
+}
+
+// ParseConvPoolArgs reads the kernel/stride/pad/dilation arguments of a 2D
+// convolution or pooling operator.
+func ParseConvPoolArgs(op *OperatorDef) (*ConvPoolArgs, error) {
+	const spatial = 2
+
+	args := &ConvPoolArgs{
+		Kernel:        make([]int64, spatial),
+		Stride:        []int64{1, 1},
+		Dilation:      []int64{1, 1},
+		Pads:          make([]int64, 2*spatial),
+		LegacyPad:     LegacyPadding(op.GetArgInt("legacy_pad", int64(LegacyPadding_NOTSET))),
+		GlobalPooling: op.GetArgInt("global_pooling", 0) != 0,
+		Order:         strings.ToUpper(op.GetArgString("order", "NCHW")),
+		Group:         op.GetArgInt("group", 1),
+	}
+
+	readHW := func(dst []int64, name, nameH, nameW, names string) error {
+		if op.HasArgument(names) {
+			vals := op.GetArgInts(names)
+			if len(vals) != len(dst) {
+				return errors.Errorf("%s operator %s argument %s has %d values, expecting %d", op.GetType(), op.GetName(), names, len(vals), len(dst))
+			}
+			copy(dst, vals)
+			return nil
+		}
+		if op.HasArgument(name) {
+			for ii := range dst {
+				dst[ii] = op.GetArgInt(name, 0)
+			}
+			return nil
+		}
+		if nameH != "" && op.HasArgument(nameH) {
+			dst[0] = op.GetArgInt(nameH, dst[0])
+		}
+		if nameW != "" && op.HasArgument(nameW) {
+			dst[1] = op.GetArgInt(nameW, dst[1])
+		}
+		return nil
+	}
+
+	if err := readHW(args.Kernel, "kernel", "kernel_h", "kernel_w", "kernels"); err != nil {
+		return nil, err
+	}
+	if err := readHW(args.Stride, "stride", "stride_h", "stride_w", "strides"); err != nil {
+		return nil, err
+	}
+	if err := readHW(args.Dilation, "dilation", "dilation_h", "dilation_w", "dilations"); err != nil {
+		return nil, err
+	}
+	if op.HasArgument("pads") {
+		if err := readHW(args.Pads, "", "", "", "pads"); err != nil {
+			return nil, err
+		}
+	} else if op.HasArgument("pad") {
+		pad := op.GetArgInt("pad", 0)
+		for ii := range args.Pads {
+			args.Pads[ii] = pad
+		}
+	} else {
+		args.Pads[0] = op.GetArgInt("pad_t", 0)
+		args.Pads[1] = op.GetArgInt("pad_l", 0)
+		args.Pads[2] = op.GetArgInt("pad_b", 0)
+		args.Pads[3] = op.GetArgInt("pad_r", 0)
+	}
+
+	if args.Order != "NCHW" && args.Order != "NHWC" {
+		return nil, errors.Errorf("%s operator %s has unsupported order %s", op.GetType(), op.GetName(), args.Order)
+	}
+	for ii := 0; ii < spatial; ii++ {
+		if args.Stride[ii] <= 0 {
+			return nil, errors.Errorf("%s operator %s has a non-positive stride", op.GetType(), op.GetName())
+		}
+		if args.Dilation[ii] <= 0 {
+			return nil, errors.Errorf("%s operator %s has a non-positive dilation", op.GetType(), op.GetName())
+		}
+		if !args.GlobalPooling && args.Kernel[ii] <= 0 {
+			return nil, errors.Errorf("%s operator %s is missing a kernel size", op.GetType(), op.GetName())
+		}
+	}
+	if args.LegacyPad != LegacyPadding_NOTSET && args.LegacyPad != LegacyPadding_CAFFE_LEGACY_POOLING {
+		for _, pad := range args.Pads {
+			if pad != 0 {
+				return nil, errors.Errorf("%s operator %s sets both legacy_pad and explicit pads", op.GetType(), op.GetName())
+			}
+		}
+	}
+	if args.Group <= 0 {
+		return nil, errors.Errorf("%s operator %s has a non-positive group", op.GetType(), op.GetName())
+	}
+
+	return args, nil
+}
+
+// ParseConvArgs is ParseConvPoolArgs for a Conv operator, whose kernel
+// defaults to the spatial dimensions of its filter as in caffe2.
+func ParseConvArgs(op *OperatorDef, filter []int64) (*ConvPoolArgs, error) {
+	if len(filter) == 4 && !op.HasArgument("kernel") && !op.HasArgument("kernels") &&
+		!op.HasArgument("kernel_h") && !op.HasArgument("kernel_w") {
+		kernel := filter[2:4]
+		if strings.ToUpper(op.GetArgString("order", "NCHW")) == "NHWC" {
+			kernel = filter[1:3]
+		}
+		withKernel := *op
+		withKernel.Arg = append(append([]*Argument{}, op.GetArg()...), &Argument{Name: "kernels", Ints: append([]int64{}, kernel...)})
+		op = &withKernel
+	}
+	return ParseConvPoolArgs(op)
+}
+
+// OutputSize computes the spatial output size for the given spatial input size
+// and returns the effective pads, which may differ from Pads when a legacy
+// padding scheme is used.
+func (a *ConvPoolArgs) OutputSize(in []int64) ([]int64, []int64, error) {
+	spatial := len(a.Kernel)
+	if len(in) != spatial {
+		return nil, nil, errors.Errorf("expecting %d spatial dimensions, got %d", spatial, len(in))
+	}
+
+	out := make([]int64, spatial)
+	pads := make([]int64, 2*spatial)
+	copy(pads, a.Pads)
+
+	for ii := 0; ii < spatial; ii++ {
+		kernel := a.Kernel[ii]
+		if a.GlobalPooling {
+			kernel = in[ii]
+		}
+		stride := a.Stride[ii]
+		dkernel := a.Dilation[ii]*(kernel-1) + 1
+		padHead, padTail := &pads[ii], &pads[ii+spatial]
+
+		switch a.LegacyPad {
+		case LegacyPadding_NOTSET:
+			out[ii] = (in[ii]+*padHead+*padTail-dkernel)/stride + 1
+		case LegacyPadding_VALID:
+			*padHead, *padTail = 0, 0
+			out[ii] = (in[ii]-dkernel)/stride + 1
+		case LegacyPadding_SAME:
+			out[ii] = (in[ii] + stride - 1) / stride
+			needed := (out[ii]-1)*stride + dkernel - in[ii]
+			if needed < 0 {
+				needed = 0
+			}
+			*padHead = needed / 2
+			*padTail = needed - *padHead
+		case LegacyPadding_CAFFE_LEGACY_POOLING:
+			// caffe rounds the output size up where caffe2 rounds down, the
+			// difference is made up by padding the tail
+			out[ii] = int64(math.Ceil(float64(in[ii]+*padHead*2-dkernel)/float64(stride))) + 1
+			if *padHead > 0 && (out[ii]-1)*stride >= in[ii]+*padHead {
+				out[ii]--
+			}
+			standard := (in[ii]+*padHead*2-dkernel)/stride + 1
+			*padTail = *padHead + stride*(out[ii]-standard)
+		default:
+			return nil, nil, errors.Errorf("unknown legacy padding %v", a.LegacyPad)
+		}
+
+		if a.GlobalPooling {
+			out[ii] = 1
+			*padHead, *padTail = 0, 0
+		}
+		if out[ii] <= 0 {
+			return nil, nil, errors.Errorf("kernel of size %d does not fit an input of size %d", dkernel, in[ii])
+		}
+	}
+
+	return out, pads, nil
+}
@@ -0,0 +1,133 @@
+package caffe2
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+// Format is the serialization format of a caffe2 message.
+type Format string
+
+const (
+	// FormatBinary is the protobuf wire format used by the .pb files.
+	FormatBinary Format = "binary"
+	// FormatText is the protobuf text format (.pbtxt).
+	FormatText Format = "text"
+	// FormatJSON is the protobuf JSON mapping.
+	FormatJSON Format = "json"
+)
+
+// FormatFromPath guesses the format of a file from its extension, defaulting
+// to the binary format.
+func FormatFromPath(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pbtxt", ".prototxt", ".txt":
+		return FormatText
+	case ".json":
+		return FormatJSON
+	}
+	return FormatBinary
+}
+
+// ParseFormat ...
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "binary", "pb", "bin":
+		return FormatBinary, nil
+	case "text", "pbtxt", "prototxt", "txt":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return "", errors.Errorf("unknown format %v", s)
+}
+
+var messageConstructors = map[string]func() proto.Message{
+	"NetDef":       func() proto.Message { return &NetDef{} },
+	"PlanDef":      func() proto.Message { return &PlanDef{} },
+	"TensorProtos": func() proto.Message { return &TensorProtos{} },
+	"TensorProto":  func() proto.Message { return &TensorProto{} },
+	"QTensorProto": func() proto.Message { return &QTensorProto{} },
+	"MetaNetDef":   func() proto.Message { return &MetaNetDef{} },
+}
+
+// NewMessage returns an empty message of the given type name (NetDef, PlanDef,
+// TensorProtos, TensorProto, QTensorProto or MetaNetDef). The name is case
+// insensitive.
+func NewMessage(typeName string) (proto.Message, error) {
+	for name, ctor := range messageConstructors {
+		if strings.EqualFold(name, typeName) {
+			return ctor(), nil
+		}
+	}
+	return nil, errors.Errorf("unknown message type %v, expecting one of %s", typeName, strings.Join(MessageTypes(), ", "))
+}
+
+// MessageTypes lists the names accepted by NewMessage.
+func MessageTypes() []string {
+	names := make([]string, 0, len(messageConstructors))
+	for name := range messageConstructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MarshalFormat serializes the message in the given format.
+func MarshalFormat(msg proto.Message, format Format) ([]byte, error) {
+	switch format {
+	case FormatBinary:
+		return proto.Marshal(msg)
+	case FormatText:
+		buf := new(bytes.Buffer)
+		if err := proto.MarshalText(buf, msg); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case FormatJSON:
+		marshaler := jsonpb.Marshaler{OrigName: true, Indent: "  "}
+		buf := new(bytes.Buffer)
+		if err := marshaler.Marshal(buf, msg); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\n")
+		return buf.Bytes(), nil
+	}
+	return nil, errors.Errorf("unknown format %v", format)
+}
+
+// UnmarshalFormat parses the message from the given format.
+func UnmarshalFormat(buf []byte, msg proto.Message, format Format) error {
+	switch format {
+	case FormatBinary:
+		return proto.Unmarshal(buf, msg)
+	case FormatText:
+		return proto.UnmarshalText(string(buf), msg)
+	case FormatJSON:
+		return jsonpb.Unmarshal(bytes.NewReader(buf), msg)
+	}
+	return errors.Errorf("unknown format %v", format)
+}
+
+// Convert re-encodes a serialized message of the given type from one format
+// to another.
+func Convert(buf []byte, typeName string, from, to Format) ([]byte, error) {
+	msg, err := NewMessage(typeName)
+	if err != nil {
+		return nil, err
+	}
+	if err := UnmarshalFormat(buf, msg, from); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse %s in %s format", typeName, from)
+	}
+	res, err := MarshalFormat(msg, to)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to write %s in %s format", typeName, to)
+	}
+	return res, nil
+}
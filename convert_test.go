@@ -0,0 +1,125 @@
+package caffe2
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+func TestFormatFromPath(t *testing.T) {
+	for path, want := range map[string]Format{
+		"predict_net.pb":     FormatBinary,
+		"predict_net.pbtxt":  FormatText,
+		"deploy.PROTOTXT":    FormatText,
+		"predict_net.json":   FormatJSON,
+		"init_net":           FormatBinary,
+		"dir.json/weights.b": FormatBinary,
+	} {
+		if got := FormatFromPath(path); got != want {
+			t.Errorf("FormatFromPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for s, want := range map[string]Format{"pb": FormatBinary, "Text": FormatText, "json": FormatJSON} {
+		if got, err := ParseFormat(s); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("yaml"); err == nil {
+		t.Error("ParseFormat of an unknown format succeeded")
+	}
+}
+
+func TestNewMessage(t *testing.T) {
+	msg, err := NewMessage("netdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*NetDef); !ok {
+		t.Errorf("NewMessage(netdef) = %T, want *NetDef", msg)
+	}
+	if _, err := NewMessage("Blob"); err == nil || !strings.Contains(err.Error(), strings.Join(MessageTypes(), ", ")) {
+		t.Errorf("NewMessage of an unknown type: %v, want the list of the types", err)
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	net := testNet()
+	binary, err := MarshalFormat(net, FormatBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []Format{FormatText, FormatJSON} {
+		converted, err := Convert(binary, "NetDef", FormatBinary, format)
+		if err != nil {
+			t.Fatalf("converting to %v: %v", format, err)
+		}
+		back, err := Convert(converted, "NetDef", format, FormatBinary)
+		if err != nil {
+			t.Fatalf("converting from %v: %v", format, err)
+		}
+		got := &NetDef{}
+		if err := UnmarshalFormat(back, got, FormatBinary); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, net) {
+			t.Errorf("round trip through %v = %v, want %v", format, got, net)
+		}
+	}
+	if _, err := Convert([]byte("{"), "NetDef", FormatJSON, FormatText); err == nil {
+		t.Error("Convert of invalid JSON succeeded")
+	}
+}
+
+// TestConvertRoundTripData round trips the packed tensor data and the bytes
+// arguments, including binary strings and non-finite floats.
+func TestConvertRoundTripData(t *testing.T) {
+	nan, inf := float32(math.NaN()), float32(math.Inf(1))
+	binary := []byte{0, 0xff, 0xfe, '"', '\\', '\n', 'a'}
+	net := testNet()
+	net.Op[0].Arg = append(net.Op[0].Arg,
+		&Argument{Name: "s", S: binary},
+		&Argument{Name: "strings", Strings: [][]byte{[]byte("NCHW"), binary, {}}},
+		&Argument{Name: "f", F: nan},
+		&Argument{Name: "floats", Floats: []float32{inf, -inf, nan, 1.5, -0.25}},
+	)
+	floatType, int32Type, byteType, stringType, doubleType := TensorProto_FLOAT, TensorProto_INT32, TensorProto_BYTE, TensorProto_STRING, TensorProto_DOUBLE
+	tensors := &TensorProtos{Protos: []*TensorProto{
+		{Name: "float", Dims: []int64{2, 3}, DataType: &floatType, FloatData: []float32{nan, inf, -inf, 0, -1e-30, math.MaxFloat32}},
+		{Name: "int32", Dims: []int64{4}, DataType: &int32Type, Int32Data: []int32{0, -1, math.MaxInt32, math.MinInt32}},
+		{Name: "byte", Dims: []int64{7}, DataType: &byteType, ByteData: binary},
+		{Name: "string", Dims: []int64{3}, DataType: &stringType, StringData: [][]byte{[]byte("label"), binary, {}}},
+		{Name: "double", Dims: []int64{3}, DataType: &doubleType, DoubleData: []float64{math.NaN(), math.Inf(-1), math.SmallestNonzeroFloat64}, Int64Data: []int64{math.MinInt64}},
+	}}
+
+	for typeName, msg := range map[string]proto.Message{"NetDef": net, "TensorProtos": tensors} {
+		want, err := MarshalFormat(msg, FormatBinary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, format := range []Format{FormatText, FormatJSON} {
+			converted, err := Convert(want, typeName, FormatBinary, format)
+			if err != nil {
+				t.Errorf("converting a %s to %v: %v", typeName, format, err)
+				continue
+			}
+			// NaN is not equal to itself, the binary encodings are compared
+			got, err := Convert(converted, typeName, format, FormatBinary)
+			if err != nil {
+				t.Errorf("converting a %s from %v: %v", typeName, format, err)
+				continue
+			}
+			if !bytes.Equal(got, want) {
+				back, _ := NewMessage(typeName)
+				UnmarshalFormat(got, back, FormatBinary)
+				t.Errorf("round trip of a %s through %v = %v, want %v", typeName, format, back, msg)
+			}
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package caffe2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change is a single modified field between two versions of a NetDef.
+// An empty Old (New) means that the field was added (removed).
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// OperatorDiff lists the changes of an operator present in both nets.
+type OperatorDiff struct {
+	Key     string   `json:"key"`
+	Type    string   `json:"type"`
+	Changes []Change `json:"changes"`
+}
+
+// OperatorRef identifies an operator added or removed between two nets.
+type OperatorRef struct {
+	Key   string       `json:"key"`
+	Index int          `json:"index"`
+	Op    *OperatorDef `json:"op"`
+}
+
+// NetDiff is the semantic difference between two NetDefs. Operators are
+// matched by name, or by type and outputs when unnamed, so that reordering
+// operators, arguments or external blobs is not reported as a change.
+type NetDiff struct {
+	Changes                []Change       `json:"changes,omitempty"`
+	AddedExternalInputs    []string       `json:"added_external_inputs,omitempty"`
+	RemovedExternalInputs  []string       `json:"removed_external_inputs,omitempty"`
+	AddedExternalOutputs   []string       `json:"added_external_outputs,omitempty"`
+	RemovedExternalOutputs []string       `json:"removed_external_outputs,omitempty"`
+	AddedOperators         []OperatorRef  `json:"added_operators,omitempty"`
+	RemovedOperators       []OperatorRef  `json:"removed_operators,omitempty"`
+	ModifiedOperators      []OperatorDiff `json:"modified_operators,omitempty"`
+}
+
+// DiffNets computes the semantic difference between the from and to nets.
+func DiffNets(from, to *NetDef) *NetDiff {
+	diff := &NetDiff{}
+
+	diff.Changes = appendChange(diff.Changes, "name", from.GetName(), to.GetName())
+	diff.Changes = appendChange(diff.Changes, "type", from.GetType(), to.GetType())
+	diff.Changes = appendChange(diff.Changes, "device_option", formatDeviceOption(from.GetDeviceOption()), formatDeviceOption(to.GetDeviceOption()))
+	diff.Changes = append(diff.Changes, diffArguments(from.GetArg(), to.GetArg())...)
+
+	diff.RemovedExternalInputs, diff.AddedExternalInputs = diffSets(from.GetExternalInput(), to.GetExternalInput())
+	diff.RemovedExternalOutputs, diff.AddedExternalOutputs = diffSets(from.GetExternalOutput(), to.GetExternalOutput())
+
+	oldKeys := operatorKeys(from)
+	newKeys := operatorKeys(to)
+	newIndex := map[string]int{}
+	for ii, key := range newKeys {
+		newIndex[key] = ii
+	}
+	matched := map[string]bool{}
+	for ii, key := range oldKeys {
+		jj, ok := newIndex[key]
+		if !ok {
+			diff.RemovedOperators = append(diff.RemovedOperators, OperatorRef{Key: key, Index: ii, Op: from.Op[ii]})
+			continue
+		}
+		matched[key] = true
+		if changes := diffOperators(from.Op[ii], to.Op[jj]); len(changes) != 0 {
+			diff.ModifiedOperators = append(diff.ModifiedOperators, OperatorDiff{
+				Key:     key,
+				Type:    to.Op[jj].GetType(),
+				Changes: changes,
+			})
+		}
+	}
+	for jj, key := range newKeys {
+		if !matched[key] {
+			diff.AddedOperators = append(diff.AddedOperators, OperatorRef{Key: key, Index: jj, Op: to.Op[jj]})
+		}
+	}
+
+	return diff
+}
+
+// Empty returns true if both nets are semantically equivalent.
+func (d *NetDiff) Empty() bool {
+	return len(d.Changes) == 0 &&
+		len(d.AddedExternalInputs) == 0 && len(d.RemovedExternalInputs) == 0 &&
+		len(d.AddedExternalOutputs) == 0 && len(d.RemovedExternalOutputs) == 0 &&
+		len(d.AddedOperators) == 0 && len(d.RemovedOperators) == 0 &&
+		len(d.ModifiedOperators) == 0
+}
+
+// WriteTo writes a human readable report of the difference.
+func (d *NetDiff) WriteTo(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	writeChanges := func(indent string, changes []Change) {
+		for _, change := range changes {
+			switch {
+			case change.Old == "":
+				fmt.Fprintf(buf, "%s+ %s: %s\n", indent, change.Field, change.New)
+			case change.New == "":
+				fmt.Fprintf(buf, "%s- %s: %s\n", indent, change.Field, change.Old)
+			default:
+				fmt.Fprintf(buf, "%s~ %s: %s -> %s\n", indent, change.Field, change.Old, change.New)
+			}
+		}
+	}
+	writeBlobs := func(prefix, title string, blobs []string) {
+		for _, blob := range blobs {
+			fmt.Fprintf(buf, "%s %s %s\n", prefix, title, blob)
+		}
+	}
+
+	if d.Empty() {
+		buf.WriteString("no differences\n")
+	}
+	writeChanges("", d.Changes)
+	writeBlobs("+", "external_input", d.AddedExternalInputs)
+	writeBlobs("-", "external_input", d.RemovedExternalInputs)
+	writeBlobs("+", "external_output", d.AddedExternalOutputs)
+	writeBlobs("-", "external_output", d.RemovedExternalOutputs)
+	for _, op := range d.RemovedOperators {
+		fmt.Fprintf(buf, "- op %s (#%d): %s\n", op.Key, op.Index, formatOperator(op.Op))
+	}
+	for _, op := range d.AddedOperators {
+		fmt.Fprintf(buf, "+ op %s (#%d): %s\n", op.Key, op.Index, formatOperator(op.Op))
+	}
+	for _, op := range d.ModifiedOperators {
+		fmt.Fprintf(buf, "~ op %s\n", op.Key)
+		writeChanges("    ", op.Changes)
+	}
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// operatorKeys returns a key per operator that is stable across reorderings:
+// the operator name when present, otherwise its type and outputs, with an
+// occurrence counter to disambiguate duplicates.
+func operatorKeys(net *NetDef) []string {
+	keys := make([]string, len(net.GetOp()))
+	seen := map[string]int{}
+	for ii, op := range net.GetOp() {
+		key := op.GetName()
+		if key == "" {
+			key = op.GetType() + "(" + strings.Join(op.GetOutput(), ",") + ")"
+		}
+		if n := seen[key]; n != 0 {
+			keys[ii] = key + "#" + strconv.Itoa(n)
+		} else {
+			keys[ii] = key
+		}
+		seen[key]++
+	}
+	return keys
+}
+
+func diffOperators(from, to *OperatorDef) []Change {
+	var changes []Change
+	changes = appendChange(changes, "type", from.GetType(), to.GetType())
+	changes = appendChange(changes, "input", formatBlobs(from.GetInput()), formatBlobs(to.GetInput()))
+	changes = appendChange(changes, "output", formatBlobs(from.GetOutput()), formatBlobs(to.GetOutput()))
+	changes = appendChange(changes, "engine", from.GetEngine(), to.GetEngine())
+	changes = appendChange(changes, "device_option", formatDeviceOption(from.GetDeviceOption()), formatDeviceOption(to.GetDeviceOption()))
+	changes = appendChange(changes, "is_gradient_op", formatBool(from.GetIsGradientOp()), formatBool(to.GetIsGradientOp()))
+	removed, added := diffSets(from.GetControlInput(), to.GetControlInput())
+	for _, blob := range removed {
+		changes = append(changes, Change{Field: "control_input", Old: blob})
+	}
+	for _, blob := range added {
+		changes = append(changes, Change{Field: "control_input", New: blob})
+	}
+	return append(changes, diffArguments(from.GetArg(), to.GetArg())...)
+}
+
+func diffArguments(from, to []*Argument) []Change {
+	oldArgs := map[string]*Argument{}
+	for _, arg := range from {
+		oldArgs[arg.GetName()] = arg
+	}
+	newArgs := map[string]*Argument{}
+	for _, arg := range to {
+		newArgs[arg.GetName()] = arg
+	}
+
+	var names []string
+	for name := range oldArgs {
+		names = append(names, name)
+	}
+	for name := range newArgs {
+		if _, ok := oldArgs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []Change
+	for _, name := range names {
+		oldArg, newArg := oldArgs[name], newArgs[name]
+		if oldArg != nil && newArg != nil {
+			oldBuf, _ := oldArg.Marshal()
+			newBuf, _ := newArg.Marshal()
+			if bytes.Equal(oldBuf, newBuf) {
+				continue
+			}
+		}
+		changes = append(changes, Change{
+			Field: "arg " + name,
+			Old:   FormatArgumentValue(oldArg),
+			New:   FormatArgumentValue(newArg),
+		})
+	}
+	return changes
+}
+
+// FormatArgumentValue returns a short human readable form of the value held
+// by the argument, or an empty string for a nil argument.
+func FormatArgumentValue(arg *Argument) string {
+	if arg == nil {
+		return ""
+	}
+	switch {
+	case len(arg.GetFloats()) != 0:
+		return fmt.Sprint(arg.GetFloats())
+	case len(arg.GetInts()) != 0:
+		return fmt.Sprint(arg.GetInts())
+	case len(arg.GetStrings()) != 0:
+		strs := make([]string, len(arg.GetStrings()))
+		for ii, s := range arg.GetStrings() {
+			strs[ii] = strconv.Quote(string(s))
+		}
+		return "[" + strings.Join(strs, " ") + "]"
+	case arg.GetS() != nil:
+		return strconv.Quote(string(arg.GetS()))
+	case arg.GetF() != 0:
+		return strconv.FormatFloat(float64(arg.GetF()), 'g', -1, 32)
+	default:
+		return strconv.FormatInt(arg.GetI(), 10)
+	}
+}
+
+func formatDeviceOption(opt *DeviceOption) string {
+	deviceType := DeviceType(opt.GetDeviceType()).String()
+	res := deviceType
+	if opt.GetDeviceType() == int32(DeviceType_CUDA) {
+		res += ":" + strconv.Itoa(int(opt.GetCudaGpuId()))
+	}
+	if opt.GetRandomSeed() != 0 {
+		res += " seed=" + strconv.FormatUint(uint64(opt.GetRandomSeed()), 10)
+	}
+	return res
+}
+
+func formatOperator(op *OperatorDef) string {
+	return op.GetType() + " " + formatBlobs(op.GetInput()) + " -> " + formatBlobs(op.GetOutput())
+}
+
+func formatBlobs(blobs []string) string {
+	return "[" + strings.Join(blobs, ", ") + "]"
+}
+
+func formatBool(b bool) string {
+	if !b {
+		return ""
+	}
+	return "true"
+}
+
+func appendChange(changes []Change, field, from, to string) []Change {
+	if from == to {
+		return changes
+	}
+	return append(changes, Change{Field: field, Old: from, New: to})
+}
+
+// diffSets returns the elements only in from and the elements only in to.
+func diffSets(from, to []string) (removed []string, added []string) {
+	oldSet := map[string]bool{}
+	for _, s := range from {
+		oldSet[s] = true
+	}
+	newSet := map[string]bool{}
+	for _, s := range to {
+		newSet[s] = true
+	}
+	for _, s := range from {
+		if !newSet[s] {
+			removed = append(removed, s)
+		}
+	}
+	for _, s := range to {
+		if !oldSet[s] {
+			added = append(added, s)
+		}
+	}
+	return removed, added
+}
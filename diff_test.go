@@ -0,0 +1,130 @@
+package caffe2
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffNetsIgnoresReordering(t *testing.T) {
+	from := testNet()
+	to := testNet()
+	// reorder the operators, their arguments and the external blobs
+	to.Op[0], to.Op[1] = to.Op[1], to.Op[0]
+	to.Op[1].Arg[0], to.Op[1].Arg[1] = to.Op[1].Arg[1], to.Op[1].Arg[0]
+	to.ExternalInput = []string{"fc_b", "fc_w", "conv_b", "conv_w", "data"}
+
+	diff := DiffNets(from, to)
+	if !diff.Empty() {
+		t.Errorf("reordered net differs: %+v", diff)
+	}
+	buf := new(bytes.Buffer)
+	if _, err := diff.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "no differences\n" {
+		t.Errorf("report = %q, want no differences", got)
+	}
+}
+
+func TestDiffNets(t *testing.T) {
+	from := testNet()
+	to := testNet()
+	to.Name = "test_v2"
+	// change the pad of the Conv and move it to the GPU
+	to.Op[0].Arg[1] = intArg("pad", 0)
+	to.Op[0].Engine = "CUDNN"
+	cuda := int32(DeviceType_CUDA)
+	to.Op[0].DeviceOption = &DeviceOption{DeviceType: &cuda, CudaGpuId: 1}
+	// replace the Softmax by a named one and expose the logits
+	to.Op[4] = &OperatorDef{Name: "softmax", Type: "Softmax", Input: []string{"fc"}, Output: []string{"prob"}}
+	to.ExternalOutput = []string{"prob", "fc"}
+	to.ExternalInput = to.ExternalInput[:4]
+
+	diff := DiffNets(from, to)
+	if diff.Empty() {
+		t.Fatal("the diff is empty")
+	}
+	if want := []Change{{Field: "name", Old: "test", New: "test_v2"}}; !reflect.DeepEqual(diff.Changes, want) {
+		t.Errorf("net changes = %v, want %v", diff.Changes, want)
+	}
+	if want := []string{"fc_b"}; !reflect.DeepEqual(diff.RemovedExternalInputs, want) || len(diff.AddedExternalInputs) != 0 {
+		t.Errorf("external inputs: removed %v added %v, want removed %v", diff.RemovedExternalInputs, diff.AddedExternalInputs, want)
+	}
+	if want := []string{"fc"}; !reflect.DeepEqual(diff.AddedExternalOutputs, want) || len(diff.RemovedExternalOutputs) != 0 {
+		t.Errorf("external outputs: added %v removed %v, want added %v", diff.AddedExternalOutputs, diff.RemovedExternalOutputs, want)
+	}
+	if len(diff.RemovedOperators) != 1 || diff.RemovedOperators[0].Key != "Softmax(prob)" || diff.RemovedOperators[0].Index != 4 {
+		t.Errorf("removed operators = %+v, want Softmax(prob) #4", diff.RemovedOperators)
+	}
+	if len(diff.AddedOperators) != 1 || diff.AddedOperators[0].Key != "softmax" {
+		t.Errorf("added operators = %+v, want softmax", diff.AddedOperators)
+	}
+
+	want := []OperatorDiff{{
+		Key:  "Conv(conv)",
+		Type: "Conv",
+		Changes: []Change{
+			{Field: "engine", New: "CUDNN"},
+			{Field: "device_option", Old: "CPU", New: "CUDA:1"},
+			{Field: "arg pad", Old: "1", New: "0"},
+		},
+	}}
+	if !reflect.DeepEqual(diff.ModifiedOperators, want) {
+		t.Errorf("modified operators = %+v, want %+v", diff.ModifiedOperators, want)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := diff.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"~ name: test -> test_v2",
+		"- external_input fc_b",
+		"+ external_output fc",
+		"- op Softmax(prob) (#4): Softmax [fc] -> [prob]",
+		"+ op softmax (#4): Softmax [fc] -> [prob]",
+		"~ op Conv(conv)",
+		"    + engine: CUDNN",
+		"    ~ arg pad: 1 -> 0",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in the report\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestDiffNetsDuplicateOperators(t *testing.T) {
+	from := &NetDef{Op: []*OperatorDef{
+		newOp("Relu", []string{"x"}, []string{"x"}),
+		newOp("Relu", []string{"x"}, []string{"x"}),
+	}}
+	to := &NetDef{Op: []*OperatorDef{
+		newOp("Relu", []string{"x"}, []string{"x"}),
+	}}
+	diff := DiffNets(from, to)
+	if len(diff.RemovedOperators) != 1 || diff.RemovedOperators[0].Key != "Relu(x)#1" {
+		t.Errorf("removed operators = %+v, want Relu(x)#1", diff.RemovedOperators)
+	}
+}
+
+func TestFormatArgumentValue(t *testing.T) {
+	tests := []struct {
+		arg  *Argument
+		want string
+	}{
+		{nil, ""},
+		{intArg("i", 3), "3"},
+		{floatArg("f", 0.25), "0.25"},
+		{stringArg("s", "NCHW"), `"NCHW"`},
+		{intsArg("ints", 1, 2), "[1 2]"},
+		{floatsArg("floats", 0.5, 1), "[0.5 1]"},
+		{&Argument{Name: "strings", Strings: [][]byte{[]byte("a"), []byte("b")}}, `["a" "b"]`},
+	}
+	for _, test := range tests {
+		if got := FormatArgumentValue(test.arg); got != test.want {
+			t.Errorf("FormatArgumentValue(%v) = %s, want %s", test.arg, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package caffe2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DotOptions controls how a NetDef is rendered by WriteDot.
+type DotOptions struct {
+	// CollapseWeights removes the parameter blobs from the graph and lists them
+	// inside the label of the operator consuming them.
+	CollapseWeights bool
+	// Weights is the set of parameter blobs. If nil, it is computed with
+	// WeightBlobs from InitNet.
+	Weights map[string]bool
+	// InitNet is the optional init net used to detect the parameter blobs.
+	InitNet *NetDef
+	// RankDir is the graphviz rank direction (TB, LR, ...). Defaults to TB.
+	RankDir string
+}
+
+const (
+	dotExternalInputColor  = "lightblue"
+	dotExternalOutputColor = "lightsalmon"
+	dotWeightColor         = "lightgrey"
+)
+
+// WriteDot writes the graph of the NetDef in graphviz DOT format.
+// Operators are drawn as boxes and blobs as ellipses. Blobs that are written
+// more than once (e.g. by in-place operators) get one node per version so
+// that the graph stays acyclic.
+func WriteDot(w io.Writer, net *NetDef, opts DotOptions) error {
+	weights := opts.Weights
+	if weights == nil {
+		weights = WeightBlobs(net, opts.InitNet)
+	}
+	rankDir := opts.RankDir
+	if rankDir == "" {
+		rankDir = "TB"
+	}
+
+	externalInputs := map[string]bool{}
+	for _, input := range net.GetExternalInput() {
+		externalInputs[input] = true
+	}
+	externalOutputs := map[string]bool{}
+	for _, output := range net.GetExternalOutput() {
+		externalOutputs[output] = true
+	}
+
+	name := net.GetName()
+	if name == "" {
+		name = "net"
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "digraph %s {\n", strconv.Quote(name))
+	fmt.Fprintf(buf, "  rankdir=%s;\n", rankDir)
+	fmt.Fprintf(buf, "  node [fontname=\"Helvetica\", fontsize=10];\n")
+
+	versions := map[string]int{}
+	declared := map[string]bool{}
+	blobNode := func(blob string) string {
+		id := dotBlobID(blob, versions[blob])
+		if declared[id] {
+			return id
+		}
+		declared[id] = true
+		attrs := []string{"shape=ellipse"}
+		switch {
+		case weights[blob]:
+			attrs = append(attrs, "style=filled", "fillcolor="+dotWeightColor)
+		case externalInputs[blob] && versions[blob] == 0:
+			attrs = append(attrs, "style=filled", "fillcolor="+dotExternalInputColor, "penwidth=2")
+		}
+		fmt.Fprintf(buf, "  %s [label=%s, %s];\n", id, strconv.Quote(blob), strings.Join(attrs, ", "))
+		return id
+	}
+
+	for ii, op := range net.GetOp() {
+		opID := "op_" + strconv.Itoa(ii)
+		label := op.GetType()
+		if op.GetName() != "" {
+			label = op.GetName() + "\\n" + label
+		}
+		if op.GetEngine() != "" {
+			label += " (" + op.GetEngine() + ")"
+		}
+		var collapsed []string
+		for _, input := range op.GetInput() {
+			if opts.CollapseWeights && weights[input] {
+				collapsed = append(collapsed, input)
+			}
+		}
+		if len(collapsed) != 0 {
+			label += "\\n[" + strings.Join(collapsed, ", ") + "]"
+		}
+		fmt.Fprintf(buf, "  %s [label=\"%s\", shape=box, style=\"rounded,filled\", fillcolor=white];\n", opID, dotEscape(label))
+
+		for _, input := range op.GetInput() {
+			if opts.CollapseWeights && weights[input] {
+				continue
+			}
+			fmt.Fprintf(buf, "  %s -> %s;\n", blobNode(input), opID)
+		}
+		for _, output := range op.GetOutput() {
+			if _, ok := versions[output]; ok || declared[dotBlobID(output, 0)] {
+				versions[output]++
+			} else {
+				versions[output] = 0
+			}
+			fmt.Fprintf(buf, "  %s -> %s;\n", opID, blobNode(output))
+		}
+	}
+
+	// highlight the final version of each external output
+	for _, output := range net.GetExternalOutput() {
+		id := dotBlobID(output, versions[output])
+		if !declared[id] {
+			blobNode(output)
+		}
+		fmt.Fprintf(buf, "  %s [style=filled, fillcolor=%s, penwidth=2];\n", id, dotExternalOutputColor)
+	}
+
+	buf.WriteString("}\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func dotBlobID(blob string, version int) string {
+	return strconv.Quote("blob_" + blob + "#" + strconv.Itoa(version))
+}
+
+func dotEscape(s string) string {
+	return strings.Replace(s, "\"", "\\\"", -1)
+}
@@ -0,0 +1,73 @@
+package caffe2
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func writeDot(t *testing.T, net *NetDef, opts DotOptions) string {
+	buf := new(bytes.Buffer)
+	if err := WriteDot(buf, net, opts); err != nil {
+		t.Fatalf("WriteDot failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestWriteDot(t *testing.T) {
+	dot := writeDot(t, testNet(), DotOptions{InitNet: testInitNet(), RankDir: "LR"})
+
+	for _, want := range []string{
+		`digraph "test" {`,
+		"rankdir=LR;",
+		`op_0 [label="Conv", shape=box`,
+		`"blob_data#0" [label="data", shape=ellipse, style=filled, fillcolor=lightblue, penwidth=2];`,
+		`"blob_conv_w#0" [label="conv_w", shape=ellipse, style=filled, fillcolor=lightgrey];`,
+		`"blob_conv_w#0" -> op_0;`,
+		// the in-place Relu writes a new version of conv
+		`op_0 -> "blob_conv#0";`,
+		`"blob_conv#0" -> op_1;`,
+		`op_1 -> "blob_conv#1";`,
+		`"blob_conv#1" -> op_2;`,
+		`"blob_prob#0" [style=filled, fillcolor=lightsalmon, penwidth=2];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("missing %s in\n%s", want, dot)
+		}
+	}
+	if !strings.HasSuffix(dot, "}\n") {
+		t.Errorf("unterminated graph\n%s", dot)
+	}
+}
+
+func TestWriteDotCollapseWeights(t *testing.T) {
+	dot := writeDot(t, testNet(), DotOptions{CollapseWeights: true})
+
+	if strings.Contains(dot, "blob_conv_w") || strings.Contains(dot, "blob_fc_b") {
+		t.Errorf("weight blobs are not collapsed\n%s", dot)
+	}
+	for _, want := range []string{
+		`op_0 [label="Conv\n[conv_w, conv_b]"`,
+		`op_3 [label="FC\n[fc_w, fc_b]"`,
+		`"blob_data#0" -> op_0;`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("missing %s in\n%s", want, dot)
+		}
+	}
+}
+
+func TestWriteDotEscapesLabels(t *testing.T) {
+	net := &NetDef{
+		Op: []*OperatorDef{
+			{Name: `say "hi"`, Type: "Relu", Engine: "CUDNN", Input: []string{"x"}, Output: []string{"y"}},
+		},
+	}
+	dot := writeDot(t, net, DotOptions{})
+	if !strings.Contains(dot, `digraph "net" {`) {
+		t.Errorf("unnamed net is not named net\n%s", dot)
+	}
+	if want := `label="say \"hi\"\nRelu (CUDNN)"`; !strings.Contains(dot, want) {
+		t.Errorf("missing %s in\n%s", want, dot)
+	}
+}
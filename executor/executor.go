@@ -0,0 +1,191 @@
+// Package executor is a pure Go interpreter for the float32 CPU operators used
+// by the builtin models. It is a slow reference implementation meant to run
+// nets without the caffe2 runtime and to cross-check its outputs.
+package executor
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+)
+
+// Blob is a dense float32 tensor stored in row major order.
+type Blob struct {
+	Dims []int64
+	Data []float32
+}
+
+// NewBlob allocates a zeroed blob of the given dimensions.
+func NewBlob(dims ...int64) *Blob {
+	return &Blob{
+		Dims: append([]int64{}, dims...),
+		Data: make([]float32, size(dims)),
+	}
+}
+
+// BlobFromTensor converts a numeric TensorProto to a blob.
+func BlobFromTensor(tensor *caffe2.TensorProto) (*Blob, error) {
+	t, err := caffe2.DecodeTensor(tensor)
+	if err != nil {
+		return nil, err
+	}
+	data, err := t.Float32s()
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{Dims: t.Dims, Data: data}, nil
+}
+
+// TensorProto converts the blob to a FLOAT TensorProto.
+func (b *Blob) TensorProto(name string) (*caffe2.TensorProto, error) {
+	return caffe2.NewTensorProto(name, b.Dims, b.Data)
+}
+
+// Size returns the number of elements of the blob.
+func (b *Blob) Size() int64 {
+	return size(b.Dims)
+}
+
+// Executor runs a NetDef whose weights are filled by an init net. The weights
+// are never modified and every run has its own workspace, so an executor can
+// run concurrently.
+type Executor struct {
+	net     *caffe2.NetDef
+	weights map[string]*Blob
+}
+
+// New loads the weights of the init net and checks that every operator of the
+// net is supported.
+func New(net, init *caffe2.NetDef) (*Executor, error) {
+	var unsupported []string
+	for _, op := range net.GetOp() {
+		if _, ok := operators[op.GetType()]; !ok {
+			unsupported = append(unsupported, op.GetType())
+		}
+	}
+	if len(unsupported) != 0 {
+		return nil, errors.Errorf("unsupported operators %s", strings.Join(unique(unsupported), ", "))
+	}
+
+	tensors, err := caffe2.InitNetTensors(init)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the init net")
+	}
+	tensors, err = caffe2.AssembleTensorProtos(tensors)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the init net")
+	}
+	weights := map[string]*Blob{}
+	for _, tensor := range tensors.GetProtos() {
+		blob, err := BlobFromTensor(tensor)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to load weight %s", tensor.GetName())
+		}
+		weights[tensor.GetName()] = blob
+	}
+
+	return &Executor{
+		net:     net,
+		weights: weights,
+	}, nil
+}
+
+// Inputs lists the external inputs of the net that are not filled by the init
+// net and must be given to Run.
+func (e *Executor) Inputs() []string {
+	var res []string
+	for _, input := range e.net.GetExternalInput() {
+		if _, ok := e.weights[input]; !ok {
+			res = append(res, input)
+		}
+	}
+	return res
+}
+
+// Outputs lists the external outputs of the net, or the outputs of its last
+// operator if none are declared.
+func (e *Executor) Outputs() []string {
+	if outputs := e.net.GetExternalOutput(); len(outputs) != 0 {
+		return outputs
+	}
+	ops := e.net.GetOp()
+	if len(ops) == 0 {
+		return nil
+	}
+	return ops[len(ops)-1].GetOutput()
+}
+
+// Run executes the net on the given inputs and returns the requested blobs,
+// the net outputs by default.
+func (e *Executor) Run(inputs map[string]*Blob, outputs ...string) (map[string]*Blob, error) {
+	workspace := make(map[string]*Blob, len(e.weights)+len(inputs))
+	for name, blob := range e.weights {
+		workspace[name] = blob
+	}
+	for _, name := range e.Inputs() {
+		blob, ok := inputs[name]
+		if !ok {
+			return nil, errors.Errorf("missing input %s", name)
+		}
+		if int64(len(blob.Data)) != blob.Size() {
+			return nil, errors.Errorf("input %s has %d elements but its dimensions %v need %d", name, len(blob.Data), blob.Dims, blob.Size())
+		}
+		workspace[name] = blob
+	}
+
+	for ii, op := range e.net.GetOp() {
+		in := make([]*Blob, len(op.GetInput()))
+		for jj, name := range op.GetInput() {
+			blob, ok := workspace[name]
+			if !ok {
+				return nil, errors.Errorf("blob %s used by the %s operator %d is not defined", name, op.GetType(), ii)
+			}
+			in[jj] = blob
+		}
+		out, err := operators[op.GetType()](op, in)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to run the %s operator %d (%s)", op.GetType(), ii, op.GetName())
+		}
+		for jj, name := range op.GetOutput() {
+			if jj < len(out) {
+				workspace[name] = out[jj]
+			}
+		}
+	}
+
+	if len(outputs) == 0 {
+		outputs = e.Outputs()
+	}
+	res := make(map[string]*Blob, len(outputs))
+	for _, name := range outputs {
+		blob, ok := workspace[name]
+		if !ok {
+			return nil, errors.Errorf("blob %s is not defined", name)
+		}
+		res[name] = blob
+	}
+	return res, nil
+}
+
+func size(dims []int64) int64 {
+	res := int64(1)
+	for _, dim := range dims {
+		res *= dim
+	}
+	return res
+}
+
+func unique(vals []string) []string {
+	seen := map[string]bool{}
+	var res []string
+	for _, val := range vals {
+		if !seen[val] {
+			seen[val] = true
+			res = append(res, val)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
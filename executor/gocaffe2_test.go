@@ -0,0 +1,119 @@
+//go:build !nogocaffe2
+// +build !nogocaffe2
+
+package executor
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rai-project/caffe2"
+	"github.com/rai-project/dlframework/framework/options"
+	gocaffe2 "github.com/rai-project/go-caffe2"
+)
+
+// TestCompareGocaffe2 runs a small classifier with the executor and with the
+// caffe2 library, it is skipped when the library cannot load the model.
+func TestCompareGocaffe2(t *testing.T) {
+	const channels, side, classes = 3, 8, 5
+
+	filled := func(name string, dims ...int64) *caffe2.OperatorDef {
+		values := make([]float32, size(dims))
+		for ii := range values {
+			values[ii] = float32(math.Sin(float64(ii+len(name)))) / 4
+		}
+		return &caffe2.OperatorDef{Type: "GivenTensorFill", Output: []string{name}, Arg: []*caffe2.Argument{
+			{Name: "shape", Ints: dims},
+			{Name: "values", Floats: values},
+		}}
+	}
+	init := &caffe2.NetDef{
+		Name: "compare_init",
+		Op: []*caffe2.OperatorDef{
+			filled("conv_w", 4, channels, 3, 3),
+			filled("conv_b", 4),
+			filled("fc_w", classes, 4*side/2*side/2),
+			filled("fc_b", classes),
+		},
+	}
+	net := &caffe2.NetDef{
+		Name:          "compare",
+		ExternalInput: []string{"data", "conv_w", "conv_b", "fc_w", "fc_b"},
+		Op: []*caffe2.OperatorDef{
+			op("Conv", intArg("kernel", 3), intArg("pad", 1)),
+			op("Relu"),
+			op("MaxPool", intArg("kernel", 2), intArg("stride", 2)),
+			op("LRN", intArg("size", 3), floatArg("alpha", 1e-2), floatArg("beta", 0.75)),
+			op("FC"),
+			op("Softmax"),
+		},
+		ExternalOutput: []string{"prob"},
+	}
+	blobs := [][2][]string{
+		{{"data", "conv_w", "conv_b"}, {"conv"}},
+		{{"conv"}, {"conv"}},
+		{{"conv"}, {"pool"}},
+		{{"pool"}, {"norm"}},
+		{{"norm", "fc_w", "fc_b"}, {"fc"}},
+		{{"fc"}, {"prob"}},
+	}
+	for ii, io := range blobs {
+		net.Op[ii].Input, net.Op[ii].Output = io[0], io[1]
+	}
+
+	dir, err := ioutil.TempDir("", "executor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	graphPath, weightsPath := filepath.Join(dir, "predict_net.pb"), filepath.Join(dir, "init_net.pb")
+	for path, msg := range map[string]*caffe2.NetDef{graphPath: net, weightsPath: init} {
+		buf, err := msg.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pred, err := gocaffe2.New(
+		options.Graph([]byte(graphPath)),
+		options.Weights([]byte(weightsPath)),
+		options.BatchSize(1),
+	)
+	if err != nil {
+		t.Skipf("the caffe2 library cannot load the model: %v", err)
+	}
+	defer pred.Close()
+
+	input := NewBlob(1, channels, side, side)
+	for ii := range input.Data {
+		input.Data[ii] = float32(ii%17) / 17
+	}
+	predictions, err := pred.Predict(input.Data, 1, channels, side, side)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exec, err := New(net, init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := exec.Run(map[string]*Blob{"data": input})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := res["prob"].Data
+	if len(predictions) != len(want) {
+		t.Fatalf("caffe2 returned %d predictions, want %d", len(predictions), len(want))
+	}
+	for _, p := range predictions {
+		if p.Index < 0 || p.Index >= len(want) || math.Abs(float64(p.Probability-want[p.Index])) > 1e-4 {
+			t.Errorf("caffe2 probability of %d = %v, the executor computes %v", p.Index, p.Probability, want)
+		}
+	}
+}
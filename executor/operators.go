@@ -0,0 +1,519 @@
+package executor
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+)
+
+type operatorFunc func(op *caffe2.OperatorDef, inputs []*Blob) ([]*Blob, error)
+
+var operators map[string]operatorFunc
+
+func init() {
+	operators = map[string]operatorFunc{
+		"Conv":        runConv,
+		"FC":          runFC,
+		"Relu":        runRelu,
+		"MaxPool":     runMaxPool,
+		"AveragePool": runAveragePool,
+		"SpatialBN":   runSpatialBN,
+		"Concat":      runConcat,
+		"Sum":         runSum,
+		"Softmax":     runSoftmax,
+		"LRN":         runLRN,
+		"Dropout":     runDropout,
+	}
+}
+
+func checkInputs(inputs []*Blob, min int) error {
+	if len(inputs) < min {
+		return errors.Errorf("expecting at least %d inputs, got %d", min, len(inputs))
+	}
+	return nil
+}
+
+// windowArgs parses the window arguments of a 4D NCHW input and returns them
+// with the output spatial size and the effective pads. The kernel of a Conv
+// defaults to the size of its filter w.
+func windowArgs(op *caffe2.OperatorDef, x, w *Blob) (*caffe2.ConvPoolArgs, []int64, []int64, error) {
+	if len(x.Dims) != 4 {
+		return nil, nil, nil, errors.Errorf("expecting a 4D input, got %v", x.Dims)
+	}
+	var (
+		args *caffe2.ConvPoolArgs
+		err  error
+	)
+	if w != nil {
+		args, err = caffe2.ParseConvArgs(op, w.Dims)
+	} else {
+		args, err = caffe2.ParseConvPoolArgs(op)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if args.Order != "NCHW" {
+		return nil, nil, nil, errors.Errorf("unsupported order %s", args.Order)
+	}
+	if args.GlobalPooling {
+		args.Kernel = []int64{x.Dims[2], x.Dims[3]}
+	}
+	out, pads, err := args.OutputSize(x.Dims[2:])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return args, out, pads, nil
+}
+
+func runConv(op *caffe2.OperatorDef, inputs []*Blob) ([]*Blob, error) {
+	if err := checkInputs(inputs, 2); err != nil {
+		return nil, err
+	}
+	x, w := inputs[0], inputs[1]
+	args, outSize, pads, err := windowArgs(op, x, w)
+	if err != nil {
+		return nil, err
+	}
+	if len(w.Dims) != 4 {
+		return nil, errors.Errorf("expecting a 4D filter, got %v", w.Dims)
+	}
+
+	n, c, h, wd := int(x.Dims[0]), int(x.Dims[1]), int(x.Dims[2]), int(x.Dims[3])
+	m, kh, kw := int(w.Dims[0]), int(w.Dims[2]), int(w.Dims[3])
+	group := int(args.Group)
+	if c%group != 0 || m%group != 0 || int(w.Dims[1])*group != c {
+		return nil, errors.Errorf("filter %v does not match %d input channels in %d groups", w.Dims, c, group)
+	}
+	if int64(kh) != args.Kernel[0] || int64(kw) != args.Kernel[1] {
+		return nil, errors.Errorf("filter %v does not match the kernel %v", w.Dims, args.Kernel)
+	}
+	var bias []float32
+	if len(inputs) > 2 {
+		if inputs[2].Size() != int64(m) {
+			return nil, errors.Errorf("bias %v does not match %d output channels", inputs[2].Dims, m)
+		}
+		bias = inputs[2].Data
+	}
+
+	oh, ow := int(outSize[0]), int(outSize[1])
+	sh, sw := int(args.Stride[0]), int(args.Stride[1])
+	dh, dw := int(args.Dilation[0]), int(args.Dilation[1])
+	pt, pl := int(pads[0]), int(pads[1])
+	cpg, mpg := c/group, m/group
+
+	y := NewBlob(int64(n), int64(m), int64(oh), int64(ow))
+	for in := 0; in < n; in++ {
+		for g := 0; g < group; g++ {
+			for om := 0; om < mpg; om++ {
+				mm := g*mpg + om
+				yplane := y.Data[(in*m+mm)*oh*ow : (in*m+mm+1)*oh*ow]
+				if bias != nil {
+					for ii := range yplane {
+						yplane[ii] = bias[mm]
+					}
+				}
+				for ic := 0; ic < cpg; ic++ {
+					cc := g*cpg + ic
+					xplane := x.Data[(in*c+cc)*h*wd : (in*c+cc+1)*h*wd]
+					filter := w.Data[(mm*cpg+ic)*kh*kw : (mm*cpg+ic+1)*kh*kw]
+					for ki := 0; ki < kh; ki++ {
+						for kj := 0; kj < kw; kj++ {
+							weight := filter[ki*kw+kj]
+							for oi := 0; oi < oh; oi++ {
+								ih := oi*sh - pt + ki*dh
+								if ih < 0 || ih >= h {
+									continue
+								}
+								xrow := xplane[ih*wd : (ih+1)*wd]
+								yrow := yplane[oi*ow : (oi+1)*ow]
+								for oj := range yrow {
+									iw := oj*sw - pl + kj*dw
+									if iw >= 0 && iw < wd {
+										yrow[oj] += weight * xrow[iw]
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return []*Blob{y}, nil
+}
+
+func runFC(op *caffe2.OperatorDef, inputs []*Blob) ([]*Blob, error) {
+	if err := checkInputs(inputs, 3); err != nil {
+		return nil, err
+	}
+	x, w, b := inputs[0], inputs[1], inputs[2]
+	axis := canonicalAxis(op.GetArgInt("axis", 1), len(x.Dims))
+	axisW := canonicalAxis(op.GetArgInt("axis_w", 1), len(w.Dims))
+	if axis < 0 || axis > len(x.Dims) || axisW < 0 || axisW > len(w.Dims) {
+		return nil, errors.Errorf("invalid axis for input %v and weights %v", x.Dims, w.Dims)
+	}
+	m, k := int(size(x.Dims[:axis])), int(size(x.Dims[axis:]))
+	n := int(size(w.Dims[:axisW]))
+	if int(size(w.Dims[axisW:])) != k {
+		return nil, errors.Errorf("weights %v do not match input %v", w.Dims, x.Dims)
+	}
+	if int(b.Size()) != n {
+		return nil, errors.Errorf("bias %v does not match %d outputs", b.Dims, n)
+	}
+
+	dims := append(append([]int64{}, x.Dims[:axis]...), int64(n))
+	y := NewBlob(dims...)
+	for ii := 0; ii < m; ii++ {
+		xrow := x.Data[ii*k : (ii+1)*k]
+		for jj := 0; jj < n; jj++ {
+			wrow := w.Data[jj*k : (jj+1)*k]
+			sum := b.Data[jj]
+			for kk, v := range xrow {
+				sum += v * wrow[kk]
+			}
+			y.Data[ii*n+jj] = sum
+		}
+	}
+	return []*Blob{y}, nil
+}
+
+func runRelu(op *caffe2.OperatorDef, inputs []*Blob) ([]*Blob, error) {
+	if err := checkInputs(inputs, 1); err != nil {
+		return nil, err
+	}
+	x := inputs[0]
+	y := NewBlob(x.Dims...)
+	for ii, v := range x.Data {
+		if v > 0 {
+			y.Data[ii] = v
+		}
+	}
+	return []*Blob{y}, nil
+}
+
+func runMaxPool(op *caffe2.OperatorDef, inputs []*Blob) ([]*Blob, error) {
+	return runPool(op, inputs, true)
+}
+
+func runAveragePool(op *caffe2.OperatorDef, inputs []*Blob) ([]*Blob, error) {
+	return runPool(op, inputs, false)
+}
+
+func runPool(op *caffe2.OperatorDef, inputs []*Blob, max bool) ([]*Blob, error) {
+	if err := checkInputs(inputs, 1); err != nil {
+		return nil, err
+	}
+	x := inputs[0]
+	args, outSize, pads, err := windowArgs(op, x, nil)
+	if err != nil {
+		return nil, err
+	}
+	if args.Dilation[0] != 1 || args.Dilation[1] != 1 {
+		return nil, errors.New("dilated pooling is not supported")
+	}
+	countIncludePad := op.GetArgInt("count_include_pad", 0) != 0
+
+	n, c, h, w := int(x.Dims[0]), int(x.Dims[1]), int(x.Dims[2]), int(x.Dims[3])
+	oh, ow := int(outSize[0]), int(outSize[1])
+	kh, kw := int(args.Kernel[0]), int(args.Kernel[1])
+	sh, sw := int(args.Stride[0]), int(args.Stride[1])
+	pt, pl, pb, pr := int(pads[0]), int(pads[1]), int(pads[2]), int(pads[3])
+
+	y := NewBlob(int64(n), int64(c), int64(oh), int64(ow))
+	for plane := 0; plane < n*c; plane++ {
+		xplane := x.Data[plane*h*w : (plane+1)*h*w]
+		yplane := y.Data[plane*oh*ow : (plane+1)*oh*ow]
+		for oi := 0; oi < oh; oi++ {
+			hstart := oi*sh - pt
+			hend := minInt(hstart+kh, h+pb)
+			hsize := hend - hstart
+			hstart, hend = maxInt(hstart, 0), minInt(hend, h)
+			for oj := 0; oj < ow; oj++ {
+				wstart := oj*sw - pl
+				wend := minInt(wstart+kw, w+pr)
+				wsize := wend - wstart
+				wstart, wend = maxInt(wstart, 0), minInt(wend, w)
+
+				if max {
+					res := float32(math.Inf(-1))
+					for ii := hstart; ii < hend; ii++ {
+						for jj := wstart; jj < wend; jj++ {
+							if v := xplane[ii*w+jj]; v > res {
+								res = v
+							}
+						}
+					}
+					yplane[oi*ow+oj] = res
+					continue
+				}
+
+				sum := float32(0)
+				for ii := hstart; ii < hend; ii++ {
+					for jj := wstart; jj < wend; jj++ {
+						sum += xplane[ii*w+jj]
+					}
+				}
+				count := (hend - hstart) * (wend - wstart)
+				if countIncludePad {
+					count = hsize * wsize
+				}
+				if count > 0 {
+					yplane[oi*ow+oj] = sum / float32(count)
+				}
+			}
+		}
+	}
+	return []*Blob{y}, nil
+}
+
+func runSpatialBN(op *caffe2.OperatorDef, inputs []*Blob) ([]*Blob, error) {
+	if op.GetArgInt("is_test", 0) == 0 {
+		return nil, errors.New("only the test mode is supported")
+	}
+	if err := checkInputs(inputs, 5); err != nil {
+		return nil, err
+	}
+	x, scale, bias, mean, variance := inputs[0], inputs[1], inputs[2], inputs[3], inputs[4]
+	if len(x.Dims) < 2 {
+		return nil, errors.Errorf("expecting at least a 2D input, got %v", x.Dims)
+	}
+	epsilon := float64(op.GetArgFloat("epsilon", 1e-5))
+	nhwc := op.GetArgString("order", "NCHW") == "NHWC"
+
+	channelAxis := 1
+	if nhwc {
+		channelAxis = len(x.Dims) - 1
+	}
+	c := int(x.Dims[channelAxis])
+	for _, param := range []*Blob{scale, bias, mean, variance} {
+		if int(param.Size()) != c {
+			return nil, errors.Errorf("parameter %v does not match %d channels", param.Dims, c)
+		}
+	}
+	inner := int(size(x.Dims[channelAxis+1:]))
+
+	// fold the normalization into a per channel multiplier and offset
+	alpha := make([]float32, c)
+	beta := make([]float32, c)
+	for ii := range alpha {
+		inv := 1 / math.Sqrt(float64(variance.Data[ii])+epsilon)
+		alpha[ii] = float32(float64(scale.Data[ii]) * inv)
+		beta[ii] = float32(float64(bias.Data[ii]) - float64(mean.Data[ii])*float64(scale.Data[ii])*inv)
+	}
+
+	y := NewBlob(x.Dims...)
+	for ii, v := range x.Data {
+		ch := (ii / inner) % c
+		y.Data[ii] = v*alpha[ch] + beta[ch]
+	}
+	return []*Blob{y}, nil
+}
+
+func runConcat(op *caffe2.OperatorDef, inputs []*Blob) ([]*Blob, error) {
+	if err := checkInputs(inputs, 1); err != nil {
+		return nil, err
+	}
+	defaultAxis := int64(1)
+	if op.GetArgString("order", "NCHW") == "NHWC" {
+		defaultAxis = 3
+	}
+	addAxis := op.GetArgInt("add_axis", 0) != 0
+	first := inputs[0]
+	rank := len(first.Dims)
+	if addAxis {
+		rank++
+	}
+	axis := canonicalAxis(op.GetArgInt("axis", defaultAxis), rank)
+	if axis < 0 || axis >= rank {
+		return nil, errors.Errorf("invalid axis %d for inputs of rank %d", axis, len(first.Dims))
+	}
+
+	// view every input as [outer, width * inner]
+	outer := int(size(first.Dims[:axis]))
+	inner := 1
+	if !addAxis {
+		inner = int(size(first.Dims[axis+1:]))
+	}
+	splits := NewBlob(int64(len(inputs)))
+	total := 0
+	for ii, input := range inputs {
+		if len(input.Dims) != len(first.Dims) {
+			return nil, errors.Errorf("input %d has dimensions %v, expecting the rank of %v", ii, input.Dims, first.Dims)
+		}
+		width := 1
+		for jj, dim := range input.Dims {
+			switch {
+			case jj == axis && !addAxis:
+				width = int(dim)
+			case dim != first.Dims[jj]:
+				return nil, errors.Errorf("input %d has dimensions %v incompatible with %v", ii, input.Dims, first.Dims)
+			}
+		}
+		splits.Data[ii] = float32(width)
+		total += width
+	}
+
+	var dims []int64
+	if addAxis {
+		dims = append(dims, first.Dims[:axis]...)
+		dims = append(dims, int64(len(inputs)))
+		dims = append(dims, first.Dims[axis:]...)
+		inner = int(size(first.Dims[axis:]))
+	} else {
+		dims = append([]int64{}, first.Dims...)
+		dims[axis] = int64(total)
+	}
+	y := NewBlob(dims...)
+	offset := 0
+	for ii, input := range inputs {
+		chunk := int(splits.Data[ii]) * inner
+		for jj := 0; jj < outer; jj++ {
+			copy(y.Data[jj*total*inner+offset:], input.Data[jj*chunk:(jj+1)*chunk])
+		}
+		offset += chunk
+	}
+	return []*Blob{y, splits}, nil
+}
+
+func runSum(op *caffe2.OperatorDef, inputs []*Blob) ([]*Blob, error) {
+	if err := checkInputs(inputs, 1); err != nil {
+		return nil, err
+	}
+	first := inputs[0]
+	y := NewBlob(first.Dims...)
+	for ii, input := range inputs {
+		if !equalDims(input.Dims, first.Dims) {
+			return nil, errors.Errorf("input %d has dimensions %v, expecting %v", ii, input.Dims, first.Dims)
+		}
+		for jj, v := range input.Data {
+			y.Data[jj] += v
+		}
+	}
+	return []*Blob{y}, nil
+}
+
+func runSoftmax(op *caffe2.OperatorDef, inputs []*Blob) ([]*Blob, error) {
+	if err := checkInputs(inputs, 1); err != nil {
+		return nil, err
+	}
+	x := inputs[0]
+	axis := canonicalAxis(op.GetArgInt("axis", 1), len(x.Dims))
+	if axis < 0 || axis > len(x.Dims) {
+		return nil, errors.Errorf("invalid axis for input %v", x.Dims)
+	}
+	d := int(size(x.Dims[axis:]))
+	y := NewBlob(x.Dims...)
+	if d == 0 {
+		return []*Blob{y}, nil
+	}
+	for start := 0; start < len(x.Data); start += d {
+		xrow, yrow := x.Data[start:start+d], y.Data[start:start+d]
+		max := xrow[0]
+		for _, v := range xrow {
+			if v > max {
+				max = v
+			}
+		}
+		sum := float64(0)
+		for ii, v := range xrow {
+			e := math.Exp(float64(v - max))
+			yrow[ii] = float32(e)
+			sum += e
+		}
+		for ii := range yrow {
+			yrow[ii] = float32(float64(yrow[ii]) / sum)
+		}
+	}
+	return []*Blob{y}, nil
+}
+
+func runLRN(op *caffe2.OperatorDef, inputs []*Blob) ([]*Blob, error) {
+	if err := checkInputs(inputs, 1); err != nil {
+		return nil, err
+	}
+	x := inputs[0]
+	if len(x.Dims) != 4 {
+		return nil, errors.Errorf("expecting a 4D input, got %v", x.Dims)
+	}
+	if op.GetArgString("order", "NCHW") != "NCHW" {
+		return nil, errors.New("only the NCHW order is supported")
+	}
+	windowSize := int(op.GetArgInt("size", 0))
+	if windowSize <= 0 {
+		return nil, errors.New("missing the size argument")
+	}
+	alpha := float64(op.GetArgFloat("alpha", 0))
+	beta := float64(op.GetArgFloat("beta", 0))
+	bias := float64(op.GetArgFloat("bias", 1))
+	prePad := (windowSize - 1) / 2
+
+	n, c := int(x.Dims[0]), int(x.Dims[1])
+	plane := int(x.Dims[2] * x.Dims[3])
+	y := NewBlob(x.Dims...)
+	scale := NewBlob(x.Dims...)
+	for in := 0; in < n; in++ {
+		for ic := 0; ic < c; ic++ {
+			offset := (in*c + ic) * plane
+			for ii := 0; ii < plane; ii++ {
+				sum := float64(0)
+				for cc := maxInt(ic-prePad, 0); cc < minInt(ic-prePad+windowSize, c); cc++ {
+					v := float64(x.Data[(in*c+cc)*plane+ii])
+					sum += v * v
+				}
+				s := bias + alpha/float64(windowSize)*sum
+				scale.Data[offset+ii] = float32(s)
+				y.Data[offset+ii] = float32(float64(x.Data[offset+ii]) * math.Pow(s, -beta))
+			}
+		}
+	}
+	return []*Blob{y, scale}, nil
+}
+
+func runDropout(op *caffe2.OperatorDef, inputs []*Blob) ([]*Blob, error) {
+	if op.GetArgInt("is_test", 0) == 0 {
+		return nil, errors.New("only the test mode is supported")
+	}
+	if err := checkInputs(inputs, 1); err != nil {
+		return nil, err
+	}
+	x := inputs[0]
+	mask := NewBlob(x.Dims...)
+	for ii := range mask.Data {
+		mask.Data[ii] = 1
+	}
+	return []*Blob{x, mask}, nil
+}
+
+func canonicalAxis(axis int64, rank int) int {
+	if axis < 0 {
+		return int(axis) + rank
+	}
+	return int(axis)
+}
+
+func equalDims(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for ii := range a {
+		if a[ii] != b[ii] {
+			return false
+		}
+	}
+	return true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
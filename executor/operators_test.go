@@ -0,0 +1,226 @@
+package executor
+
+import (
+	"math"
+	"testing"
+
+	"github.com/rai-project/caffe2"
+)
+
+func blob(dims []int64, data ...float32) *Blob {
+	return &Blob{Dims: dims, Data: data}
+}
+
+func op(typ string, args ...*caffe2.Argument) *caffe2.OperatorDef {
+	return &caffe2.OperatorDef{Type: typ, Arg: args}
+}
+
+func intArg(name string, i int64) *caffe2.Argument {
+	return &caffe2.Argument{Name: name, I: i}
+}
+
+func floatArg(name string, f float32) *caffe2.Argument {
+	return &caffe2.Argument{Name: name, F: f}
+}
+
+func checkBlob(t *testing.T, name string, got *Blob, dims []int64, want ...float32) {
+	if !equalDims(got.Dims, dims) {
+		t.Errorf("%s: dimensions = %v, want %v", name, got.Dims, dims)
+		return
+	}
+	if len(got.Data) != len(want) {
+		t.Errorf("%s: %d elements, want %d", name, len(got.Data), len(want))
+		return
+	}
+	for ii, v := range got.Data {
+		if math.Abs(float64(v-want[ii])) > 1e-5 {
+			t.Errorf("%s: %v, want %v", name, got.Data, want)
+			return
+		}
+	}
+}
+
+func run(t *testing.T, name string, o *caffe2.OperatorDef, inputs ...*Blob) []*Blob {
+	outputs, err := operators[o.GetType()](o, inputs)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return outputs
+}
+
+// image3x3 holds 1 to 9 in row major order.
+func image3x3() *Blob {
+	return blob([]int64{1, 1, 3, 3}, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+}
+
+func ones(dims ...int64) *Blob {
+	b := NewBlob(dims...)
+	for ii := range b.Data {
+		b.Data[ii] = 1
+	}
+	return b
+}
+
+func TestConv(t *testing.T) {
+	w, b := ones(1, 1, 3, 3), blob([]int64{1}, 1)
+	// every output sums the 3x3 neighborhood, the padding adds zeros
+	out := run(t, "padded", op("Conv", intArg("kernel", 3), intArg("pad", 1)), image3x3(), w, b)
+	checkBlob(t, "padded", out[0], []int64{1, 1, 3, 3}, 13, 22, 17, 28, 46, 34, 25, 40, 29)
+
+	out = run(t, "strided", op("Conv", intArg("kernel", 3), intArg("pad", 1), intArg("stride", 2)), image3x3(), w)
+	checkBlob(t, "strided", out[0], []int64{1, 1, 2, 2}, 12, 16, 24, 28)
+
+	out = run(t, "valid", op("Conv", intArg("kernel", 2)), image3x3(), ones(1, 1, 2, 2))
+	checkBlob(t, "valid", out[0], []int64{1, 1, 2, 2}, 12, 16, 24, 28)
+
+	// the kernel defaults to the size of the filter
+	out = run(t, "filter kernel", op("Conv", intArg("pad", 1)), image3x3(), w, b)
+	checkBlob(t, "filter kernel", out[0], []int64{1, 1, 3, 3}, 13, 22, 17, 28, 46, 34, 25, 40, 29)
+
+	x := blob([]int64{1, 2, 1, 2}, 1, 2, 3, 4)
+	out = run(t, "grouped", op("Conv", intArg("kernel", 1), intArg("group", 2)), x, blob([]int64{2, 1, 1, 1}, 2, 3))
+	checkBlob(t, "grouped", out[0], []int64{1, 2, 1, 2}, 2, 4, 9, 12)
+
+	if _, err := runConv(op("Conv", intArg("kernel", 2)), []*Blob{image3x3(), w}); err == nil {
+		t.Error("Conv with a filter that does not match the kernel succeeded")
+	}
+}
+
+func TestPool(t *testing.T) {
+	x := NewBlob(1, 1, 4, 4)
+	for ii := range x.Data {
+		x.Data[ii] = float32(ii + 1)
+	}
+	out := run(t, "max", op("MaxPool", intArg("kernel", 2), intArg("stride", 2)), x)
+	checkBlob(t, "max", out[0], []int64{1, 1, 2, 2}, 6, 8, 14, 16)
+
+	padded := op("MaxPool", intArg("kernel", 2), intArg("stride", 2), intArg("pad", 1))
+	out = run(t, "padded max", padded, image3x3())
+	checkBlob(t, "padded max", out[0], []int64{1, 1, 2, 2}, 1, 3, 7, 9)
+
+	// the padding is not counted by default
+	padded.Type = "AveragePool"
+	out = run(t, "padded average", padded, image3x3())
+	checkBlob(t, "padded average", out[0], []int64{1, 1, 2, 2}, 1, 2.5, 5.5, 7)
+
+	padded.Arg = append(padded.Arg, intArg("count_include_pad", 1))
+	out = run(t, "average including the padding", padded, image3x3())
+	checkBlob(t, "average including the padding", out[0], []int64{1, 1, 2, 2}, 0.25, 1.25, 2.75, 7)
+
+	out = run(t, "global", op("AveragePool", intArg("global_pooling", 1)), image3x3())
+	checkBlob(t, "global", out[0], []int64{1, 1, 1, 1}, 5)
+}
+
+func TestLRN(t *testing.T) {
+	x := blob([]int64{1, 3, 1, 1}, 1, 2, 3)
+	// scale = 1 + 3/3 * the sum of the squares of the neighboring channels
+	out := run(t, "lrn", op("LRN", intArg("size", 3), floatArg("alpha", 3), floatArg("beta", 1), floatArg("bias", 1)), x)
+	checkBlob(t, "lrn", out[0], []int64{1, 3, 1, 1}, 1.0/6, 2.0/15, 3.0/14)
+	checkBlob(t, "lrn scale", out[1], []int64{1, 3, 1, 1}, 6, 15, 14)
+
+	out = run(t, "lrn beta", op("LRN", intArg("size", 3), floatArg("alpha", 3), floatArg("beta", 0.5), floatArg("bias", 1)), x)
+	checkBlob(t, "lrn beta", out[0], []int64{1, 3, 1, 1}, float32(1/math.Sqrt(6)), float32(2/math.Sqrt(15)), float32(3/math.Sqrt(14)))
+
+	if _, err := runLRN(op("LRN"), []*Blob{x}); err == nil {
+		t.Error("LRN without size succeeded")
+	}
+}
+
+func TestSoftmax(t *testing.T) {
+	x := blob([]int64{2, 3}, 0, float32(math.Log(3)), float32(math.Log(4)), float32(math.Log(1)), float32(math.Log(2)), float32(math.Log(5)))
+	out := run(t, "softmax", op("Softmax"), x)
+	checkBlob(t, "softmax", out[0], []int64{2, 3}, 1.0/8, 3.0/8, 4.0/8, 1.0/8, 2.0/8, 5.0/8)
+
+	// large values do not overflow
+	out = run(t, "large", op("Softmax"), blob([]int64{1, 2}, 1000, 1000))
+	checkBlob(t, "large", out[0], []int64{1, 2}, 0.5, 0.5)
+}
+
+func TestFC(t *testing.T) {
+	x := blob([]int64{2, 3}, 1, 2, 3, 4, 5, 6)
+	w := blob([]int64{2, 3}, 1, 0, -1, 0.5, 0.5, 0.5)
+	b := blob([]int64{2}, 1, -1)
+	out := run(t, "fc", op("FC"), x, w, b)
+	checkBlob(t, "fc", out[0], []int64{2, 2}, -1, 2, -1, 6.5)
+
+	// the input is flattened from the axis
+	x4 := blob([]int64{2, 3, 1, 1}, 1, 2, 3, 4, 5, 6)
+	out = run(t, "fc 4D", op("FC"), x4, w, b)
+	checkBlob(t, "fc 4D", out[0], []int64{2, 2}, -1, 2, -1, 6.5)
+
+	if _, err := runFC(op("FC"), []*Blob{x, blob([]int64{2, 2}, 1, 2, 3, 4), b}); err == nil {
+		t.Error("FC with mismatched weights succeeded")
+	}
+}
+
+func TestElementwiseOperators(t *testing.T) {
+	x := blob([]int64{1, 4}, -1, 0, 2, -3)
+	out := run(t, "relu", op("Relu"), x)
+	checkBlob(t, "relu", out[0], []int64{1, 4}, 0, 0, 2, 0)
+
+	out = run(t, "sum", op("Sum"), x, x, x)
+	checkBlob(t, "sum", out[0], []int64{1, 4}, -3, 0, 6, -9)
+
+	out = run(t, "dropout", op("Dropout", intArg("is_test", 1)), x)
+	checkBlob(t, "dropout", out[0], []int64{1, 4}, -1, 0, 2, -3)
+
+	// y = (x - mean) / sqrt(var + eps) * scale + bias
+	bn := op("SpatialBN", intArg("is_test", 1), floatArg("epsilon", 0))
+	xbn := blob([]int64{1, 2, 1, 2}, 1, 3, 10, 20)
+	out = run(t, "spatial bn", bn, xbn, blob([]int64{2}, 2, 1), blob([]int64{2}, 0, 1), blob([]int64{2}, 1, 10), blob([]int64{2}, 4, 100))
+	checkBlob(t, "spatial bn", out[0], []int64{1, 2, 1, 2}, 0, 2, 1, 2)
+}
+
+func TestConcat(t *testing.T) {
+	a := blob([]int64{2, 1}, 1, 2)
+	b := blob([]int64{2, 2}, 3, 4, 5, 6)
+	out := run(t, "concat", op("Concat"), a, b)
+	checkBlob(t, "concat", out[0], []int64{2, 3}, 1, 3, 4, 2, 5, 6)
+	checkBlob(t, "concat splits", out[1], []int64{2}, 1, 2)
+
+	out = run(t, "concat add axis", op("Concat", intArg("axis", 0), intArg("add_axis", 1)), a, a)
+	checkBlob(t, "concat add axis", out[0], []int64{2, 2, 1}, 1, 2, 1, 2)
+}
+
+func TestExecutor(t *testing.T) {
+	net := &caffe2.NetDef{
+		ExternalInput: []string{"data", "w", "b"},
+		Op: []*caffe2.OperatorDef{
+			{Type: "FC", Input: []string{"data", "w", "b"}, Output: []string{"fc"}},
+			{Type: "Softmax", Input: []string{"fc"}, Output: []string{"prob"}},
+		},
+		ExternalOutput: []string{"prob"},
+	}
+	init := &caffe2.NetDef{
+		Op: []*caffe2.OperatorDef{
+			{Type: "GivenTensorFill", Output: []string{"w"}, Arg: []*caffe2.Argument{
+				{Name: "shape", Ints: []int64{2, 2}},
+				{Name: "values", Floats: []float32{1, 0, 0, 1}},
+			}},
+			{Type: "ConstantFill", Output: []string{"b"}, Arg: []*caffe2.Argument{
+				{Name: "shape", Ints: []int64{2}},
+			}},
+		},
+	}
+	exec, err := New(net, init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inputs := exec.Inputs(); len(inputs) != 1 || inputs[0] != "data" {
+		t.Errorf("Inputs = %v, want [data]", inputs)
+	}
+	res, err := exec.Run(map[string]*Blob{"data": blob([]int64{1, 2}, 0, float32(math.Log(3)))}, "prob", "fc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBlob(t, "prob", res["prob"], []int64{1, 2}, 0.25, 0.75)
+	checkBlob(t, "fc", res["fc"], []int64{1, 2}, 0, float32(math.Log(3)))
+
+	if _, err := exec.Run(nil); err == nil {
+		t.Error("Run without input succeeded")
+	}
+	net.Op = append(net.Op, &caffe2.OperatorDef{Type: "Custom"})
+	if _, err := New(net, init); err == nil {
+		t.Error("New with an unsupported operator succeeded")
+	}
+}
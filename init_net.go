@@ -0,0 +1,226 @@
+package caffe2
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// InitNetTensors extracts the tensors filled by the GivenTensor*Fill and
+// ConstantFill operators of an init net.
+func InitNetTensors(init *NetDef) (*TensorProtos, error) {
+	res := &TensorProtos{}
+	for _, op := range init.GetOp() {
+		for _, output := range op.GetOutput() {
+			tensor, err := FillOperatorTensor(op, output)
+			if err != nil {
+				return nil, err
+			}
+			res.Protos = append(res.Protos, tensor)
+		}
+	}
+	return res, nil
+}
+
+// FillOperatorTensor builds the tensor that a fill operator writes in the
+// named output.
+func FillOperatorTensor(op *OperatorDef, name string) (*TensorProto, error) {
+	values := op.GetArgument("values")
+	shape := op.GetArgInts("shape")
+	if !op.HasArgument("shape") {
+		switch {
+		case values == nil:
+			return nil, errors.Errorf("%s operator for %s has no shape", op.GetType(), name)
+		case values.GetS() != nil:
+			shape = []int64{int64(len(values.GetS()))}
+		default:
+			shape = []int64{int64(len(values.GetFloats()) + len(values.GetInts()) + len(values.GetStrings()))}
+		}
+	}
+
+	var (
+		tensor *TensorProto
+		err    error
+	)
+	switch op.GetType() {
+	case "GivenTensorFill":
+		tensor, err = NewTensorProto(name, shape, values.GetFloats())
+	case "GivenTensorDoubleFill":
+		data := make([]float64, len(values.GetFloats()))
+		for ii, v := range values.GetFloats() {
+			data[ii] = float64(v)
+		}
+		tensor, err = NewTensorProto(name, shape, data)
+	case "GivenTensorIntFill":
+		data := make([]int32, len(values.GetInts()))
+		for ii, v := range values.GetInts() {
+			data[ii] = int32(v)
+		}
+		tensor, err = NewTensorProto(name, shape, data)
+	case "GivenTensorInt16Fill":
+		data := make([]int16, len(values.GetInts()))
+		for ii, v := range values.GetInts() {
+			data[ii] = int16(v)
+		}
+		tensor, err = NewTensorProto(name, shape, data)
+	case "GivenTensorInt64Fill":
+		tensor, err = NewTensorProto(name, shape, values.GetInts())
+	case "GivenTensorBoolFill":
+		data := make([]bool, len(values.GetInts()))
+		for ii, v := range values.GetInts() {
+			data[ii] = v != 0
+		}
+		tensor, err = NewTensorProto(name, shape, data)
+	case "GivenTensorStringFill":
+		tensor, err = NewTensorProto(name, shape, values.GetStrings())
+	case "GivenTensorByteStringToUInt8Fill":
+		tensor, err = NewTensorProto(name, shape, values.GetS())
+	case "ConstantFill":
+		tensor, err = constantFillTensor(op, name, shape)
+	default:
+		return nil, errors.Errorf("unsupported fill operator %s for %s", op.GetType(), name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s operator for %s", op.GetType(), name)
+	}
+	return tensor, nil
+}
+
+func constantFillTensor(op *OperatorDef, name string, shape []int64) (*TensorProto, error) {
+	size := dimsProduct(shape)
+	if size < 0 {
+		return nil, errors.Errorf("invalid shape %v", shape)
+	}
+	dtype := TensorProto_DataType(op.GetArgInt("dtype", int64(TensorProto_FLOAT)))
+	value := op.GetArgument("value")
+	switch dtype {
+	case TensorProto_FLOAT:
+		data := make([]float32, size)
+		for ii := range data {
+			data[ii] = value.GetF()
+		}
+		return NewTensorProto(name, shape, data)
+	case TensorProto_DOUBLE:
+		data := make([]float64, size)
+		for ii := range data {
+			data[ii] = float64(value.GetF())
+		}
+		return NewTensorProto(name, shape, data)
+	case TensorProto_INT32:
+		data := make([]int32, size)
+		for ii := range data {
+			data[ii] = int32(value.GetI())
+		}
+		return NewTensorProto(name, shape, data)
+	case TensorProto_INT64:
+		data := make([]int64, size)
+		for ii := range data {
+			data[ii] = value.GetI()
+		}
+		return NewTensorProto(name, shape, data)
+	case TensorProto_BOOL:
+		data := make([]bool, size)
+		for ii := range data {
+			data[ii] = value.GetI() != 0
+		}
+		return NewTensorProto(name, shape, data)
+	}
+	return nil, errors.Errorf("unsupported ConstantFill dtype %v", dtype)
+}
+
+// NewInitNet builds an init net with one GivenTensor*Fill operator per
+// tensor, the inverse of InitNetTensors.
+func NewInitNet(name string, protos *TensorProtos) (*NetDef, error) {
+	protos, err := AssembleTensorProtos(protos)
+	if err != nil {
+		return nil, err
+	}
+	net := &NetDef{Name: name}
+	for _, tensor := range protos.GetProtos() {
+		op, err := fillOperator(tensor)
+		if err != nil {
+			return nil, err
+		}
+		net.Op = append(net.Op, op)
+	}
+	return net, nil
+}
+
+func fillOperator(tensor *TensorProto) (*OperatorDef, error) {
+	t, err := DecodeTensor(tensor)
+	if err != nil {
+		return nil, err
+	}
+	values := &Argument{Name: "values"}
+	op := &OperatorDef{
+		Output: []string{t.Name},
+		Arg: []*Argument{
+			{Name: "shape", Ints: append([]int64{}, t.Dims...)},
+			values,
+		},
+	}
+	switch data := t.Data.(type) {
+	case []float32:
+		// FLOAT16 tensors are widened to float, which is lossless
+		op.Type = "GivenTensorFill"
+		values.Floats = data
+	case []float64:
+		// the values of an Argument are float, the GivenTensorDoubleFill
+		// operator widens them
+		op.Type = "GivenTensorDoubleFill"
+		values.Floats = make([]float32, len(data))
+		for ii, v := range data {
+			f := float32(v)
+			if float64(f) != v && !math.IsNaN(v) {
+				return nil, errors.Errorf("tensor %s holds the double %v at %d that cannot be stored in a GivenTensorDoubleFill operator without loss", t.Name, v, ii)
+			}
+			values.Floats[ii] = f
+		}
+	case []int32:
+		op.Type = "GivenTensorIntFill"
+		values.Ints = make([]int64, len(data))
+		for ii, v := range data {
+			values.Ints[ii] = int64(v)
+		}
+	case []int16:
+		op.Type = "GivenTensorInt16Fill"
+		values.Ints = make([]int64, len(data))
+		for ii, v := range data {
+			values.Ints[ii] = int64(v)
+		}
+	case []int8:
+		// caffe2 has no int8 nor uint16 fill operator, they are widened to
+		// int32
+		op.Type = "GivenTensorIntFill"
+		values.Ints = make([]int64, len(data))
+		for ii, v := range data {
+			values.Ints[ii] = int64(v)
+		}
+	case []uint16:
+		op.Type = "GivenTensorIntFill"
+		values.Ints = make([]int64, len(data))
+		for ii, v := range data {
+			values.Ints[ii] = int64(v)
+		}
+	case []int64:
+		op.Type = "GivenTensorInt64Fill"
+		values.Ints = data
+	case []bool:
+		op.Type = "GivenTensorBoolFill"
+		values.Ints = make([]int64, len(data))
+		for ii, v := range data {
+			if v {
+				values.Ints[ii] = 1
+			}
+		}
+	case []string:
+		op.Type = "GivenTensorStringFill"
+		values.Strings = tensor.GetStringData()
+	case []uint8:
+		op.Type = "GivenTensorByteStringToUInt8Fill"
+		values.S = data
+	default:
+		return nil, errors.Errorf("tensor %s of type %v cannot be stored in an init net", t.Name, t.DataType)
+	}
+	return op, nil
+}
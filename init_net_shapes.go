@@ -0,0 +1,293 @@
+package caffe2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// maxShapeRank bounds the number of integers kept from an argument, only the
+// shape argument of the fill operators is needed.
+const maxShapeRank = 64
+
+// maxNameLength bounds the blob and argument names read from an init net.
+const maxNameLength = 1 << 16
+
+// ReadInitNetShapes reads the blobs filled by a serialized init net and their
+// shapes, as InitNetShapes does, without decoding the values of its fill
+// operators. This keeps the memory used by large models, such as VGG, to the
+// blob names. The blobs whose shape is not given map to nil.
+func ReadInitNetShapes(r io.Reader) (map[string][]int64, error) {
+	pr := &protoReader{r: bufio.NewReader(r)}
+	shapes := map[string][]int64{}
+	for {
+		field, wireType, err := pr.key()
+		if err == io.EOF {
+			return shapes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// the operators are the field 2 of a NetDef
+		if field != 2 || wireType != wireBytes {
+			if err := pr.skip(wireType); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		size, err := pr.varint()
+		if err != nil {
+			return nil, err
+		}
+		outputs, shape, err := pr.fillOperator(pr.n + int64(size))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid operator %d of the init net", len(shapes))
+		}
+		for _, output := range outputs {
+			shapes[output] = shape
+		}
+	}
+}
+
+// protoReader decodes the protobuf wire format, counting the bytes read so
+// that the end of the embedded messages is known.
+type protoReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (p *protoReader) ReadByte() (byte, error) {
+	b, err := p.r.ReadByte()
+	if err == nil {
+		p.n++
+	}
+	return b, err
+}
+
+func (p *protoReader) varint() (uint64, error) {
+	start := p.n
+	v, err := binary.ReadUvarint(p)
+	if err == io.EOF && p.n != start {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+// key reads the field number and wire type of the next field. It returns
+// io.EOF only at the end of the input.
+func (p *protoReader) key() (uint64, uint64, error) {
+	key, err := p.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return key >> 3, key & 7, nil
+}
+
+func (p *protoReader) discard(n int64) error {
+	for n > 0 {
+		chunk := n
+		if chunk > 1<<30 {
+			chunk = 1 << 30
+		}
+		d, err := p.r.Discard(int(chunk))
+		p.n += int64(d)
+		n -= int64(d)
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return nil
+}
+
+// string reads a length delimited field of at most maxNameLength bytes.
+func (p *protoReader) string() (string, error) {
+	size, err := p.varint()
+	if err != nil {
+		return "", err
+	}
+	if size > maxNameLength {
+		return "", errors.Errorf("name of %d bytes", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return "", io.ErrUnexpectedEOF
+	}
+	p.n += int64(size)
+	return string(buf), nil
+}
+
+func (p *protoReader) skip(wireType uint64) error {
+	switch wireType {
+	case wireVarint:
+		_, err := p.varint()
+		return err
+	case wireFixed64:
+		return p.discard(8)
+	case wireBytes:
+		size, err := p.varint()
+		if err != nil {
+			return err
+		}
+		return p.discard(int64(size))
+	case wireFixed32:
+		return p.discard(4)
+	}
+	return errors.Errorf("unsupported wire type %d", wireType)
+}
+
+// fillOperator reads the outputs of an OperatorDef ending at end, and the
+// shape given by its shape argument or by the number of its values.
+func (p *protoReader) fillOperator(end int64) ([]string, []int64, error) {
+	var (
+		outputs []string
+		shape   []int64
+		values  int64 = -1
+	)
+	for p.n < end {
+		field, wireType, err := p.key()
+		if err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+		switch {
+		case field == 2 && wireType == wireBytes:
+			output, err := p.string()
+			if err != nil {
+				return nil, nil, unexpectedEOF(err)
+			}
+			outputs = append(outputs, output)
+		case field == 5 && wireType == wireBytes:
+			size, err := p.varint()
+			if err != nil {
+				return nil, nil, unexpectedEOF(err)
+			}
+			name, ints, count, err := p.argument(p.n + int64(size))
+			if err != nil {
+				return nil, nil, err
+			}
+			switch name {
+			case "shape":
+				if count > maxShapeRank {
+					return nil, nil, errors.Errorf("shape argument of rank %d", count)
+				}
+				shape = ints
+				if shape == nil {
+					shape = []int64{}
+				}
+			case "values":
+				values = count
+			}
+		default:
+			if err := p.skip(wireType); err != nil {
+				return nil, nil, unexpectedEOF(err)
+			}
+		}
+	}
+	if p.n != end {
+		return nil, nil, errors.New("truncated operator")
+	}
+	if shape == nil && values >= 0 {
+		shape = []int64{values}
+	}
+	return outputs, shape, nil
+}
+
+// argument reads the name of an Argument ending at end, its first
+// maxShapeRank integers and the number of values it holds.
+func (p *protoReader) argument(end int64) (string, []int64, int64, error) {
+	var (
+		name  string
+		ints  []int64
+		count int64
+	)
+	addInt := func() error {
+		v, err := p.varint()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if len(ints) < maxShapeRank {
+			ints = append(ints, int64(v))
+		}
+		count++
+		return nil
+	}
+	for p.n < end {
+		field, wireType, err := p.key()
+		if err != nil {
+			return "", nil, 0, unexpectedEOF(err)
+		}
+		switch {
+		case field == 1 && wireType == wireBytes:
+			if name, err = p.string(); err != nil {
+				return "", nil, 0, unexpectedEOF(err)
+			}
+		case field == 4 && wireType == wireBytes:
+			// the bytes of a GivenTensorByteStringToUInt8Fill
+			size, err := p.varint()
+			if err != nil {
+				return "", nil, 0, unexpectedEOF(err)
+			}
+			count += int64(size)
+			if err := p.discard(int64(size)); err != nil {
+				return "", nil, 0, err
+			}
+		case field == 5 && wireType == wireBytes:
+			size, err := p.varint()
+			if err != nil {
+				return "", nil, 0, unexpectedEOF(err)
+			}
+			count += int64(size) / 4
+			if err := p.discard(int64(size)); err != nil {
+				return "", nil, 0, err
+			}
+		case field == 5 && wireType == wireFixed32:
+			count++
+			if err := p.discard(4); err != nil {
+				return "", nil, 0, err
+			}
+		case field == 6 && wireType == wireBytes:
+			size, err := p.varint()
+			if err != nil {
+				return "", nil, 0, unexpectedEOF(err)
+			}
+			for packedEnd := p.n + int64(size); p.n < packedEnd; {
+				if err := addInt(); err != nil {
+					return "", nil, 0, err
+				}
+			}
+		case field == 6 && wireType == wireVarint:
+			if err := addInt(); err != nil {
+				return "", nil, 0, err
+			}
+		case field == 7 && wireType == wireBytes:
+			count++
+			if err := p.skip(wireType); err != nil {
+				return "", nil, 0, unexpectedEOF(err)
+			}
+		default:
+			if err := p.skip(wireType); err != nil {
+				return "", nil, 0, unexpectedEOF(err)
+			}
+		}
+	}
+	if p.n != end {
+		return "", nil, 0, errors.New("truncated argument")
+	}
+	return name, ints, count, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
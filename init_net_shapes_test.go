@@ -0,0 +1,43 @@
+package caffe2
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReadInitNetShapes(t *testing.T) {
+	init := testInitNet()
+	init.Op = append(init.Op,
+		newOp("GivenTensorIntFill", nil, []string{"ids"}, intsArg("values", 1, 2, 3)),
+		newOp("UniformFill", nil, []string{"noise"}, floatArg("min", -1)),
+	)
+	buf, err := init.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shapes, err := ReadInitNetShapes(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]int64{
+		"conv_w": {4, 3, 3, 3},
+		"conv_b": {4},
+		"fc_w":   {10, 64},
+		"fc_b":   {10},
+		"ids":    {3},
+		"noise":  nil,
+	}
+	if !reflect.DeepEqual(shapes, want) {
+		t.Errorf("ReadInitNetShapes = %v, want %v", shapes, want)
+	}
+
+	for _, n := range []int{1, len(buf) / 2, len(buf) - 1} {
+		if _, err := ReadInitNetShapes(bytes.NewReader(buf[:n])); err == nil {
+			t.Errorf("ReadInitNetShapes of %d of %d bytes succeeded", n, len(buf))
+		}
+	}
+	if shapes, err := ReadInitNetShapes(bytes.NewReader(nil)); err != nil || len(shapes) != 0 {
+		t.Errorf("ReadInitNetShapes of an empty net = %v, %v", shapes, err)
+	}
+}
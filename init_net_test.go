@@ -0,0 +1,90 @@
+package caffe2
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInitNetRoundTrip(t *testing.T) {
+	protos := &TensorProtos{}
+	for name, data := range map[string]interface{}{
+		"float":  []float32{1.5, -2},
+		"double": []float64{0.5, -3},
+		"int32":  []int32{1, math.MinInt32},
+		"int64":  []int64{1, math.MaxInt64},
+		"int16":  []int16{1, math.MinInt16},
+		"bool":   []bool{true, false},
+		"string": []string{"a", "b"},
+		"uint8":  []uint8{1, 255},
+	} {
+		tensor, err := NewTensorProto(name, []int64{2}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		protos.Protos = append(protos.Protos, tensor)
+	}
+	init, err := NewInitNet("init", protos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{}
+	for _, op := range init.GetOp() {
+		types[op.GetOutput()[0]] = op.GetType()
+	}
+	if types["int16"] != "GivenTensorInt16Fill" || types["double"] != "GivenTensorDoubleFill" {
+		t.Errorf("fill operators = %v", types)
+	}
+	got, err := InitNetTensors(init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, protos) {
+		t.Errorf("init net round trip = %v, want %v", got, protos)
+	}
+	if issues := ValidateNet(&NetDef{}, init); len(issues) != 0 {
+		t.Errorf("generated init net issues = %v", issues)
+	}
+}
+
+func TestInitNetWidenedIntegers(t *testing.T) {
+	int8s, _ := NewTensorProto("int8", []int64{2}, []int8{-128, 127})
+	uint16s, _ := NewTensorProto("uint16", []int64{2}, []uint16{0, 65535})
+	init, err := NewInitNet("init", &TensorProtos{Protos: []*TensorProto{int8s, uint16s}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := InitNetTensors(init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := got.GetProtos()[0].GetInt32Data(); got.GetProtos()[0].GetDataType() != TensorProto_INT32 || !reflect.DeepEqual(data, []int32{-128, 127}) {
+		t.Errorf("int8 tensor = %v", got.GetProtos()[0])
+	}
+	if data := got.GetProtos()[1].GetInt32Data(); !reflect.DeepEqual(data, []int32{0, 65535}) {
+		t.Errorf("uint16 tensor = %v", got.GetProtos()[1])
+	}
+}
+
+func TestInitNetLossyDouble(t *testing.T) {
+	tensor, _ := NewTensorProto("double", []int64{2}, []float64{0.5, 0.1})
+	_, err := NewInitNet("init", &TensorProtos{Protos: []*TensorProto{tensor}})
+	if err == nil || !strings.Contains(err.Error(), "without loss") {
+		t.Errorf("NewInitNet of a double that is not a float = %v, want an error", err)
+	}
+}
+
+func TestConstantFillTensor(t *testing.T) {
+	op := newOp("ConstantFill", nil, []string{"c"}, intsArg("shape", 2, 2), intArg("dtype", int64(TensorProto_INT64)), intArg("value", 7))
+	tensor, err := FillOperatorTensor(op, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{7, 7, 7, 7}; !reflect.DeepEqual(tensor.GetInt64Data(), want) {
+		t.Errorf("ConstantFill data = %v, want %v", tensor.GetInt64Data(), want)
+	}
+	if _, err := FillOperatorTensor(newOp("XavierFill", nil, []string{"x"}, intsArg("shape", 2)), "x"); err == nil {
+		t.Error("FillOperatorTensor of a random fill succeeded")
+	}
+}
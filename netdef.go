@@ -0,0 +1,107 @@
+package caffe2
+
+// GetArgument returns the argument with the given name or nil if the operator
+// does not carry it.
+func (m *OperatorDef) GetArgument(name string) *Argument {
+	for _, arg := range m.GetArg() {
+		if arg.GetName() == name {
+			return arg
+		}
+	}
+	return nil
+}
+
+// HasArgument ...
+func (m *OperatorDef) HasArgument(name string) bool {
+	return m.GetArgument(name) != nil
+}
+
+// GetArgInt returns the integer value of the named argument or def if the
+// operator does not carry it.
+func (m *OperatorDef) GetArgInt(name string, def int64) int64 {
+	if arg := m.GetArgument(name); arg != nil {
+		return arg.GetI()
+	}
+	return def
+}
+
+// GetArgInts ...
+func (m *OperatorDef) GetArgInts(name string) []int64 {
+	return m.GetArgument(name).GetInts()
+}
+
+// GetArgFloat ...
+func (m *OperatorDef) GetArgFloat(name string, def float32) float32 {
+	if arg := m.GetArgument(name); arg != nil {
+		return arg.GetF()
+	}
+	return def
+}
+
+// GetArgString ...
+func (m *OperatorDef) GetArgString(name string, def string) string {
+	if arg := m.GetArgument(name); arg != nil {
+		return string(arg.GetS())
+	}
+	return def
+}
+
+// Producers maps each blob name to the indices of the operators that write it.
+func (m *NetDef) Producers() map[string][]int {
+	producers := map[string][]int{}
+	for ii, op := range m.GetOp() {
+		for _, output := range op.GetOutput() {
+			producers[output] = append(producers[output], ii)
+		}
+	}
+	return producers
+}
+
+// Consumers maps each blob name to the indices of the operators that read it.
+func (m *NetDef) Consumers() map[string][]int {
+	consumers := map[string][]int{}
+	for ii, op := range m.GetOp() {
+		for _, input := range op.GetInput() {
+			consumers[input] = append(consumers[input], ii)
+		}
+	}
+	return consumers
+}
+
+// WeightBlobs returns the set of blobs in the net that hold parameters.
+// When an init net is given, the parameters are the blobs it produces.
+// Otherwise they are guessed to be the external inputs that are never used as
+// the first input of an operator (e.g. the filter and bias of a Conv).
+func WeightBlobs(net *NetDef, init *NetDef) map[string]bool {
+	weights := map[string]bool{}
+	if init != nil {
+		for _, op := range init.GetOp() {
+			for _, output := range op.GetOutput() {
+				weights[output] = true
+			}
+		}
+		return weights
+	}
+
+	external := map[string]bool{}
+	for _, input := range net.GetExternalInput() {
+		external[input] = true
+	}
+	data := map[string]bool{}
+	for _, op := range net.GetOp() {
+		inputs := op.GetInput()
+		if len(inputs) == 0 {
+			continue
+		}
+		data[inputs[0]] = true
+		for _, input := range inputs[1:] {
+			if external[input] {
+				weights[input] = true
+			}
+		}
+	}
+	for name := range data {
+		delete(weights, name)
+	}
+	return weights
+}
@@ -0,0 +1,109 @@
+package caffe2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newOp(typ string, inputs, outputs []string, args ...*Argument) *OperatorDef {
+	return &OperatorDef{
+		Type:   typ,
+		Input:  inputs,
+		Output: outputs,
+		Arg:    args,
+	}
+}
+
+func intArg(name string, i int64) *Argument {
+	return &Argument{Name: name, I: i}
+}
+
+func intsArg(name string, ints ...int64) *Argument {
+	return &Argument{Name: name, Ints: ints}
+}
+
+func floatArg(name string, f float32) *Argument {
+	return &Argument{Name: name, F: f}
+}
+
+func floatsArg(name string, floats ...float32) *Argument {
+	return &Argument{Name: name, Floats: floats}
+}
+
+func stringArg(name, s string) *Argument {
+	return &Argument{Name: name, S: []byte(s)}
+}
+
+// testNet is a small convolutional classifier whose weights are filled by
+// testInitNet.
+func testNet() *NetDef {
+	return &NetDef{
+		Name:          "test",
+		ExternalInput: []string{"data", "conv_w", "conv_b", "fc_w", "fc_b"},
+		Op: []*OperatorDef{
+			newOp("Conv", []string{"data", "conv_w", "conv_b"}, []string{"conv"}, intArg("kernel", 3), intArg("pad", 1)),
+			newOp("Relu", []string{"conv"}, []string{"conv"}),
+			newOp("MaxPool", []string{"conv"}, []string{"pool"}, intArg("kernel", 2), intArg("stride", 2)),
+			newOp("FC", []string{"pool", "fc_w", "fc_b"}, []string{"fc"}),
+			newOp("Softmax", []string{"fc"}, []string{"prob"}),
+		},
+		ExternalOutput: []string{"prob"},
+	}
+}
+
+func testInitNet() *NetDef {
+	return &NetDef{
+		Name: "test_init",
+		Op: []*OperatorDef{
+			newOp("GivenTensorFill", nil, []string{"conv_w"}, intsArg("shape", 4, 3, 3, 3), floatsArg("values", make([]float32, 4*3*3*3)...)),
+			newOp("ConstantFill", nil, []string{"conv_b"}, intsArg("shape", 4)),
+			newOp("XavierFill", nil, []string{"fc_w"}, intsArg("shape", 10, 4*4*4)),
+			newOp("ConstantFill", nil, []string{"fc_b"}, intsArg("shape", 10)),
+		},
+	}
+}
+
+func TestWeightBlobs(t *testing.T) {
+	want := map[string]bool{"conv_w": true, "conv_b": true, "fc_w": true, "fc_b": true}
+	if got := WeightBlobs(testNet(), testInitNet()); !reflect.DeepEqual(got, want) {
+		t.Errorf("WeightBlobs with the init net = %v, want %v", got, want)
+	}
+	if got := WeightBlobs(testNet(), nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("WeightBlobs without the init net = %v, want %v", got, want)
+	}
+}
+
+func TestProducersConsumers(t *testing.T) {
+	net := testNet()
+	if got, want := net.Producers()["conv"], []int{0, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("producers of conv = %v, want %v", got, want)
+	}
+	if got, want := net.Consumers()["conv"], []int{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("consumers of conv = %v, want %v", got, want)
+	}
+	if got := net.Consumers()["prob"]; got != nil {
+		t.Errorf("consumers of prob = %v, want none", got)
+	}
+}
+
+func TestArgumentAccessors(t *testing.T) {
+	op := newOp("Conv", nil, nil, intArg("kernel", 3), intsArg("pads", 1, 2), floatArg("alpha", 0.5), stringArg("order", "NHWC"))
+	if got := op.GetArgInt("kernel", 0); got != 3 {
+		t.Errorf("kernel = %d, want 3", got)
+	}
+	if got := op.GetArgInt("stride", 1); got != 1 {
+		t.Errorf("missing stride = %d, want the default 1", got)
+	}
+	if got := op.GetArgInts("pads"); !reflect.DeepEqual(got, []int64{1, 2}) {
+		t.Errorf("pads = %v, want [1 2]", got)
+	}
+	if got := op.GetArgFloat("alpha", 0); got != 0.5 {
+		t.Errorf("alpha = %v, want 0.5", got)
+	}
+	if got := op.GetArgString("order", "NCHW"); got != "NHWC" {
+		t.Errorf("order = %s, want NHWC", got)
+	}
+	if op.HasArgument("dilation") {
+		t.Error("HasArgument(dilation) = true, want false")
+	}
+}
@@ -0,0 +1,496 @@
+package caffe2
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// The .npy format is described in
+// https://numpy.org/doc/stable/reference/generated/numpy.lib.format.html
+
+var npyMagic = []byte("\x93NUMPY")
+
+// maxNpyHeaderLen bounds the header dictionary. numpy pads it to a multiple
+// of 64 bytes and only switches to version 2 past 64KB for huge record types.
+const maxNpyHeaderLen = 1 << 20
+
+var (
+	npyDescrRe   = regexp.MustCompile(`['"]descr['"]\s*:\s*['"]([^'"]*)['"]`)
+	npyFortranRe = regexp.MustCompile(`['"]fortran_order['"]\s*:\s*(True|False)`)
+	npyShapeRe   = regexp.MustCompile(`['"]shape['"]\s*:\s*\(([^)]*)\)`)
+)
+
+// npyDescrs maps the numpy type strings, without their byte order, to the
+// tensor data types.
+var npyDescrs = map[string]TensorProto_DataType{
+	"f4": TensorProto_FLOAT,
+	"f8": TensorProto_DOUBLE,
+	"f2": TensorProto_FLOAT16,
+	"i4": TensorProto_INT32,
+	"i8": TensorProto_INT64,
+	"i2": TensorProto_INT16,
+	"u2": TensorProto_UINT16,
+	"i1": TensorProto_INT8,
+	"u1": TensorProto_UINT8,
+	"b1": TensorProto_BOOL,
+}
+
+type npyHeader struct {
+	descr        string
+	fortranOrder bool
+	shape        []int64
+}
+
+// ReadNpy decodes a .npy array into a TensorProto with the given name. Numeric
+// and boolean arrays, as well as byte (S) and unicode (U) string arrays are
+// supported, in either byte order and memory layout.
+func ReadNpy(r io.Reader, name string) (*TensorProto, error) {
+	header, err := readNpyHeader(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid npy header for %s", name)
+	}
+
+	order, descr := npyByteOrder(header.descr)
+	size := npySize(header.shape)
+	if size < 0 {
+		return nil, errors.Errorf("invalid shape %v for %s", header.shape, name)
+	}
+
+	if dtype, ok := npyDescrs[descr]; ok {
+		itemSize, _ := strconv.Atoi(descr[1:])
+		raw, err := readNpyData(r, header, int64(itemSize), size)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read npy data for %s", name)
+		}
+		data, err := decodeNpyData(raw, order, dtype, size)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to decode npy data for %s", name)
+		}
+		return NewTensorProtoWithType(name, header.shape, dtype, data)
+	}
+
+	if len(descr) > 1 && (descr[0] == 'S' || descr[0] == 'U') {
+		width, err := strconv.Atoi(descr[1:])
+		if err != nil || width < 0 {
+			return nil, errors.Errorf("unsupported npy type %s for %s", header.descr, name)
+		}
+		itemSize := int64(width)
+		if descr[0] == 'U' {
+			itemSize *= 4
+		}
+		raw, err := readNpyData(r, header, itemSize, size)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read npy data for %s", name)
+		}
+		data := make([][]byte, size)
+		for ii := range data {
+			item := raw[int64(ii)*itemSize : int64(ii+1)*itemSize]
+			if descr[0] == 'U' {
+				item = decodeUTF32(item, order)
+			}
+			data[ii] = bytes.TrimRight(item, "\x00")
+		}
+		return NewTensorProtoWithType(name, header.shape, TensorProto_STRING, data)
+	}
+
+	return nil, errors.Errorf("unsupported npy type %s for %s", header.descr, name)
+}
+
+// ReadNpyFile reads the .npy array at path, the tensor is named after the
+// file.
+func ReadNpyFile(filePath string) (*TensorProto, error) {
+	buf, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s", filePath)
+	}
+	name := strings.TrimSuffix(filepath.Base(filePath), ".npy")
+	return ReadNpy(bytes.NewReader(buf), name)
+}
+
+// WriteNpy encodes a tensor as a little endian, C ordered .npy array. STRING
+// tensors are written as fixed width byte strings and BYTE tensors as uint8.
+func WriteNpy(w io.Writer, tensor *TensorProto) error {
+	t, err := DecodeTensor(tensor)
+	if err != nil {
+		return err
+	}
+
+	var (
+		descr string
+		data  interface{}
+	)
+	switch t.DataType {
+	case TensorProto_STRING:
+		strs := tensor.GetStringData()
+		width := 1
+		for _, s := range strs {
+			if len(s) > width {
+				width = len(s)
+			}
+		}
+		buf := make([]byte, width*len(strs))
+		for ii, s := range strs {
+			copy(buf[ii*width:], s)
+		}
+		descr, data = "|S"+strconv.Itoa(width), buf
+	case TensorProto_FLOAT16:
+		raw := tensor.GetInt32Data()
+		halfs := make([]uint16, len(raw))
+		for ii, v := range raw {
+			halfs[ii] = uint16(v)
+		}
+		descr, data = "<f2", halfs
+	case TensorProto_BYTE:
+		descr, data = "|u1", t.Data
+	default:
+		for d, dtype := range npyDescrs {
+			if dtype == t.DataType {
+				descr = d
+				break
+			}
+		}
+		if descr == "" {
+			return errors.Errorf("tensor %s has an unsupported data type %v", t.Name, t.DataType)
+		}
+		if strings.HasSuffix(descr, "1") {
+			descr = "|" + descr
+		} else {
+			descr = "<" + descr
+		}
+		data = t.Data
+	}
+
+	if err := writeNpyHeader(w, descr, t.Dims); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, data)
+}
+
+// ReadNpz reads all the arrays of a .npz archive. The tensors are named after
+// the archive entries, without their .npy extension, and kept in the archive
+// order.
+func ReadNpz(r io.ReaderAt, size int64) (*TensorProtos, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open npz archive")
+	}
+	res := &TensorProtos{}
+	for _, file := range archive.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		name := strings.TrimSuffix(file.Name, ".npy")
+		f, err := file.Open()
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to open %s in npz archive", file.Name)
+		}
+		// the reported size of the entry is not trusted for the allocation,
+		// it only bounds the read
+		tensor, err := ReadNpy(io.LimitReader(f, int64(file.UncompressedSize64)), name)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Protos = append(res.Protos, tensor)
+	}
+	return res, nil
+}
+
+// ReadNpzFile reads the .npz archive at path.
+func ReadNpzFile(path string) (*TensorProtos, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open %s", path)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to stat %s", path)
+	}
+	return ReadNpz(f, info.Size())
+}
+
+// WriteNpz writes the tensors as a .npz archive keyed by tensor name, as
+// numpy.savez does. Segmented tensors are assembled first.
+func WriteNpz(w io.Writer, protos *TensorProtos) error {
+	protos, err := AssembleTensorProtos(protos)
+	if err != nil {
+		return err
+	}
+	archive := zip.NewWriter(w)
+	seen := map[string]bool{}
+	for _, tensor := range protos.GetProtos() {
+		name := tensor.GetName()
+		if name == "" {
+			return errors.New("cannot write an unnamed tensor to an npz archive")
+		}
+		if seen[name] {
+			return errors.Errorf("duplicate tensor %s", name)
+		}
+		seen[name] = true
+		f, err := archive.Create(name + ".npy")
+		if err != nil {
+			return errors.Wrapf(err, "unable to add %s to npz archive", name)
+		}
+		if err := WriteNpy(f, tensor); err != nil {
+			return errors.Wrapf(err, "unable to write %s", name)
+		}
+	}
+	return archive.Close()
+}
+
+func readNpyHeader(r io.Reader) (*npyHeader, error) {
+	prefix := make([]byte, len(npyMagic)+2)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(prefix[:len(npyMagic)], npyMagic) {
+		return nil, errors.New("not an npy file")
+	}
+
+	var headerLen int
+	switch major := prefix[len(npyMagic)]; major {
+	case 1:
+		var n uint16
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		headerLen = int(n)
+	case 2, 3:
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		headerLen = int(n)
+	default:
+		return nil, errors.Errorf("unsupported npy version %d", major)
+	}
+	if headerLen > maxNpyHeaderLen {
+		return nil, errors.Errorf("npy header of %d bytes is too large", headerLen)
+	}
+	buf := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	dict := string(buf)
+
+	header := &npyHeader{}
+	m := npyDescrRe.FindStringSubmatch(dict)
+	if m == nil {
+		return nil, errors.Errorf("unsupported descr in %s", strings.TrimSpace(dict))
+	}
+	header.descr = m[1]
+	if m := npyFortranRe.FindStringSubmatch(dict); m != nil {
+		header.fortranOrder = m[1] == "True"
+	}
+	m = npyShapeRe.FindStringSubmatch(dict)
+	if m == nil {
+		return nil, errors.Errorf("missing shape in %s", strings.TrimSpace(dict))
+	}
+	header.shape = []int64{}
+	for _, s := range strings.Split(m[1], ",") {
+		s = strings.TrimSuffix(strings.TrimSpace(s), "L")
+		if s == "" {
+			continue
+		}
+		dim, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid shape %s", m[1])
+		}
+		header.shape = append(header.shape, dim)
+	}
+	return header, nil
+}
+
+func writeNpyHeader(w io.Writer, descr string, dims []int64) error {
+	shape := make([]string, len(dims))
+	for ii, dim := range dims {
+		shape[ii] = strconv.FormatInt(dim, 10)
+	}
+	shapeStr := strings.Join(shape, ", ")
+	if len(dims) == 1 {
+		shapeStr += ","
+	}
+	dict := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%s), }", descr, shapeStr)
+
+	// the data must start on a 64 byte boundary, the header ends with a newline
+	version, lenSize := byte(1), 2
+	padded := func() int {
+		total := len(npyMagic) + 2 + lenSize + len(dict) + 1
+		return len(dict) + 1 + (64-total%64)%64
+	}
+	if padded() > 0xffff {
+		version, lenSize = 2, 4
+	}
+	headerLen := padded()
+	dict += strings.Repeat(" ", headerLen-len(dict)-1) + "\n"
+
+	buf := new(bytes.Buffer)
+	buf.Write(npyMagic)
+	buf.Write([]byte{version, 0})
+	if version == 1 {
+		binary.Write(buf, binary.LittleEndian, uint16(headerLen))
+	} else {
+		binary.Write(buf, binary.LittleEndian, uint32(headerLen))
+	}
+	buf.WriteString(dict)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// npyByteOrder splits the byte order from a type string, native and not
+// applicable orders are treated as little endian.
+func npyByteOrder(descr string) (binary.ByteOrder, string) {
+	if descr == "" {
+		return binary.LittleEndian, descr
+	}
+	switch descr[0] {
+	case '>', '!':
+		return binary.BigEndian, descr[1:]
+	case '<', '|', '=':
+		return binary.LittleEndian, descr[1:]
+	}
+	return binary.LittleEndian, descr
+}
+
+// npySize returns the number of elements of an array, -1 if a dimension is
+// negative or if the number overflows.
+func npySize(shape []int64) int64 {
+	size := int64(1)
+	for _, dim := range shape {
+		if dim < 0 || (dim != 0 && size > math.MaxInt64/dim) {
+			return -1
+		}
+		size *= dim
+	}
+	return size
+}
+
+// readNpyData reads the array elements, reordering them to C order if needed.
+// The size given by the header is checked against the remaining input before
+// the elements are allocated when the input is in memory.
+func readNpyData(r io.Reader, header *npyHeader, itemSize, size int64) ([]byte, error) {
+	if itemSize != 0 && size > math.MaxInt64/itemSize {
+		return nil, errors.Errorf("array of %d elements of %d bytes is too large", size, itemSize)
+	}
+	n := itemSize * size
+	remaining := int64(-1)
+	if r, ok := r.(interface {
+		Len() int
+	}); ok {
+		remaining = int64(r.Len())
+	}
+	var raw []byte
+	switch {
+	case remaining >= 0 && n > remaining:
+		return nil, errors.Errorf("array of %d bytes but only %d bytes remain", n, remaining)
+	case remaining >= 0:
+		raw = make([]byte, n)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, err
+		}
+	default:
+		// grown as the data is read rather than trusting the header
+		buf, err := ioutil.ReadAll(io.LimitReader(r, n))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(buf)) != n {
+			return nil, io.ErrUnexpectedEOF
+		}
+		raw = buf
+	}
+	if !header.fortranOrder || len(header.shape) < 2 {
+		return raw, nil
+	}
+
+	strides := make([]int64, len(header.shape))
+	stride := int64(1)
+	for ii, dim := range header.shape {
+		strides[ii] = stride
+		stride *= dim
+	}
+	res := make([]byte, len(raw))
+	index := make([]int64, len(header.shape))
+	for ii := int64(0); ii < size; ii++ {
+		offset := int64(0)
+		for jj, idx := range index {
+			offset += idx * strides[jj]
+		}
+		copy(res[ii*itemSize:(ii+1)*itemSize], raw[offset*itemSize:(offset+1)*itemSize])
+		for jj := len(index) - 1; jj >= 0; jj-- {
+			index[jj]++
+			if index[jj] < header.shape[jj] {
+				break
+			}
+			index[jj] = 0
+		}
+	}
+	return res, nil
+}
+
+func decodeNpyData(raw []byte, order binary.ByteOrder, dtype TensorProto_DataType, size int64) (interface{}, error) {
+	var data interface{}
+	switch dtype {
+	case TensorProto_FLOAT:
+		data = make([]float32, size)
+	case TensorProto_DOUBLE:
+		data = make([]float64, size)
+	case TensorProto_INT32:
+		data = make([]int32, size)
+	case TensorProto_INT64:
+		data = make([]int64, size)
+	case TensorProto_INT16:
+		data = make([]int16, size)
+	case TensorProto_UINT16, TensorProto_FLOAT16:
+		data = make([]uint16, size)
+	case TensorProto_INT8:
+		data = make([]int8, size)
+	case TensorProto_UINT8:
+		data = make([]uint8, size)
+	case TensorProto_BOOL:
+		data = make([]bool, size)
+	default:
+		return nil, errors.Errorf("unsupported data type %v", dtype)
+	}
+	if err := binary.Read(bytes.NewReader(raw), order, data); err != nil {
+		return nil, err
+	}
+	if dtype == TensorProto_FLOAT16 {
+		halfs := data.([]uint16)
+		floats := make([]float32, len(halfs))
+		for ii, h := range halfs {
+			floats[ii] = Float16ToFloat32(h)
+		}
+		data = floats
+	}
+	return data, nil
+}
+
+// decodeUTF32 converts fixed width UTF-32 text to UTF-8.
+func decodeUTF32(buf []byte, order binary.ByteOrder) []byte {
+	res := make([]byte, 0, len(buf)/4)
+	for ii := 0; ii+4 <= len(buf); ii += 4 {
+		r := rune(order.Uint32(buf[ii:]))
+		if r == 0 {
+			break
+		}
+		var enc [utf8.UTFMax]byte
+		n := utf8.EncodeRune(enc[:], r)
+		res = append(res, enc[:n]...)
+	}
+	return res
+}
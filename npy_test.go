@@ -0,0 +1,162 @@
+package caffe2
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNpyRoundTrip(t *testing.T) {
+	for _, data := range []interface{}{
+		[]float32{1.5, -2, 0, 3, 4, 5},
+		[]float64{1.5, -2, 0, 1e300, 4, 5},
+		[]int32{1, -2, 3, 4, 5, math.MaxInt32},
+		[]int64{1, -2, 3, 4, 5, math.MaxInt64},
+		[]int16{1, -2, 3, 4, 5, math.MinInt16},
+		[]uint16{1, 2, 3, 4, 5, math.MaxUint16},
+		[]int8{1, -2, 3, 4, 5, math.MinInt8},
+		[]uint8{1, 2, 3, 4, 5, math.MaxUint8},
+		[]bool{true, false, false, true, true, false},
+		[]string{"a", "bc", "", "def", "g", "h"},
+	} {
+		tensor, err := NewTensorProto("t", []int64{2, 3}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		if err := WriteNpy(buf, tensor); err != nil {
+			t.Fatalf("WriteNpy(%T): %v", data, err)
+		}
+		if buf.Len() < 64 || (bytes.IndexByte(buf.Bytes(), '\n')+1)%64 != 0 {
+			t.Errorf("npy data of %T does not start on a 64 byte boundary", data)
+		}
+		got, err := ReadNpy(buf, "t")
+		if err != nil {
+			t.Fatalf("ReadNpy(%T): %v", data, err)
+		}
+		if !reflect.DeepEqual(got, tensor) {
+			t.Errorf("npy round trip of %T = %v, want %v", data, got, tensor)
+		}
+	}
+}
+
+// npy builds an npy file from its header dictionary and data.
+func npy(dict string, data interface{}) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(npyMagic)
+	buf.Write([]byte{1, 0})
+	binary.Write(buf, binary.LittleEndian, uint16(len(dict)+1))
+	buf.WriteString(dict + "\n")
+	binary.Write(buf, binary.BigEndian, data)
+	return buf.Bytes()
+}
+
+func TestReadNpyLayouts(t *testing.T) {
+	// big endian, Fortran ordered 2x3 array
+	buf := npy("{'descr': '>i4', 'fortran_order': True, 'shape': (2, 3), }", []int32{1, 4, 2, 5, 3, 6})
+	tensor, err := ReadNpy(bytes.NewReader(buf), "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int32{1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(tensor.GetInt32Data(), want) {
+		t.Errorf("Fortran ordered data = %v, want %v", tensor.GetInt32Data(), want)
+	}
+
+	// big endian UTF-32 strings
+	buf = npy("{'descr': '>U2', 'fortran_order': False, 'shape': (2,), }", []uint32{'h', 'é', 'x', 0})
+	tensor, err = ReadNpy(bytes.NewReader(buf), "u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{[]byte("hé"), []byte("x")}; !reflect.DeepEqual(tensor.GetStringData(), want) {
+		t.Errorf("unicode data = %q, want %q", tensor.GetStringData(), want)
+	}
+}
+
+func TestReadNpyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+		err  string
+	}{
+		{"magic", []byte("NUMPYX\x01\x00"), "not an npy file"},
+		{"type", npy("{'descr': '<c8', 'fortran_order': False, 'shape': (1,), }", []float32{1, 2}), "unsupported npy type"},
+		{"truncated", npy("{'descr': '<f4', 'fortran_order': False, 'shape': (3,), }", []float32{1, 2}), "only 8 bytes remain"},
+		// the header claims more data than the input holds, it must not be
+		// allocated
+		{"huge", npy("{'descr': '<f8', 'fortran_order': False, 'shape': (1000000000000,), }", []float32{1}), "only 4 bytes remain"},
+		{"overflow", npy("{'descr': '<f8', 'fortran_order': False, 'shape': (4611686018427387904, 4), }", []float32{1}), "invalid shape"},
+		{"bytes overflow", npy("{'descr': '<f8', 'fortran_order': False, 'shape': (4611686018427387904,), }", []float32{1}), "too large"},
+		{"header", append(append([]byte{}, npyMagic...), 2, 0, 0xff, 0xff, 0xff, 0xff), "npy header of 4294967295 bytes is too large"},
+	}
+	for _, test := range tests {
+		_, err := ReadNpy(bytes.NewReader(test.buf), test.name)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: ReadNpy error = %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	// without the size of the input, the data is read as it comes
+	buf := npy("{'descr': '<f8', 'fortran_order': False, 'shape': (1000000000000,), }", []float32{1})
+	if _, err := ReadNpy(io.MultiReader(bytes.NewReader(buf)), "stream"); err == nil {
+		t.Error("ReadNpy of a truncated stream succeeded")
+	}
+}
+
+func TestNpz(t *testing.T) {
+	w, _ := NewTensorProto("conv/w", []int64{2, 2}, []float32{1, 2, 3, 4})
+	b, _ := NewTensorProto("conv/b", []int64{2}, []int64{5, 6})
+	protos := &TensorProtos{Protos: []*TensorProto{w, b}}
+	buf := new(bytes.Buffer)
+	if err := WriteNpz(buf, protos); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadNpz(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, protos) {
+		t.Errorf("npz round trip = %v, want %v", got, protos)
+	}
+
+	dup := &TensorProtos{Protos: []*TensorProto{w, w}}
+	if err := WriteNpz(new(bytes.Buffer), dup); err == nil {
+		t.Error("WriteNpz with duplicate names succeeded")
+	}
+}
+
+func TestReadNpzForgedSize(t *testing.T) {
+	// the entry claims 4GB but only holds a few bytes past its header
+	buf := new(bytes.Buffer)
+	archive := zip.NewWriter(buf)
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: "w.npy", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(npy("{'descr': '<f8', 'fortran_order': False, 'shape': (500000000,), }", []float32{1}))
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	central := bytes.Index(raw, []byte("PK\x01\x02"))
+	if central < 0 {
+		t.Fatal("missing central directory")
+	}
+	binary.LittleEndian.PutUint32(raw[central+24:], 0xfffffff0)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := ReadNpz(bytes.NewReader(raw), int64(len(raw))); err == nil {
+		t.Error("ReadNpz of a truncated entry succeeded")
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("ReadNpz allocated %d bytes for a forged entry size", allocated)
+	}
+}
@@ -0,0 +1,197 @@
+package predict
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+// archiveFile is the name of a downloaded model archive in the work
+// directory, it is removed once extracted.
+const archiveFile = ".model_archive"
+
+// defaultMaxArchiveSize bounds the bytes extracted from a model archive when
+// caffe2.max_archive_size is not set.
+const defaultMaxArchiveSize = 64 << 30
+
+// archiveLimit counts the bytes extracted from an archive, whose entries
+// could otherwise fill the disk however small the archive is.
+type archiveLimit struct {
+	max     int64
+	written int64
+}
+
+func newArchiveLimit() (*archiveLimit, error) {
+	if Config.MaxArchiveSize == "" {
+		return &archiveLimit{max: defaultMaxArchiveSize}, nil
+	}
+	max, err := humanize.ParseBytes(Config.MaxArchiveSize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid caffe2.max_archive_size %q", Config.MaxArchiveSize)
+	}
+	return &archiveLimit{max: int64(max)}, nil
+}
+
+// extractArchive extracts a tar.gz, tar.bz2, zip or tar archive into dir. The
+// format is detected from the content of the archive. Entries that would end
+// up outside of dir, and symbolic or hard links, are rejected, as are the
+// archives extracting to more than caffe2.max_archive_size.
+func extractArchive(ctx context.Context, archivePath, dir string) error {
+	limit, err := newArchiveLimit()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic, _ := r.Peek(512)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return errors.Wrapf(err, "invalid gzip archive %s", archivePath)
+		}
+		defer gz.Close()
+		return extractTar(ctx, tar.NewReader(gz), dir, limit)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return extractTar(ctx, tar.NewReader(bzip2.NewReader(r)), dir, limit)
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return errors.Wrapf(err, "invalid zip archive %s", archivePath)
+		}
+		return extractZip(ctx, zr, dir, limit)
+	case len(magic) >= 262 && string(magic[257:262]) == "ustar":
+		return extractTar(ctx, tar.NewReader(r), dir, limit)
+	}
+	return errors.Errorf("unsupported format of the model archive %s, expecting tar.gz, tar.bz2, zip or tar", archivePath)
+}
+
+func extractTar(ctx context.Context, tr *tar.Reader, dir string, limit *archiveLimit) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "invalid tar archive")
+		}
+		if err := checkContext(ctx, "extract model archive"); err != nil {
+			return err
+		}
+		target, err := archiveEntryPath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeArchiveEntry(target, tr, os.FileMode(hdr.Mode).Perm(), limit); err != nil {
+				return err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			// a link could redirect the entries that follow it out of dir
+			return errors.Errorf("archive entry %s is a link, links are not supported in model archives", hdr.Name)
+		default:
+			log.WithField("entry", hdr.Name).Debug("skipping the special file of the model archive")
+		}
+	}
+}
+
+func extractZip(ctx context.Context, zr *zip.Reader, dir string, limit *archiveLimit) error {
+	for _, file := range zr.File {
+		if err := checkContext(ctx, "extract model archive"); err != nil {
+			return err
+		}
+		target, err := archiveEntryPath(dir, file.Name)
+		if err != nil {
+			return err
+		}
+		mode := file.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			r, err := file.Open()
+			if err != nil {
+				return errors.Wrapf(err, "invalid zip entry %s", file.Name)
+			}
+			err = writeArchiveEntry(target, r, mode.Perm(), limit)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("unsupported zip entry %s of mode %v", file.Name, mode)
+		}
+	}
+	return nil
+}
+
+// archiveEntryPath returns where an archive entry is extracted, rejecting the
+// entries that are absolute or escape dir.
+func archiveEntryPath(dir, name string) (string, error) {
+	name = strings.Replace(name, `\`, "/", -1)
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", errors.Errorf("archive entry %s is an absolute path", name)
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.Errorf("archive entry %s is outside of the work directory", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+func writeArchiveEntry(target string, r io.Reader, perm os.FileMode, limit *archiveLimit) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// the target may be a file of the work directory linked by stageFiles,
+	// it is replaced rather than written through
+	if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm|0600)
+	if err != nil {
+		return err
+	}
+	// one byte past the limit is enough to tell that it is exceeded
+	n, err := io.Copy(f, io.LimitReader(r, limit.max-limit.written+1))
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "cannot extract %s", target)
+	}
+	limit.written += n
+	if limit.written > limit.max {
+		f.Close()
+		return errors.Errorf("the model archive extracts to more than %s, see caffe2.max_archive_size", humanize.Bytes(uint64(limit.max)))
+	}
+	return f.Close()
+}
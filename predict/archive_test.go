@@ -0,0 +1,306 @@
+package predict
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is an entry of a test archive, a link when link is set, a
+// directory when its name ends with a slash.
+type archiveEntry struct {
+	name    string
+	content string
+	link    string
+	hard    bool
+}
+
+func writeTarArchive(t *testing.T, path string, compress bool, entries []archiveEntry) {
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	}
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.content))}
+		switch {
+		case entry.link != "" && entry.hard:
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, entry.link, 0
+		case entry.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, entry.link, 0
+		case strings.HasSuffix(entry.name, "/"):
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, path, buf.Bytes())
+}
+
+func writeZipArchive(t *testing.T, path string, entries []archiveEntry) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, buf.Bytes())
+}
+
+func TestExtractArchive(t *testing.T) {
+	entries := []archiveEntry{
+		{name: "model/"},
+		{name: "model/graph.pb", content: "graph"},
+		{name: "model/data/weights.pb", content: "weights"},
+	}
+	formats := map[string]func(path string){
+		"tar":    func(path string) { writeTarArchive(t, path, false, entries) },
+		"tar.gz": func(path string) { writeTarArchive(t, path, true, entries) },
+		"zip":    func(path string) { writeZipArchive(t, path, entries) },
+	}
+	for format, write := range formats {
+		dir, err := ioutil.TempDir("", "archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive := filepath.Join(dir, "model."+format)
+		write(archive)
+		workDir := filepath.Join(dir, "work")
+		if err := extractArchive(context.Background(), archive, workDir); err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		for _, entry := range entries[1:] {
+			buf, err := ioutil.ReadFile(filepath.Join(workDir, filepath.FromSlash(entry.name)))
+			if err != nil || string(buf) != entry.content {
+				t.Errorf("%s: %s = %q, %v, want %q", format, entry.name, buf, err, entry.content)
+			}
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "model.txt")
+	writeTestFile(t, archive, []byte("not an archive"))
+	if err := extractArchive(context.Background(), archive, dir); err == nil || !strings.Contains(err.Error(), "unsupported format") {
+		t.Errorf("extract of a text file = %v", err)
+	}
+}
+
+// TestExtractArchiveTraversal checks that no entry is written outside of the
+// work directory, through its path or through links.
+func TestExtractArchiveTraversal(t *testing.T) {
+	cases := map[string][]archiveEntry{
+		"parent":    {{name: "../evil", content: "evil"}},
+		"absolute":  {{name: "/evil", content: "evil"}},
+		"backslash": {{name: `..\evil`, content: "evil"}},
+		// a link to the work directory, then a link to its parent through
+		// the first link
+		"symlinked parent": {
+			{name: "d", link: "."},
+			{name: "d/e", link: ".."},
+			{name: "d/e/evil", content: "evil"},
+		},
+		"symlink":  {{name: "evil", link: "../evil"}},
+		"hardlink": {{name: "graph.pb", content: "graph"}, {name: "evil", link: "graph.pb", hard: true}},
+	}
+	for name, entries := range cases {
+		dir, err := ioutil.TempDir("", "archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive := filepath.Join(dir, "model.tar.gz")
+		writeTarArchive(t, archive, true, entries)
+		workDir := filepath.Join(dir, "work")
+		if err := extractArchive(context.Background(), archive, workDir); err == nil {
+			t.Errorf("%s: the archive was extracted", name)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+			t.Errorf("%s: the archive wrote outside of the work directory", name)
+		}
+		if _, err := os.Lstat(filepath.Join(workDir, "evil")); !os.IsNotExist(err) {
+			t.Errorf("%s: the link of the archive was extracted", name)
+		}
+	}
+}
+
+func TestExtractArchiveLimit(t *testing.T) {
+	maxArchiveSize := Config.MaxArchiveSize
+	Config.MaxArchiveSize = "1KB"
+	defer func() { Config.MaxArchiveSize = maxArchiveSize }()
+
+	cases := map[string][]archiveEntry{
+		// a few bytes compress the zeros of an entry far larger than the limit
+		"bomb": {{name: "weights.pb", content: strings.Repeat("\x00", 1<<20)}},
+		// each entry fits, not their total
+		"total": {{name: "graph.pb", content: strings.Repeat("g", 600)}, {name: "weights.pb", content: strings.Repeat("w", 600)}},
+	}
+	for name, entries := range cases {
+		for _, format := range []string{"tar.gz", "zip"} {
+			dir, err := ioutil.TempDir("", "archive")
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(dir, "model."+format)
+			if format == "zip" {
+				writeZipArchive(t, archive, entries)
+			} else {
+				writeTarArchive(t, archive, true, entries)
+			}
+			err = extractArchive(context.Background(), archive, filepath.Join(dir, "work"))
+			if err == nil || !strings.Contains(err.Error(), "extracts to more than 1.0 kB") {
+				t.Errorf("%s %s: extract = %v, want the archive to be rejected", name, format, err)
+			}
+			if info, err := os.Stat(filepath.Join(dir, "work", "weights.pb")); err == nil && info.Size() > 1001 {
+				t.Errorf("%s %s: extracted %d bytes of weights", name, format, info.Size())
+			}
+		}
+	}
+
+	// the archives within the limit are extracted
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "model.tar.gz")
+	writeTarArchive(t, archive, true, []archiveEntry{{name: "graph.pb", content: strings.Repeat("g", 500)}, {name: "weights.pb", content: strings.Repeat("w", 500)}})
+	if err := extractArchive(context.Background(), archive, filepath.Join(dir, "work")); err != nil {
+		t.Errorf("extract of 1000 bytes: %v", err)
+	}
+
+	Config.MaxArchiveSize = "a lot"
+	if err := extractArchive(context.Background(), archive, filepath.Join(dir, "work")); err == nil || !strings.Contains(err.Error(), "invalid caffe2.max_archive_size") {
+		t.Errorf("extract with an invalid limit = %v", err)
+	}
+}
+
+func testChecksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// testArchive writes an archive of the files of a model and returns its path
+// and the modelFiles to download it into a new work directory.
+func testArchive(t *testing.T, contents map[string]string) (string, []modelFile) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []archiveEntry
+	var files []modelFile
+	for name, content := range contents {
+		entries = append(entries, archiveEntry{name: name, content: content})
+		files = append(files, modelFile{
+			name:      name,
+			path:      modelFilePath(filepath.Join(dir, "work"), name),
+			checksum:  testChecksum(content),
+			inArchive: true,
+		})
+	}
+	archive := filepath.Join(dir, "model.tar.gz")
+	writeTarArchive(t, archive, true, entries)
+	return archive, files
+}
+
+func TestDownloadArchive(t *testing.T) {
+	archive, files := testArchive(t, map[string]string{"graph.pb": "graph", "weights.pb": "weights"})
+	workDir := filepath.Dir(files[0].path)
+	model, _, _ := testDownload(t, nil)
+	model.Model.BaseUrl = archive
+	model.Model.IsArchive = true
+	download := func(files []modelFile) error {
+		return downloadModel(context.Background(), testSpan(), model, workDir, files)
+	}
+
+	// the archive checksum is optional
+	if err := download(files); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if err := verifyChecksum(file.path, file.checksum); err != nil {
+			t.Errorf("%s: %v", file.name, err)
+		}
+	}
+
+	buf, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.Attributes = map[string]string{"archive_checksum": testChecksum(string(buf))}
+	if err := download(files); err != nil {
+		t.Errorf("download of an archive with its checksum: %v", err)
+	}
+	model.Attributes = map[string]string{"archive_checksum": testChecksum("other")}
+	if err := download(files); err == nil || !strings.Contains(err.Error(), "invalid model archive") {
+		t.Errorf("download of an archive with a wrong checksum = %v", err)
+	}
+	model.Attributes = nil
+
+	unchecked := append([]modelFile(nil), files...)
+	unchecked[0].checksum = ""
+	if err := download(unchecked); err == nil || !strings.Contains(err.Error(), "file checksum in the model manifest") {
+		t.Errorf("download of an archive without file checksums = %v", err)
+	}
+
+	tampered := append([]modelFile(nil), files...)
+	tampered[0].checksum = testChecksum("other")
+	if err := download(tampered); err == nil || !strings.Contains(err.Error(), "in the model archive") {
+		t.Errorf("download of a tampered archive file = %v", err)
+	}
+
+	missing := append(files, modelFile{name: "labels", path: modelFilePath(workDir, "labels.txt"), checksum: testChecksum("labels"), inArchive: true})
+	if err := download(missing); err == nil || !strings.Contains(err.Error(), "labels.txt is not in the model archive") {
+		t.Errorf("download of an archive missing a file = %v", err)
+	}
+}
+
+// TestDownloadExtractedArchive checks that the files of a local extracted
+// archive need checksums as those of a remote one.
+func TestDownloadExtractedArchive(t *testing.T) {
+	model, workDir, files := testDownload(t, map[string]string{"graph.pb": "graph"})
+	model.Model.IsArchive = true
+	files[0].inArchive = true
+	checksum := files[0].checksum
+	files[0].checksum = ""
+	err := downloadModel(context.Background(), testSpan(), model, workDir, files)
+	if err == nil || !strings.Contains(err.Error(), "Need graph.pb file checksum") {
+		t.Errorf("download of an extracted archive without checksums = %v", err)
+	}
+	files[0].checksum = checksum
+	if err := downloadModel(context.Background(), testSpan(), model, workDir, files); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,107 @@
+package predict
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+	"github.com/rai-project/dlframework/framework/options"
+)
+
+// Prediction is the probability computed for one output index.
+type Prediction struct {
+	Index       int
+	Probability float32
+}
+
+// Backend is the engine that runs a loaded model.
+type Backend interface {
+	// Predict runs a batch of CHW images and returns the outputs of every
+	// batch element one after the other.
+	Predict(input []float32, batchSize, channels, width, height int) ([]Prediction, error)
+	StartProfiling(name, metadata string) error
+	EndProfiling() error
+	// ReadProfile returns the profile recorded between StartProfiling and
+	// EndProfiling.
+	ReadProfile() (string, error)
+	Close() error
+}
+
+// TensorBackend is implemented by the backends that can run a model on named
+// tensors, as needed by the TensorPredictor.
+type TensorBackend interface {
+	Backend
+	// Run feeds the inputs to the model and returns the requested outputs.
+	Run(inputs map[string]*caffe2.TensorProto, outputs []string) (map[string]*caffe2.TensorProto, error)
+	// CheckTensors returns an error if the backend cannot run a model with
+	// these inputs and outputs.
+	CheckTensors(inputs []TensorInput, outputs []string) error
+}
+
+// BlobBackend is implemented by the backends that can return the blobs
+// computed during a forward pass.
+type BlobBackend interface {
+	Backend
+	// PredictBlobs is Predict also returning the named blobs.
+	PredictBlobs(input []float32, batchSize, channels, width, height int, blobs []string) ([]Prediction, map[string]*caffe2.TensorProto, error)
+}
+
+// VariableBatchBackend is implemented by the backends that can run batches
+// smaller than the configured batch size, the input of the other backends is
+// padded to the batch size.
+type VariableBatchBackend interface {
+	Backend
+	SupportsVariableBatch() bool
+}
+
+// sharingBackend is implemented by the backends that can create another
+// backend of the same model sharing its read-only weights, as the backends
+// of a pool do.
+type sharingBackend interface {
+	Backend
+	Share() (Backend, error)
+}
+
+// BackendFactory creates a backend, the graph and weights paths are given
+// through options.Graph and options.Weights.
+type BackendFactory func(opts ...options.Option) (Backend, error)
+
+// DefaultBackend is the backend used by the predictors that do not select one.
+var DefaultBackend = "gocaffe2"
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]BackendFactory{}
+)
+
+// RegisterBackend makes a backend available under the given name.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = factory
+}
+
+// Backends lists the registered backends.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewBackend creates the named backend.
+func NewBackend(name string, opts ...options.Option) (Backend, error) {
+	backendsMu.RLock()
+	factory, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("backend %s is not available, expecting one of %s", name, strings.Join(Backends(), ", "))
+	}
+	return factory(opts...)
+}
@@ -0,0 +1,300 @@
+package predict
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+	"github.com/rai-project/dlframework/framework/options"
+)
+
+// fakeBackend is a deterministic in-memory backend used to exercise the
+// predictors without the caffe2 library. Every batch element gets half of the
+// probability on the output selected by the sum of its values, the rest is
+// spread over the other outputs.
+type fakeBackend struct {
+	// NumOutputs is the number of outputs per batch element.
+	NumOutputs int
+	// VariableBatch makes the predictor skip the padding of partial batches.
+	VariableBatch bool
+
+	mu        sync.Mutex
+	calls     int
+	profiling bool
+	profile   []fakeProfileEntry
+	closed    bool
+	// held makes the forward passes wait until it is closed, see Hold.
+	held chan struct{}
+}
+
+type fakeProfileEntry struct {
+	Name      string `json:"name"`
+	Metadata  string `json:"metadata"`
+	Predicts  int    `json:"predicts"`
+	BatchSize int    `json:"batch_size"`
+}
+
+func newFakeBackend(numOutputs int) *fakeBackend {
+	return &fakeBackend{NumOutputs: numOutputs}
+}
+
+func (b *fakeBackend) Predict(input []float32, batchSize, channels, width, height int) ([]Prediction, error) {
+	b.wait()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, errors.New("fake backend is closed")
+	}
+	if b.NumOutputs <= 0 {
+		return nil, errors.New("fake backend has no outputs")
+	}
+	elementSize := channels * width * height
+	if batchSize <= 0 || len(input) != batchSize*elementSize {
+		return nil, errors.Errorf("expecting %d inputs for a batch of %d %dx%dx%d images, got %d", batchSize*elementSize, batchSize, channels, width, height, len(input))
+	}
+
+	b.calls++
+	if b.profiling {
+		entry := &b.profile[len(b.profile)-1]
+		entry.Predicts++
+		entry.BatchSize = batchSize
+	}
+
+	scores := b.scores(input, batchSize)
+	res := make([]Prediction, len(scores))
+	for ii, score := range scores {
+		res[ii] = Prediction{Index: ii % b.NumOutputs, Probability: score}
+	}
+	return res, nil
+}
+
+// PredictBlobs is Predict also returning the scores as every requested blob.
+func (b *fakeBackend) PredictBlobs(input []float32, batchSize, channels, width, height int, blobs []string) ([]Prediction, map[string]*caffe2.TensorProto, error) {
+	predictions, err := b.Predict(input, batchSize, channels, width, height)
+	if err != nil {
+		return nil, nil, err
+	}
+	scores := make([]float32, len(predictions))
+	for ii, pred := range predictions {
+		scores[ii] = pred.Probability
+	}
+	res := map[string]*caffe2.TensorProto{}
+	for _, name := range blobs {
+		res[name], err = caffe2.NewTensorProto(name, []int64{int64(batchSize), int64(b.NumOutputs)}, scores)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return predictions, res, nil
+}
+
+// scores computes the NumOutputs probabilities of every batch element.
+func (b *fakeBackend) scores(input []float32, batchSize int) []float32 {
+	elementSize := len(input) / batchSize
+	rest := float32(0)
+	if b.NumOutputs > 1 {
+		rest = 0.5 / float32(b.NumOutputs-1)
+	}
+	res := make([]float32, 0, batchSize*b.NumOutputs)
+	for ii := 0; ii < batchSize; ii++ {
+		sum := float64(0)
+		for _, v := range input[ii*elementSize : (ii+1)*elementSize] {
+			sum += float64(v)
+		}
+		top := int(math.Mod(math.Abs(math.Floor(sum)), float64(b.NumOutputs)))
+		for jj := 0; jj < b.NumOutputs; jj++ {
+			prob := rest
+			if jj == top {
+				prob = 1 - rest*float32(b.NumOutputs-1)
+			}
+			res = append(res, prob)
+		}
+	}
+	return res
+}
+
+// Run scores the inputs as Predict does, the outputs in name order scoring
+// the inputs in name order, cycling through the inputs when there are more
+// outputs.
+func (b *fakeBackend) Run(inputs map[string]*caffe2.TensorProto, outputs []string) (map[string]*caffe2.TensorProto, error) {
+	b.wait()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, errors.New("fake backend is closed")
+	}
+	if b.NumOutputs <= 0 {
+		return nil, errors.New("fake backend has no outputs")
+	}
+	if len(inputs) == 0 {
+		return nil, errors.New("no inputs")
+	}
+	inputNames := make([]string, 0, len(inputs))
+	for name := range inputs {
+		inputNames = append(inputNames, name)
+	}
+	sort.Strings(inputNames)
+	outputNames := append([]string{}, outputs...)
+	sort.Strings(outputNames)
+
+	res := map[string]*caffe2.TensorProto{}
+	batchSize := 0
+	for ii, name := range outputNames {
+		t, err := caffe2.DecodeTensor(inputs[inputNames[ii%len(inputNames)]])
+		if err != nil {
+			return nil, err
+		}
+		input, err := t.Float32s()
+		if err != nil {
+			return nil, err
+		}
+		batchSize = 1
+		if len(t.Dims) != 0 {
+			batchSize = int(t.Dims[0])
+		}
+		if batchSize <= 0 || len(input)%batchSize != 0 {
+			return nil, errors.Errorf("invalid input dimensions %v", t.Dims)
+		}
+		res[name], err = caffe2.NewTensorProto(name, []int64{int64(batchSize), int64(b.NumOutputs)}, b.scores(input, batchSize))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b.calls++
+	if b.profiling {
+		entry := &b.profile[len(b.profile)-1]
+		entry.Predicts++
+		entry.BatchSize = batchSize
+	}
+	return res, nil
+}
+
+// CheckTensors accepts any model.
+func (b *fakeBackend) CheckTensors(inputs []TensorInput, outputs []string) error {
+	return nil
+}
+
+func (b *fakeBackend) SupportsVariableBatch() bool {
+	return b.VariableBatch
+}
+
+func (b *fakeBackend) StartProfiling(name, metadata string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.profiling {
+		return errors.New("profiling already started")
+	}
+	b.profiling = true
+	b.profile = append(b.profile, fakeProfileEntry{Name: name, Metadata: metadata})
+	return nil
+}
+
+func (b *fakeBackend) EndProfiling() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.profiling {
+		return errors.New("profiling not started")
+	}
+	b.profiling = false
+	return nil
+}
+
+// ReadProfile returns the recorded profiling sessions as JSON.
+func (b *fakeBackend) ReadProfile() (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	buf, err := json.Marshal(b.profile)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (b *fakeBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+// Hold blocks the forward passes until the returned function is called.
+func (b *fakeBackend) Hold() func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	held := make(chan struct{})
+	b.held = held
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			b.held = nil
+			b.mu.Unlock()
+			close(held)
+		})
+	}
+}
+
+func (b *fakeBackend) wait() {
+	b.mu.Lock()
+	held := b.held
+	b.mu.Unlock()
+	if held != nil {
+		<-held
+	}
+}
+
+// Calls returns the number of successful Predict calls.
+func (b *fakeBackend) Calls() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.calls
+}
+
+// Closed reports whether Close was called.
+func (b *fakeBackend) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+// fakeFactory registers a backend creating fake backends and keeps them so
+// that the tests can inspect them.
+type fakeFactory struct {
+	name          string
+	numOutputs    int
+	variableBatch bool
+
+	mu       sync.Mutex
+	backends []*fakeBackend
+}
+
+var fakeFactories int32
+
+func registerFake(numOutputs int, variableBatch bool) *fakeFactory {
+	f := &fakeFactory{
+		name:          fmt.Sprintf("fake%d", atomic.AddInt32(&fakeFactories, 1)),
+		numOutputs:    numOutputs,
+		variableBatch: variableBatch,
+	}
+	RegisterBackend(f.name, func(opts ...options.Option) (Backend, error) {
+		b := newFakeBackend(f.numOutputs)
+		b.VariableBatch = f.variableBatch
+		f.mu.Lock()
+		f.backends = append(f.backends, b)
+		f.mu.Unlock()
+		return b, nil
+	})
+	return f
+}
+
+func (f *fakeFactory) created() []*fakeBackend {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*fakeBackend{}, f.backends...)
+}
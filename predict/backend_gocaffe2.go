@@ -0,0 +1,128 @@
+//go:build !nogocaffe2
+// +build !nogocaffe2
+
+package predict
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+	"github.com/rai-project/dlframework/framework/options"
+	gocaffe2 "github.com/rai-project/go-caffe2"
+)
+
+// gocaffe2Backend runs the models with the caffe2 C++ library.
+type gocaffe2Backend struct {
+	predictor *gocaffe2.Predictor
+	// output is the blob returned by the predictor, the only one that can be
+	// fetched.
+	output string
+}
+
+func newGocaffe2Backend(opts ...options.Option) (Backend, error) {
+	graph, err := readNetDef(string(options.New(opts...).Graph()))
+	if err != nil {
+		return nil, err
+	}
+	output := graphOutput(graph)
+	if output == "" {
+		return nil, errors.New("the model has no output")
+	}
+	pred, err := gocaffe2.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &gocaffe2Backend{predictor: pred, output: output}, nil
+}
+
+func (b *gocaffe2Backend) Predict(input []float32, batchSize, channels, width, height int) ([]Prediction, error) {
+	predictions, err := b.predictor.Predict(input, batchSize, channels, width, height)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Prediction, len(predictions))
+	for ii, pred := range predictions {
+		res[ii] = Prediction{
+			Index:       pred.Index,
+			Probability: pred.Probability,
+		}
+	}
+	return res, nil
+}
+
+// CheckTensors accepts the models with a single FLOAT NCHW input and a single
+// output, the output of the graph, which is all the gocaffe2 predictor exposes.
+func (b *gocaffe2Backend) CheckTensors(inputs []TensorInput, outputs []string) error {
+	if len(inputs) != 1 || len(outputs) != 1 {
+		return errors.Errorf("the gocaffe2 backend only supports models with one input and one output, got %d inputs and %d outputs", len(inputs), len(outputs))
+	}
+	if err := b.checkOutput(outputs[0]); err != nil {
+		return err
+	}
+	input := inputs[0]
+	if input.DataType != caffe2.TensorProto_FLOAT || len(input.Shape) != 4 {
+		return errors.Errorf("the gocaffe2 backend only supports a 4D FLOAT input, input %s is a %v tensor of shape %v", input.Name, input.DataType, input.Shape)
+	}
+	return nil
+}
+
+// Run supports the models accepted by CheckTensors. The outputs selected with
+// the Outputs option are only known at run time and checked here.
+func (b *gocaffe2Backend) Run(inputs map[string]*caffe2.TensorProto, outputs []string) (map[string]*caffe2.TensorProto, error) {
+	if len(inputs) != 1 || len(outputs) != 1 {
+		return nil, errors.New("the gocaffe2 backend only supports models with one input and one output")
+	}
+	if err := b.checkOutput(outputs[0]); err != nil {
+		return nil, err
+	}
+	var input *caffe2.TensorProto
+	for _, tensor := range inputs {
+		input = tensor
+	}
+	dims := input.GetDims()
+	if input.GetDataType() != caffe2.TensorProto_FLOAT || len(dims) != 4 || dims[0] <= 0 {
+		return nil, errors.Errorf("the gocaffe2 backend only supports a 4D FLOAT input, got a %v tensor of dimensions %v", input.GetDataType(), dims)
+	}
+	predictions, err := b.Predict(input.GetFloatData(), int(dims[0]), int(dims[1]), int(dims[2]), int(dims[3]))
+	if err != nil {
+		return nil, err
+	}
+	data := make([]float32, len(predictions))
+	for ii, pred := range predictions {
+		data[ii] = pred.Probability
+	}
+	output, err := caffe2.NewTensorProto(outputs[0], []int64{dims[0], int64(len(data)) / dims[0]}, data)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]*caffe2.TensorProto{outputs[0]: output}, nil
+}
+
+func (b *gocaffe2Backend) checkOutput(name string) error {
+	if name != b.output {
+		return errors.Errorf("the gocaffe2 backend can only fetch the output %s of the graph, not %s", b.output, name)
+	}
+	return nil
+}
+
+func (b *gocaffe2Backend) StartProfiling(name, metadata string) error {
+	return b.predictor.StartProfiling(name, metadata)
+}
+
+func (b *gocaffe2Backend) EndProfiling() error {
+	return b.predictor.EndProfiling()
+}
+
+// ReadProfile reads the profile and disables the profiler.
+func (b *gocaffe2Backend) ReadProfile() (string, error) {
+	defer b.predictor.DisableProfiling()
+	return b.predictor.ReadProfile()
+}
+
+func (b *gocaffe2Backend) Close() error {
+	b.predictor.Close()
+	return nil
+}
+
+func init() {
+	RegisterBackend("gocaffe2", newGocaffe2Backend)
+}
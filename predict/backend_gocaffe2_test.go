@@ -0,0 +1,66 @@
+//go:build !nogocaffe2
+// +build !nogocaffe2
+
+package predict
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rai-project/caffe2"
+	"github.com/rai-project/dlframework/framework/options"
+)
+
+func TestGocaffe2CheckTensors(t *testing.T) {
+	image := TensorInput{Name: "data", DataType: caffe2.TensorProto_FLOAT, Shape: []int64{-1, 3, 224, 224}}
+	cases := []struct {
+		name    string
+		inputs  []TensorInput
+		outputs []string
+		err     string
+	}{
+		{"image", []TensorInput{image}, []string{"prob"}, ""},
+		{"two inputs", []TensorInput{image, {Name: "rois", DataType: caffe2.TensorProto_FLOAT}}, []string{"prob"}, "got 2 inputs and 1 outputs"},
+		{"two outputs", []TensorInput{image}, []string{"prob", "bbox"}, "got 1 inputs and 2 outputs"},
+		{"int input", []TensorInput{{Name: "ids", DataType: caffe2.TensorProto_INT64, Shape: []int64{-1, 3, 2, 2}}}, []string{"prob"}, "input ids is a INT64 tensor"},
+		{"2D input", []TensorInput{{Name: "x", DataType: caffe2.TensorProto_FLOAT, Shape: []int64{-1, 128}}}, []string{"prob"}, "only supports a 4D FLOAT input"},
+		{"intermediate output", []TensorInput{image}, []string{"pool5"}, "can only fetch the output prob"},
+	}
+	b := &gocaffe2Backend{output: "prob"}
+	for _, c := range cases {
+		err := b.CheckTensors(c.inputs, c.outputs)
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%s: CheckTensors = %v", c.name, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("%s: CheckTensors = %v, want an error containing %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestGocaffe2IntermediateOutput(t *testing.T) {
+	model := testModel(t, "gocaffe2_outputs", 3, 3)
+	graphPath := filepath.Join(model.GetModel().GetBaseUrl(), model.GetModel().GetGraphPath())
+	backend, err := newGocaffe2Backend(options.Graph([]byte(graphPath)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	b := backend.(*gocaffe2Backend)
+	if b.output != "prob" {
+		t.Errorf("output of the backend = %s, want prob", b.output)
+	}
+
+	image := TensorInput{Name: "data", DataType: caffe2.TensorProto_FLOAT, Shape: []int64{-1, 3, 2, 2}}
+	if err := b.CheckTensors([]TensorInput{image}, []string{"fc"}); err == nil {
+		t.Error("CheckTensors accepted the intermediate blob fc")
+	}
+	input, err := caffe2.NewTensorProto("data", []int64{1, 3, 2, 2}, testImage(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Run(map[string]*caffe2.TensorProto{"data": input}, []string{"fc"}); err == nil || !strings.Contains(err.Error(), "not fc") {
+		t.Errorf("Run of the intermediate blob fc = %v, want an error", err)
+	}
+}
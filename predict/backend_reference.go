@@ -0,0 +1,190 @@
+package predict
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+	"github.com/rai-project/caffe2/executor"
+	"github.com/rai-project/dlframework/framework/options"
+)
+
+// referenceBackend runs the models with the pure Go executor. It is slow but
+// needs no native library.
+type referenceBackend struct {
+	exec *executor.Executor
+
+	mu        sync.Mutex
+	profiling bool
+	profile   []referenceProfileEntry
+}
+
+type referenceProfileEntry struct {
+	Name     string        `json:"name"`
+	Metadata string        `json:"metadata"`
+	Runs     int           `json:"runs"`
+	Duration time.Duration `json:"duration"`
+}
+
+func newReferenceBackend(opts ...options.Option) (Backend, error) {
+	o := options.New(opts...)
+	graph, err := readNetDef(string(o.Graph()))
+	if err != nil {
+		return nil, err
+	}
+	weights, err := readNetDef(string(o.Weights()))
+	if err != nil {
+		return nil, err
+	}
+	exec, err := executor.New(graph, weights)
+	if err != nil {
+		return nil, err
+	}
+	return &referenceBackend{exec: exec}, nil
+}
+
+func (b *referenceBackend) Predict(input []float32, batchSize, channels, width, height int) ([]Prediction, error) {
+	predictions, _, err := b.PredictBlobs(input, batchSize, channels, width, height, nil)
+	return predictions, err
+}
+
+func (b *referenceBackend) PredictBlobs(input []float32, batchSize, channels, width, height int, blobs []string) ([]Prediction, map[string]*caffe2.TensorProto, error) {
+	inputs := b.exec.Inputs()
+	if len(inputs) != 1 {
+		return nil, nil, errors.Errorf("expecting a model with one input, got %d", len(inputs))
+	}
+	outputs := b.exec.Outputs()
+	if len(outputs) == 0 {
+		return nil, nil, errors.New("the model has no output")
+	}
+	blob := &executor.Blob{
+		Dims: []int64{int64(batchSize), int64(channels), int64(width), int64(height)},
+		Data: input,
+	}
+	res, err := b.run(map[string]*executor.Blob{inputs[0]: blob}, append(outputs[:1:1], blobs...))
+	if err != nil {
+		return nil, nil, err
+	}
+	data := res[outputs[0]].Data
+	if len(data) == 0 || len(data)%batchSize != 0 {
+		return nil, nil, errors.Errorf("output %s has %d elements for a batch of %d", outputs[0], len(data), batchSize)
+	}
+	predictions := make([]Prediction, len(data))
+	length := len(data) / batchSize
+	for ii, v := range data {
+		predictions[ii] = Prediction{Index: ii % length, Probability: v}
+	}
+
+	tensors := make(map[string]*caffe2.TensorProto, len(blobs))
+	for _, name := range blobs {
+		tensor, err := res[name].TensorProto(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		tensors[name] = tensor
+	}
+	return predictions, tensors, nil
+}
+
+func (b *referenceBackend) Run(inputs map[string]*caffe2.TensorProto, outputs []string) (map[string]*caffe2.TensorProto, error) {
+	blobs := make(map[string]*executor.Blob, len(inputs))
+	for name, tensor := range inputs {
+		blob, err := executor.BlobFromTensor(tensor)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid input %s", name)
+		}
+		blobs[name] = blob
+	}
+	res, err := b.run(blobs, outputs)
+	if err != nil {
+		return nil, err
+	}
+	tensors := make(map[string]*caffe2.TensorProto, len(res))
+	for name, blob := range res {
+		tensor, err := blob.TensorProto(name)
+		if err != nil {
+			return nil, err
+		}
+		tensors[name] = tensor
+	}
+	return tensors, nil
+}
+
+// CheckTensors rejects the string inputs, the executor computes in float32.
+func (b *referenceBackend) CheckTensors(inputs []TensorInput, outputs []string) error {
+	for _, input := range inputs {
+		if input.DataType == caffe2.TensorProto_STRING {
+			return errors.Errorf("the reference backend does not support the string input %s", input.Name)
+		}
+	}
+	return nil
+}
+
+func (b *referenceBackend) run(inputs map[string]*executor.Blob, outputs []string) (map[string]*executor.Blob, error) {
+	start := time.Now()
+	res, err := b.exec.Run(inputs, outputs...)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	if b.profiling {
+		entry := &b.profile[len(b.profile)-1]
+		entry.Runs++
+		entry.Duration += time.Since(start)
+	}
+	b.mu.Unlock()
+	return res, nil
+}
+
+func (b *referenceBackend) StartProfiling(name, metadata string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.profiling {
+		return errors.New("profiling already started")
+	}
+	b.profiling = true
+	b.profile = append(b.profile, referenceProfileEntry{Name: name, Metadata: metadata})
+	return nil
+}
+
+func (b *referenceBackend) EndProfiling() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.profiling {
+		return errors.New("profiling not started")
+	}
+	b.profiling = false
+	return nil
+}
+
+func (b *referenceBackend) ReadProfile() (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	buf, err := json.Marshal(b.profile)
+	if err != nil {
+		return "", err
+	}
+	b.profile = nil
+	return string(buf), nil
+}
+
+// Share returns a backend running the same executor, the executor only reads
+// its weights and keeps the blobs of each run apart.
+func (b *referenceBackend) Share() (Backend, error) {
+	return &referenceBackend{exec: b.exec}, nil
+}
+
+func (b *referenceBackend) Close() error {
+	return nil
+}
+
+// SupportsVariableBatch ...
+func (b *referenceBackend) SupportsVariableBatch() bool {
+	return true
+}
+
+func init() {
+	RegisterBackend("reference", newReferenceBackend)
+}
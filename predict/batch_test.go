@@ -0,0 +1,90 @@
+package predict
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rai-project/dlframework/framework/options"
+)
+
+func TestPredictBatchSizes(t *testing.T) {
+	const batchSize = 4
+	for _, variable := range []bool{false, true} {
+		fake := registerFake(4, variable)
+		p := loadImagePredictor(t, testModel(t, "batch_sizes", 4, 4), fake.name, options.BatchSize(batchSize))
+		backend := fake.created()[0]
+
+		tests := []struct {
+			name   string
+			images int
+		}{
+			{"one image", 1},
+			{"partial batch", batchSize - 1},
+			{"full batch", batchSize},
+		}
+		for _, test := range tests {
+			data := make([][]float32, test.images)
+			for ii := range data {
+				data[ii] = testImage(float32(ii))
+			}
+			calls := backend.Calls()
+			features, err := p.Predict(context.Background(), data, TopK(1))
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if backend.Calls() != calls+1 {
+				t.Errorf("%s: %d forward passes, want 1", test.name, backend.Calls()-calls)
+			}
+			// the padding of the batch is dropped
+			if len(features) != test.images {
+				t.Errorf("%s: got the features of %d images, want %d", test.name, len(features), test.images)
+				continue
+			}
+			for ii, feature := range features {
+				if len(feature) != 1 || feature[0].Index != int64(ii%4) {
+					t.Errorf("%s: top feature of image %d = %+v, want index %d", test.name, ii, feature, ii%4)
+				}
+			}
+		}
+
+		// the backends that do not accept variable batches get a padded one
+		data := [][]float32{testImage(0)}
+		backend.StartProfiling("batch", "")
+		if _, err := p.Predict(context.Background(), data); err != nil {
+			t.Fatal(err)
+		}
+		backend.EndProfiling()
+		want := batchSize
+		if variable {
+			want = 1
+		}
+		if got := backend.profile[len(backend.profile)-1].BatchSize; got != want {
+			t.Errorf("variable batch %v: the backend ran a batch of %d, want %d", variable, got, want)
+		}
+
+		p.Close()
+	}
+}
+
+func TestPredictBatchErrors(t *testing.T) {
+	fake := registerFake(4, false)
+	p := loadImagePredictor(t, testModel(t, "batch_errors", 4, 4), fake.name, options.BatchSize(2))
+	defer p.Close()
+
+	tests := []struct {
+		name string
+		data [][]float32
+		err  string
+	}{
+		{"empty", nil, "no input to predict"},
+		{"too many images", [][]float32{testImage(0), testImage(0), testImage(0)}, "batch size is 2"},
+		{"image size", [][]float32{testImage(0), make([]float32, 5)}, "image 1 has 5 elements"},
+	}
+	for _, test := range tests {
+		_, err := p.Predict(context.Background(), test.data)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: Predict error = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package predict
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+)
+
+// batchRequest is a Predict call waiting to be part of a batch.
+type batchRequest struct {
+	ctx  context.Context
+	data [][]float32
+	res  chan batchResult
+}
+
+type batchResult struct {
+	predictions []Prediction
+	blobs       map[string]*caffe2.TensorProto
+	err         error
+}
+
+// batcher coalesces the concurrent Predict calls of an ImagePredictor. The
+// first call of a batch waits up to maxLatency for others, the batch is run
+// as soon as it holds maxBatch images. Each batch runs on its own backend of
+// the pool so that a batch can be collected while the previous one runs.
+type batcher struct {
+	predictor  *ImagePredictor
+	maxBatch   int
+	maxLatency time.Duration
+
+	requests chan *batchRequest
+	done     chan struct{}
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+func newBatcher(predictor *ImagePredictor, maxBatch int, maxLatency time.Duration) *batcher {
+	b := &batcher{
+		predictor:  predictor,
+		maxBatch:   maxBatch,
+		maxLatency: maxLatency,
+		requests:   make(chan *batchRequest),
+		done:       make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.loop()
+	return b
+}
+
+// predict queues the images and waits for their predictions, or for the
+// context to end. The images are checked before they are queued so that a
+// malformed call does not fail the calls batched with it.
+func (b *batcher) predict(ctx context.Context, data [][]float32) ([]Prediction, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if len(data) == 0 {
+		return nil, errors.New("no input to predict")
+	}
+	if len(data) > b.maxBatch {
+		return nil, errors.Errorf("got %d images but the batch size is %d", len(data), b.maxBatch)
+	}
+	if err := b.predictor.checkImages(data); err != nil {
+		return nil, err
+	}
+	req := &batchRequest{
+		ctx:  ctx,
+		data: data,
+		res:  make(chan batchResult, 1),
+	}
+	select {
+	case b.requests <- req:
+	case <-ctx.Done():
+		return nil, &TimeoutError{Op: "waiting to be batched", Err: ctx.Err()}
+	case <-b.done:
+		return nil, errors.New("predictor is closed")
+	}
+	select {
+	case res := <-req.res:
+		return res.predictions, res.err
+	case <-ctx.Done():
+		return nil, &TimeoutError{Op: "waiting for the batch predictions", Err: ctx.Err()}
+	}
+}
+
+func (b *batcher) loop() {
+	defer b.wg.Done()
+	var next *batchRequest
+	for {
+		if next == nil {
+			select {
+			case next = <-b.requests:
+			case <-b.done:
+				return
+			}
+		}
+		batch := []*batchRequest{next}
+		size := len(next.data)
+		next = nil
+
+		timer := time.NewTimer(b.maxLatency)
+	collect:
+		for size < b.maxBatch {
+			select {
+			case req := <-b.requests:
+				if size+len(req.data) > b.maxBatch {
+					next = req
+					break collect
+				}
+				batch = append(batch, req)
+				size += len(req.data)
+			case <-timer.C:
+				break collect
+			case <-b.done:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		b.wg.Add(1)
+		go b.run(batch)
+	}
+}
+
+// run predicts a batch and hands each request its predictions. The requests
+// whose context ended are dropped, the batch is canceled once all of them
+// ended.
+func (b *batcher) run(batch []*batchRequest) {
+	defer b.wg.Done()
+
+	live := batch[:0]
+	for _, req := range batch {
+		if err := checkContext(req.ctx, "waiting to be batched"); err != nil {
+			req.res <- batchResult{err: err}
+			continue
+		}
+		live = append(live, req)
+	}
+	if len(live) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if span := opentracing.SpanFromContext(live[0].ctx); span != nil {
+		ctx = opentracing.ContextWithSpan(ctx, span)
+	}
+	var mu sync.Mutex
+	remaining := len(live)
+	for _, req := range live {
+		go func(req *batchRequest) {
+			select {
+			case <-req.ctx.Done():
+				mu.Lock()
+				remaining--
+				if remaining == 0 {
+					cancel()
+				}
+				mu.Unlock()
+			case <-ctx.Done():
+			}
+		}(req)
+	}
+
+	var data [][]float32
+	for _, req := range live {
+		data = append(data, req.data...)
+	}
+	predictions, _, err := b.predictor.forward(ctx, data, predictOptions{})
+	if err != nil {
+		for _, req := range live {
+			req.res <- batchResult{err: err}
+		}
+		return
+	}
+
+	length := len(predictions) / len(data)
+	offset := 0
+	for _, req := range live {
+		end := offset + len(req.data)*length
+		req.res <- batchResult{predictions: predictions[offset:end]}
+		offset = end
+	}
+}
+
+// close stops batching and waits for the running batches.
+func (b *batcher) close() {
+	b.once.Do(func() {
+		close(b.done)
+	})
+	b.wg.Wait()
+}
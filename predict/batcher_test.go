@@ -0,0 +1,183 @@
+package predict
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rai-project/dlframework/framework/options"
+)
+
+// predictConcurrently runs one Predict call per element of images and returns
+// the top feature index of each image, by call.
+func predictConcurrently(t *testing.T, p *ImagePredictor, images [][][]float32) [][]int64 {
+	res := make([][]int64, len(images))
+	var wg sync.WaitGroup
+	for ii := range images {
+		wg.Add(1)
+		go func(ii int) {
+			defer wg.Done()
+			features, err := p.Predict(context.Background(), images[ii], TopK(1))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for _, f := range features {
+				res[ii] = append(res[ii], f[0].Index)
+			}
+		}(ii)
+	}
+	wg.Wait()
+	return res
+}
+
+func TestBatcherFillsBatch(t *testing.T) {
+	fake := registerFake(4, true)
+	// the batch is run as soon as it is full, long before the latency
+	p := loadImagePredictor(t, testModel(t, "batcher_full", 4, 4), fake.name, options.BatchSize(4), BatchLatency(time.Minute))
+	defer p.Close()
+
+	var images [][][]float32
+	for ii := 0; ii < 4; ii++ {
+		images = append(images, [][]float32{testImage(float32(ii))})
+	}
+	for ii, indices := range predictConcurrently(t, p, images) {
+		if len(indices) != 1 || indices[0] != int64(ii) {
+			t.Errorf("call %d got the top features %v, want [%d]", ii, indices, ii)
+		}
+	}
+	if calls := fake.created()[0].Calls(); calls != 1 {
+		t.Errorf("the 4 calls ran %d forward passes, want 1", calls)
+	}
+}
+
+func TestBatcherLatency(t *testing.T) {
+	fake := registerFake(4, false)
+	p := loadImagePredictor(t, testModel(t, "batcher_latency", 4, 4), fake.name, options.BatchSize(4), BatchLatency(20*time.Millisecond))
+	defer p.Close()
+
+	start := time.Now()
+	features, err := p.Predict(context.Background(), [][]float32{testImage(3)}, TopK(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("a lone call returned after %v, before the batch latency", elapsed)
+	}
+	if len(features) != 1 || features[0][0].Index != 3 {
+		t.Errorf("features = %v, want the top index 3", features)
+	}
+
+	// a call that would overflow the batch goes to the next one
+	res := predictConcurrently(t, p, [][][]float32{
+		{testImage(0), testImage(1), testImage(2)},
+		{testImage(3), testImage(1)},
+	})
+	if want := [][]int64{{0, 1, 2}, {3, 1}}; len(res[0]) != 3 || len(res[1]) != 2 || res[0][2] != want[0][2] || res[1][0] != want[1][0] {
+		t.Errorf("top features = %v, want %v", res, want)
+	}
+	if calls := fake.created()[0].Calls(); calls != 3 {
+		t.Errorf("ran %d forward passes, want 1 for the lone call and 2 for the overflowing calls", calls)
+	}
+
+	// full batches are not batched
+	if _, err := p.Predict(context.Background(), [][]float32{testImage(0), testImage(1), testImage(2), testImage(3)}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := fake.created()[0].Calls(); calls != 4 {
+		t.Errorf("ran %d forward passes, want 4", calls)
+	}
+}
+
+func TestBatcherMalformedRequest(t *testing.T) {
+	fake := registerFake(4, true)
+	p := loadImagePredictor(t, testModel(t, "batcher_malformed", 4, 4), fake.name, options.BatchSize(2), BatchLatency(50*time.Millisecond))
+	defer p.Close()
+
+	// the malformed call fails on its own, the valid call of the same batch
+	// gets its predictions
+	malformed := make(chan error, 1)
+	go func() {
+		_, err := p.Predict(context.Background(), [][]float32{testImage(1)[1:]})
+		malformed <- err
+	}()
+	res := predictConcurrently(t, p, [][][]float32{{testImage(2)}})
+	if err := <-malformed; err == nil || !strings.Contains(err.Error(), "image 0 has 11 elements") {
+		t.Errorf("malformed call = %v, want an error on its image size", err)
+	}
+	if len(res[0]) != 1 || res[0][0] != 2 {
+		t.Errorf("the valid call got %v, want [2]", res[0])
+	}
+	if calls := fake.created()[0].Calls(); calls != 1 {
+		t.Errorf("ran %d forward passes, want 1 for the valid call", calls)
+	}
+}
+
+func TestBatcherCancel(t *testing.T) {
+	fake := registerFake(4, true)
+	p := loadImagePredictor(t, testModel(t, "batcher_cancel", 4, 4), fake.name, options.BatchSize(4), BatchLatency(50*time.Millisecond))
+	defer p.Close()
+
+	// a call canceled while its batch is collected does not fail the others
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := p.Predict(ctx, [][]float32{testImage(1)})
+		canceled <- err
+	}()
+	result := make(chan []int64, 1)
+	go func() {
+		result <- predictConcurrently(t, p, [][][]float32{{testImage(2)}})[0]
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-canceled; !IsTimeout(err) {
+		t.Errorf("canceled call = %v, want a timeout", err)
+	}
+	if indices := <-result; len(indices) != 1 || indices[0] != 2 {
+		t.Errorf("the other call of the batch got %v, want [2]", indices)
+	}
+
+	// a call canceled during the forward pass returns at once
+	release := fake.created()[0].Hold()
+	defer release()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := p.Predict(ctx, [][]float32{testImage(1)}); !IsTimeout(err) {
+		t.Errorf("call canceled during its forward pass = %v, want a timeout", err)
+	}
+	release()
+	waitFor(t, "the backend to be released", func() bool { return p.PoolStats().InUse == 0 })
+	if _, err := p.Predict(context.Background(), [][]float32{testImage(1)}); err != nil {
+		t.Errorf("Predict after the cancel: %v", err)
+	}
+}
+
+func TestBatcherClose(t *testing.T) {
+	fake := registerFake(4, true)
+	p := loadImagePredictor(t, testModel(t, "batcher_close", 4, 4), fake.name, options.BatchSize(4), BatchLatency(time.Minute))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Predict(context.Background(), [][]float32{testImage(1)})
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// Close runs the pending batch and waits for it
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil && !strings.Contains(err.Error(), "closed") {
+			t.Errorf("call pending at Close = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call pending at Close never returned")
+	}
+	if _, err := p.Predict(context.Background(), [][]float32{testImage(1)}); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("Predict after Close = %v", err)
+	}
+}
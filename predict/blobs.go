@@ -0,0 +1,51 @@
+package predict
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+)
+
+// graphBlobs returns the blobs computed by the operators of the graph.
+func graphBlobs(graph *caffe2.NetDef) map[string]bool {
+	blobs := map[string]bool{}
+	for _, op := range graph.GetOp() {
+		for _, output := range op.GetOutput() {
+			blobs[output] = true
+		}
+	}
+	return blobs
+}
+
+// checkBlobs makes sure that every name is a blob computed by the graph.
+func checkBlobs(blobs map[string]bool, names []string) error {
+	var missing []string
+	for _, name := range names {
+		if !blobs[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return errors.Errorf("blobs %s are not computed by the graph", strings.Join(missing, ", "))
+}
+
+// trimBatch drops the padding added to a partial batch from a FLOAT blob whose
+// first dimension is the batch. Other blobs are returned unchanged.
+func trimBatch(blob *caffe2.TensorProto, batchSize, length int) *caffe2.TensorProto {
+	dims := blob.GetDims()
+	if length >= batchSize || len(dims) == 0 || dims[0] != int64(batchSize) || blob.GetDataType() != caffe2.TensorProto_FLOAT {
+		return blob
+	}
+	data := blob.GetFloatData()
+	elementSize := len(data) / batchSize
+	res, err := caffe2.NewTensorProto(blob.GetName(), append([]int64{int64(length)}, dims[1:]...), data[:length*elementSize])
+	if err != nil {
+		return blob
+	}
+	return res
+}
@@ -0,0 +1,101 @@
+package predict
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+	"github.com/rai-project/dlframework/framework/options"
+)
+
+func TestPredictBlobs(t *testing.T) {
+	fake := registerFake(4, false)
+	p := loadImagePredictor(t, testModel(t, "predict_blobs", 4, 4), fake.name, options.BatchSize(4))
+	defer p.Close()
+
+	images := [][]float32{testImage(1), testImage(2)}
+	features, blobs, err := p.PredictBlobs(context.Background(), images, FetchBlobs("fc"), TopK(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 2 || len(features[0]) != 1 || features[0][0].Index != 1 || features[1][0].Index != 2 {
+		t.Errorf("PredictBlobs features = %v, want the top 1 of each image", features)
+	}
+	fc, ok := blobs["fc"]
+	if !ok || len(blobs) != 1 {
+		t.Fatalf("PredictBlobs returned the blobs %v, want fc", blobs)
+	}
+	// the padding of the batch of 4 is dropped
+	if want := []int64{2, 4}; !reflect.DeepEqual(fc.GetDims(), want) {
+		t.Errorf("fc has dimensions %v, want %v", fc.GetDims(), want)
+	}
+	if data := fc.GetFloatData(); len(data) != 8 || data[1] != 0.5 || data[6] != 0.5 {
+		t.Errorf("fc = %v, want the scores of the 2 images", data)
+	}
+
+	if _, _, err := p.PredictBlobs(context.Background(), images, FetchBlobs("fc_w")); err == nil || !strings.Contains(err.Error(), "blobs fc_w are not computed by the graph") {
+		t.Errorf("PredictBlobs of a weight: %v", err)
+	}
+	features, err = p.Predict(context.Background(), images, FetchBlobs("fc"))
+	if err != nil || len(features) != 2 {
+		t.Errorf("Predict with FetchBlobs = %v, %v", features, err)
+	}
+}
+
+// TestPredictBlobsCanceled cancels a PredictBlobs whose forward pass is
+// running, the pass must not hand its blobs to the returned call.
+func TestPredictBlobsCanceled(t *testing.T) {
+	fake := registerFake(4, false)
+	p := loadImagePredictor(t, testModel(t, "predict_blobs_canceled", 4, 4), fake.name)
+	defer p.Close()
+	release := fake.created()[0].Hold()
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, blobs, err := p.PredictBlobs(ctx, [][]float32{testImage(1)}, FetchBlobs("fc"))
+		if blobs != nil {
+			err = errors.New("canceled call returned blobs")
+		}
+		done <- err
+	}()
+	for p.PoolStats().InUse == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !IsTimeout(err) {
+		t.Errorf("canceled PredictBlobs = %v, want a timeout", err)
+	}
+
+	release()
+	for p.PoolStats().InUse != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	_, blobs, err := p.PredictBlobs(context.Background(), [][]float32{testImage(1)}, FetchBlobs("fc"))
+	if err != nil || blobs["fc"] == nil {
+		t.Errorf("PredictBlobs after the cancel = %v, %v", blobs, err)
+	}
+}
+
+func TestPredictTensorsFetchBlobs(t *testing.T) {
+	fake := registerFake(3, false)
+	p := loadTensorPredictor(t, testFCModel(t, "tensor_blobs", ""), fake.name)
+	defer p.Close()
+
+	x := newTensor(t, "x", []int64{1, 4}, []float32{1, 0, 0, 0})
+	res, err := p.PredictTensors(context.Background(), map[string]*caffe2.TensorProto{"x": x}, FetchBlobs("y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res["y"]; !ok || len(res) != 1 {
+		t.Errorf("PredictTensors returned %v, want y", res)
+	}
+	if _, err := p.PredictTensors(context.Background(), map[string]*caffe2.TensorProto{"x": x}, FetchBlobs("x")); err == nil || !strings.Contains(err.Error(), "not computed by the graph") {
+		t.Errorf("PredictTensors fetching an input: %v", err)
+	}
+}
@@ -0,0 +1,564 @@
+package predict
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/rai-project/dlframework"
+)
+
+const (
+	// cacheIndexFile is the name of the index of the cache in its directory.
+	cacheIndexFile = "index.json"
+	// cacheLockFile is locked by every process reading or updating the index.
+	cacheLockFile = "index.lock"
+	// cacheModelsDir holds the work directories of the models, the cache
+	// never removes anything outside of it.
+	cacheModelsDir = "models"
+	// cacheRefsDir holds a directory of reference files by model.
+	cacheRefsDir = "refs"
+)
+
+// CacheEntry is a model version whose files are in the cache.
+type CacheEntry struct {
+	Name    string    `json:"name"`
+	Version string    `json:"version"`
+	WorkDir string    `json:"work_dir"`
+	Size    int64     `json:"size"`
+	LastUse time.Time `json:"last_use"`
+	// Checksums holds the manifest checksums of the files, by path relative
+	// to the work directory.
+	Checksums map[string]string `json:"checksums,omitempty"`
+	// Refs is the number of predictors, of every process, using the model.
+	Refs int `json:"-"`
+}
+
+// key identifies the entry in the index, the name and version are separated
+// by a byte that neither holds.
+func (e CacheEntry) key() string {
+	return e.Name + "\x00" + e.Version
+}
+
+// Cache tracks the work directories of the downloaded models in an index
+// file. The models that are not used by a predictor are evicted, least
+// recently used first, to keep the cache under its budget. The index is
+// reloaded and updated under a file lock so that the agents and the cache
+// commands can share it. Each predictor using a model holds a lock on a
+// reference file of the model, the models with a locked reference file are
+// never evicted and the files left by a process that died are ignored.
+type Cache struct {
+	dir    string
+	budget int64
+
+	mu      sync.Mutex
+	entries map[string]*CacheEntry
+	// refs holds the reference files of the models used by this process.
+	refs map[string][]*os.File
+}
+
+var (
+	defaultCache     *Cache
+	defaultCacheErr  error
+	defaultCacheOnce sync.Once
+)
+
+// DefaultCache returns the cache configured by caffe2.cache_dir and
+// caffe2.cache_budget. The cache is opt-in, without caffe2.cache_dir the
+// models stay in the work directories of the framework and are never evicted.
+func DefaultCache() (*Cache, error) {
+	if !cacheEnabled() {
+		return nil, errors.New("the model cache is disabled, set caffe2.cache_dir to enable it")
+	}
+	defaultCacheOnce.Do(func() {
+		var budget uint64
+		if Config.CacheBudget != "" {
+			budget, defaultCacheErr = humanize.ParseBytes(Config.CacheBudget)
+			if defaultCacheErr != nil {
+				defaultCacheErr = errors.Wrapf(defaultCacheErr, "invalid caffe2.cache_budget %q", Config.CacheBudget)
+				return
+			}
+		}
+		defaultCache, defaultCacheErr = OpenCache(Config.CacheDir, int64(budget))
+	})
+	return defaultCache, defaultCacheErr
+}
+
+// cacheEnabled reports whether the models are downloaded into the cache.
+func cacheEnabled() bool {
+	return Config.CacheDir != ""
+}
+
+// OpenCache opens the cache whose index is in dir. A budget of zero bytes
+// disables the eviction.
+func OpenCache(dir string, budget int64) (*Cache, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "cannot create the cache directory %s", dir)
+	}
+	c := &Cache{
+		dir:    dir,
+		budget: budget,
+		refs:   map[string][]*os.File{},
+	}
+	unlock, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	unlock()
+	return c, nil
+}
+
+// lock locks the index, against the other goroutines and processes, and
+// reloads it. The returned function unlocks it.
+func (c *Cache) lock() (func(), error) {
+	c.mu.Lock()
+	path := filepath.Join(c.dir, cacheLockFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		c.mu.Unlock()
+		return nil, errors.Wrapf(err, "cannot open the cache lock %s", path)
+	}
+	if err := lockFile(f, true); err != nil {
+		f.Close()
+		c.mu.Unlock()
+		return nil, errors.Wrapf(err, "cannot lock the cache lock %s", path)
+	}
+	unlock := func() {
+		// closing the file releases its lock
+		f.Close()
+		c.mu.Unlock()
+	}
+	if err := c.load(); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+func (c *Cache) load() error {
+	path := filepath.Join(c.dir, cacheIndexFile)
+	c.entries = map[string]*CacheEntry{}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot read the cache index %s", path)
+	}
+	var entries []*CacheEntry
+	if err := json.Unmarshal(buf, &entries); err != nil {
+		return errors.Wrapf(err, "invalid cache index %s", path)
+	}
+	for _, e := range entries {
+		c.entries[e.key()] = e
+	}
+	return nil
+}
+
+func (c *Cache) save() error {
+	entries := make([]*CacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(ii, jj int) bool {
+		return entries[ii].key() < entries[jj].key()
+	})
+	buf, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written next to the index and renamed so that readers never see a
+	// partial index
+	f, err := ioutil.TempFile(c.dir, "."+cacheIndexFile)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(c.dir, cacheIndexFile))
+}
+
+// cacheName returns the name of the directories of a model in the cache. The
+// name and version are lowercased and stripped down to portable characters,
+// which can map different models to the same name, so a hash of the exact
+// name and version is appended to tell them apart.
+func cacheName(name, version string) string {
+	sum := sha256.Sum256([]byte(CacheEntry{Name: name, Version: version}.key()))
+	readable := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, strings.ToLower(name+"_"+version))
+	return readable + "_" + hex.EncodeToString(sum[:6])
+}
+
+// workDir returns the work directory of a model in the cache.
+func (c *Cache) workDir(model dlframework.ModelManifest) (string, error) {
+	if model.GetName() == "" {
+		return "", errors.New("model has no name")
+	}
+	return filepath.Join(c.dir, cacheModelsDir, cacheName(model.GetName(), model.GetVersion())), nil
+}
+
+func (c *Cache) refsDir(e CacheEntry) string {
+	return filepath.Join(c.dir, cacheRefsDir, cacheName(e.Name, e.Version))
+}
+
+// owns reports whether path is a work directory of the cache, the only paths
+// the cache removes.
+func (c *Cache) owns(path string) bool {
+	rel, err := filepath.Rel(filepath.Join(c.dir, cacheModelsDir), path)
+	return err == nil && rel != "." && rel != ".." && !strings.ContainsRune(rel, filepath.Separator)
+}
+
+// modelWorkDir returns the work directory of a model in the default cache, or
+// the work directory given by the framework when the cache is disabled.
+func modelWorkDir(model dlframework.ModelManifest) (string, error) {
+	if !cacheEnabled() {
+		return model.WorkDir()
+	}
+	cache, err := DefaultCache()
+	if err != nil {
+		return "", err
+	}
+	return cache.workDir(model)
+}
+
+// downloadCached runs download with the model acquired in the default cache
+// and records its files once downloaded. The model must be released once the
+// predictor is closed. Without cache, download is run alone and the returned
+// cache is nil.
+func downloadCached(model dlframework.ModelManifest, workDir string, files []modelFile, download func() error) (*Cache, error) {
+	if !cacheEnabled() {
+		return nil, download()
+	}
+	cache, err := DefaultCache()
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.acquire(model, workDir); err != nil {
+		return nil, err
+	}
+	err = download()
+	if err == nil {
+		err = cache.downloaded(model, workDir, files)
+	}
+	if err != nil {
+		if releaseErr := cache.release(model); releaseErr != nil {
+			log.WithError(releaseErr).WithField("model", model.GetName()).Warn("failed to release the model from the cache")
+		}
+		return nil, err
+	}
+	return cache, nil
+}
+
+func (c *Cache) entry(model dlframework.ModelManifest) *CacheEntry {
+	e := &CacheEntry{Name: model.GetName(), Version: model.GetVersion()}
+	if old, ok := c.entries[e.key()]; ok {
+		return old
+	}
+	c.entries[e.key()] = e
+	return e
+}
+
+// acquire marks the model as used by a predictor, it is not evicted until
+// released.
+func (c *Cache) acquire(model dlframework.ModelManifest, workDir string) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	e := c.entry(model)
+	e.WorkDir = workDir
+	e.LastUse = time.Now()
+	ref, err := c.addRef(*e)
+	if err != nil {
+		return err
+	}
+	if err := c.save(); err != nil {
+		ref.Close()
+		os.Remove(ref.Name())
+		return err
+	}
+	c.refs[e.key()] = append(c.refs[e.key()], ref)
+	return nil
+}
+
+// addRef creates a reference file of the model and holds a shared lock on it
+// until the file is closed.
+func (c *Cache) addRef(e CacheEntry) (*os.File, error) {
+	dir := c.refsDir(e)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "cannot create the cache references directory %s", dir)
+	}
+	f, err := ioutil.TempFile(dir, fmt.Sprintf("%d-", os.Getpid()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot reference model %s version %s", e.Name, e.Version)
+	}
+	if err := lockFile(f, false); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, errors.Wrapf(err, "cannot lock the reference %s", f.Name())
+	}
+	return f, nil
+}
+
+// refCount returns the number of references to the model whose file is
+// locked, and removes those left by a process that died.
+func (c *Cache) refCount(e CacheEntry) (int, error) {
+	dir := c.refsDir(e)
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot read the references of model %s version %s", e.Name, e.Version)
+	}
+	refs := 0
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		held, err := refHeld(path)
+		if err != nil {
+			return 0, err
+		}
+		if held {
+			refs++
+			continue
+		}
+		os.Remove(path)
+	}
+	return refs, nil
+}
+
+// refHeld reports whether a reference file is locked.
+func refHeld(path string) (bool, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	held, err := fileLocked(f)
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot lock the reference %s", path)
+	}
+	return held, nil
+}
+
+// downloaded records the files of a model once they are downloaded, and
+// evicts the unused models if the cache is over its budget.
+func (c *Cache) downloaded(model dlframework.ModelManifest, workDir string, files []modelFile) error {
+	size, err := dirSize(workDir)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	e := c.entry(model)
+	e.WorkDir = workDir
+	e.Size = size
+	e.LastUse = time.Now()
+	e.Checksums = map[string]string{}
+	for _, file := range files {
+		if file.path == "" || file.checksum == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(workDir, file.path); err == nil {
+			e.Checksums[filepath.ToSlash(rel)] = file.checksum
+		}
+	}
+	if _, err := c.prune(c.budget); err != nil {
+		return err
+	}
+	return c.save()
+}
+
+// release releases a reference of this process to the model.
+func (c *Cache) release(model dlframework.ModelManifest) error {
+	key := CacheEntry{Name: model.GetName(), Version: model.GetVersion()}.key()
+	c.mu.Lock()
+	refs := c.refs[key]
+	if len(refs) == 0 {
+		c.mu.Unlock()
+		return errors.Errorf("model %s version %s is not acquired from the cache", model.GetName(), model.GetVersion())
+	}
+	ref := refs[len(refs)-1]
+	if len(refs) == 1 {
+		delete(c.refs, key)
+	} else {
+		c.refs[key] = refs[:len(refs)-1]
+	}
+	c.mu.Unlock()
+
+	err := os.Remove(ref.Name())
+	if closeErr := ref.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot release model %s version %s", model.GetName(), model.GetVersion())
+	}
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if e, ok := c.entries[key]; ok {
+		e.LastUse = time.Now()
+		return c.save()
+	}
+	return nil
+}
+
+// Entries returns the models of the cache, least recently used first.
+func (c *Cache) Entries() ([]CacheEntry, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return c.sortedEntries()
+}
+
+func (c *Cache) sortedEntries() ([]CacheEntry, error) {
+	entries := make([]CacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entry := *e
+		refs, err := c.refCount(entry)
+		if err != nil {
+			return nil, err
+		}
+		entry.Refs = refs
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(ii, jj int) bool {
+		if !entries[ii].LastUse.Equal(entries[jj].LastUse) {
+			return entries[ii].LastUse.Before(entries[jj].LastUse)
+		}
+		return entries[ii].key() < entries[jj].key()
+	})
+	return entries, nil
+}
+
+// Prune evicts the least recently used models that are not in use, by any
+// process, until the cache holds at most budget bytes, a negative budget
+// evicts every unused model. It returns the evicted models.
+func (c *Cache) Prune(budget int64) ([]CacheEntry, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	evicted, err := c.prune(budget)
+	if saveErr := c.save(); err == nil {
+		err = saveErr
+	}
+	return evicted, err
+}
+
+func (c *Cache) prune(budget int64) ([]CacheEntry, error) {
+	if budget == 0 {
+		return nil, nil
+	}
+	var total int64
+	for _, e := range c.entries {
+		total += e.Size
+	}
+	entries, err := c.sortedEntries()
+	if err != nil {
+		return nil, err
+	}
+	var evicted []CacheEntry
+	for _, e := range entries {
+		if budget > 0 && total <= budget {
+			break
+		}
+		if e.Refs > 0 {
+			continue
+		}
+		if !c.owns(e.WorkDir) {
+			log.WithField("model", e.Name).WithField("version", e.Version).WithField("path", e.WorkDir).
+				Warn("the work directory is not in the model cache, it is dropped from the index but not removed")
+			delete(c.entries, e.key())
+			total -= e.Size
+			continue
+		}
+		if err := os.RemoveAll(e.WorkDir); err != nil {
+			return evicted, errors.Wrapf(err, "cannot evict model %s version %s", e.Name, e.Version)
+		}
+		os.RemoveAll(c.refsDir(e))
+		log.WithField("model", e.Name).WithField("version", e.Version).Debug("evicted from the model cache")
+		delete(c.entries, e.key())
+		total -= e.Size
+		evicted = append(evicted, e)
+	}
+	if budget > 0 && total > budget {
+		log.Warnf("the model cache holds %s of models in use, more than its %s budget",
+			humanize.Bytes(uint64(total)), humanize.Bytes(uint64(budget)))
+	}
+	return evicted, nil
+}
+
+// Verify checks that the files of a model are in its work directory and match
+// their checksums.
+func (c *Cache) Verify(e CacheEntry) error {
+	if !isDir(e.WorkDir) {
+		return errors.Errorf("work directory %s is missing", e.WorkDir)
+	}
+	paths := make([]string, 0, len(e.Checksums))
+	for path := range e.Checksums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := verifyChecksum(filepath.Join(e.WorkDir, filepath.FromSlash(path)), e.Checksums[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirSize returns the size of the files in a directory.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,20 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package predict
+
+import (
+	"os"
+)
+
+// lockFile does not lock the file on this platform, the cache is then only
+// safe to share between the goroutines of a process.
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+// fileLocked cannot tell whether a reference file is used on this platform,
+// it is assumed to be so that a model in use is never evicted.
+func fileLocked(f *os.File) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,317 @@
+package predict
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rai-project/dlframework"
+)
+
+func openTestCache(t *testing.T, budget int64) *Cache {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenCache(dir, budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// cacheModel downloads a model of size bytes into the cache and returns its
+// manifest and work directory, the model is still acquired.
+func cacheModel(t *testing.T, c *Cache, name string, size int) (dlframework.ModelManifest, string) {
+	model := dlframework.ModelManifest{Name: name, Version: "1.0"}
+	workDir, err := c.workDir(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.acquire(model, workDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := modelFile{name: "graph", path: filepath.Join(workDir, "graph.pb")}
+	file.checksum = writeTestFile(t, file.path, make([]byte, size))
+	if err := c.downloaded(model, workDir, []modelFile{file}); err != nil {
+		t.Fatal(err)
+	}
+	return model, workDir
+}
+
+func cacheKeys(t *testing.T, c *Cache) []string {
+	entries, err := c.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.Name+":"+e.Version)
+	}
+	return keys
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := openTestCache(t, 250)
+	var workDirs []string
+	for _, name := range []string{"a", "b", "c"} {
+		model, workDir := cacheModel(t, c, name, 100)
+		if err := c.release(model); err != nil {
+			t.Fatal(err)
+		}
+		workDirs = append(workDirs, workDir)
+	}
+	// a, the least recently used, is evicted to fit c
+	if keys := cacheKeys(t, c); strings.Join(keys, " ") != "b:1.0 c:1.0" {
+		t.Errorf("cached models = %v, want b and c", keys)
+	}
+	if _, err := os.Stat(workDirs[0]); !os.IsNotExist(err) {
+		t.Errorf("the work directory of the evicted model is left")
+	}
+
+	entries, err := c.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := entries[0]; e.Size != 100 || e.Refs != 0 || c.Verify(e) != nil {
+		t.Errorf("entry = %+v", e)
+	}
+	writeTestFile(t, filepath.Join(workDirs[1], "graph.pb"), []byte("tampered"))
+	if err := c.Verify(entries[0]); err == nil {
+		t.Error("Verify of a tampered model succeeded")
+	}
+
+	evicted, err := c.Prune(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 2 || len(cacheKeys(t, c)) != 0 {
+		t.Errorf("Prune(-1) evicted %v", evicted)
+	}
+}
+
+// TestCachePruneInUse prunes the cache from another Cache, as the cache
+// commands do, while a model is in use.
+func TestCachePruneInUse(t *testing.T) {
+	c := openTestCache(t, 0)
+	model, workDir := cacheModel(t, c, "used", 100)
+	other, err := OpenCache(c.dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := other.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Refs != 1 {
+		t.Fatalf("entries = %+v, want the model used once", entries)
+	}
+	if evicted, err := other.Prune(-1); err != nil || len(evicted) != 0 {
+		t.Fatalf("Prune(-1) of a model in use = %v, %v", evicted, err)
+	}
+	if !isDir(workDir) {
+		t.Fatal("the work directory of the model in use was removed")
+	}
+
+	if err := c.release(model); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.release(model); err == nil || !strings.Contains(err.Error(), "is not acquired") {
+		t.Errorf("second release = %v", err)
+	}
+	if evicted, err := other.Prune(-1); err != nil || len(evicted) != 1 {
+		t.Fatalf("Prune(-1) of the released model = %v, %v", evicted, err)
+	}
+	if isDir(workDir) {
+		t.Error("the work directory of the evicted model is left")
+	}
+}
+
+// TestCacheStaleRef checks that the reference of a process that died does
+// not keep its model.
+func TestCacheStaleRef(t *testing.T) {
+	c := openTestCache(t, 0)
+	model, _ := cacheModel(t, c, "stale", 100)
+	if err := c.release(model); err != nil {
+		t.Fatal(err)
+	}
+	// an unlocked reference file
+	stale := filepath.Join(c.refsDir(CacheEntry{Name: "stale", Version: "1.0"}), "1-dead")
+	writeTestFile(t, stale, nil)
+
+	entries, err := c.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Refs != 0 {
+		t.Errorf("entries = %+v, want the model unused", entries)
+	}
+	if evicted, err := c.Prune(-1); err != nil || len(evicted) != 1 {
+		t.Errorf("Prune(-1) = %v, %v", evicted, err)
+	}
+}
+
+// TestCacheOutsidePaths checks that the cache never removes a directory that
+// is not one of its work directories, whatever its index holds.
+func TestCacheOutsidePaths(t *testing.T) {
+	c := openTestCache(t, 0)
+	outside, err := ioutil.TempDir("", "outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(outside, "keep"), []byte("keep"))
+	if err := os.MkdirAll(filepath.Join(c.dir, cacheModelsDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, workDir := range []string{outside, c.dir, filepath.Join(c.dir, cacheModelsDir), filepath.Join(c.dir, cacheModelsDir, "..", "..")} {
+		model := dlframework.ModelManifest{Name: "outside", Version: "1.0"}
+		if err := c.acquire(model, workDir); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.release(model); err != nil {
+			t.Fatal(err)
+		}
+		evicted, err := c.Prune(-1)
+		if err != nil || len(evicted) != 0 {
+			t.Errorf("Prune(-1) of %s = %v, %v", workDir, evicted, err)
+		}
+		if len(cacheKeys(t, c)) != 0 {
+			t.Errorf("%s is still in the index", workDir)
+		}
+		if !isDir(workDir) {
+			t.Fatalf("Prune removed %s", workDir)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "keep")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	c := openTestCache(t, 0)
+	other, err := OpenCache(c.dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for ii, cache := range []*Cache{c, other, c, other} {
+		wg.Add(1)
+		go func(ii int, cache *Cache) {
+			defer wg.Done()
+			model := dlframework.ModelManifest{Name: "concurrent", Version: string('a' + rune(ii%2))}
+			workDir, err := cache.workDir(model)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for jj := 0; jj < 10; jj++ {
+				if err := cache.acquire(model, workDir); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := cache.Prune(-1); err != nil {
+					t.Error(err)
+				}
+				if err := cache.release(model); err != nil {
+					t.Error(err)
+				}
+			}
+		}(ii, cache)
+	}
+	wg.Wait()
+	if keys := cacheKeys(t, c); len(keys) > 2 {
+		t.Errorf("cached models = %v", keys)
+	}
+}
+
+func TestLoadCache(t *testing.T) {
+	fake := registerFake(4, false)
+	model := testModel(t, "load_cache", 4, 4)
+	p := loadImagePredictor(t, model, fake.name)
+	cache, err := DefaultCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cache.owns(p.WorkDir) {
+		t.Errorf("work directory %s is not in the cache %s", p.WorkDir, cache.dir)
+	}
+	refs := func() int {
+		entries, err := cache.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if e.Name == "load_cache" {
+				return e.Refs
+			}
+		}
+		return -1
+	}
+	if n := refs(); n != 1 {
+		t.Errorf("the loaded model has %d references, want 1", n)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := refs(); n != 0 {
+		t.Errorf("the closed model has %d references, want 0", n)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestLoadWithoutCache(t *testing.T) {
+	cacheDir := Config.CacheDir
+	Config.CacheDir = ""
+	defer func() { Config.CacheDir = cacheDir }()
+
+	fake := registerFake(4, false)
+	model := testModel(t, "load_without_cache", 4, 4)
+	p := loadImagePredictor(t, model, fake.name)
+	defer p.Close()
+	// the models stay where the framework puts them
+	if workDir, _ := model.WorkDir(); p.WorkDir != workDir {
+		t.Errorf("work directory = %s, want %s", p.WorkDir, workDir)
+	}
+	if p.cache != nil {
+		t.Error("the model was added to the disabled cache")
+	}
+	if _, err := DefaultCache(); err == nil || !strings.Contains(err.Error(), "caffe2.cache_dir") {
+		t.Errorf("DefaultCache without cache directory = %v", err)
+	}
+}
+
+func TestCacheName(t *testing.T) {
+	models := [][2]string{
+		{"ResNet", "1.0"},
+		{"resnet", "1.0"},
+		{"a.b", "1.0"},
+		{"a_b", "1.0"},
+		{"a", "b_1.0"},
+		{"a_b", "_1.0"},
+		{"model/v2", "1.0"},
+		{"model_v2", "1.0"},
+	}
+	seen := map[string][2]string{}
+	for _, model := range models {
+		name := cacheName(model[0], model[1])
+		if other, ok := seen[name]; ok {
+			t.Errorf("%v and %v share the cache name %s", model, other, name)
+		}
+		seen[name] = model
+		if strings.ContainsAny(name, "/\\:") {
+			t.Errorf("cache name %s of %v is not a plain file name", name, model)
+		}
+	}
+	if name := cacheName("ResNet", "1.0"); name != cacheName("ResNet", "1.0") || !strings.HasPrefix(name, "resnet_1.0_") {
+		t.Errorf("cache name of ResNet 1.0 = %s", name)
+	}
+}
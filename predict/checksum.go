@@ -0,0 +1,85 @@
+package predict
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// checksum is a checksum of the model manifest, either "sha256:<hex>" or
+// "md5:<hex>". A checksum without prefix is an MD5 one.
+type checksum struct {
+	algorithm string
+	sum       string
+}
+
+func parseChecksum(s string) (checksum, error) {
+	s = strings.TrimSpace(s)
+	algorithm, sum := "md5", s
+	if ii := strings.Index(s, ":"); ii >= 0 {
+		algorithm, sum = strings.ToLower(s[:ii]), s[ii+1:]
+	}
+	var size int
+	switch algorithm {
+	case "md5":
+		size = md5.Size
+	case "sha256":
+		size = sha256.Size
+	default:
+		return checksum{}, errors.Errorf("unsupported checksum algorithm %s", algorithm)
+	}
+	if buf, err := hex.DecodeString(sum); err != nil || len(buf) != size {
+		return checksum{}, errors.Errorf("invalid %s checksum %q", algorithm, sum)
+	}
+	return checksum{algorithm: algorithm, sum: strings.ToLower(sum)}, nil
+}
+
+func (c checksum) hash() hash.Hash {
+	if c.algorithm == "sha256" {
+		return sha256.New()
+	}
+	return md5.New()
+}
+
+func (c checksum) String() string {
+	return c.algorithm + ":" + c.sum
+}
+
+// verifyChecksum checks the checksum of a file, an empty checksum is not
+// checked.
+func verifyChecksum(path, s string) error {
+	if s == "" {
+		return nil
+	}
+	c, err := parseChecksum(s)
+	if err != nil {
+		return err
+	}
+	sum, err := fileHash(path, c.hash())
+	if err != nil {
+		return err
+	}
+	if sum != c.sum {
+		return errors.Errorf("%s checksum of %s is %s, expecting %s", c.algorithm, path, sum, c.sum)
+	}
+	return nil
+}
+
+// fileHash returns the hex encoded hash of a file.
+func fileHash(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "cannot read %s", path)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,70 @@
+package predict
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseChecksum(t *testing.T) {
+	const (
+		md5Sum    = "0cc175b9c0f1b6a831c399e269772661"
+		sha256Sum = "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"
+	)
+	cases := []struct {
+		s    string
+		want string
+	}{
+		{md5Sum, "md5:" + md5Sum},
+		{" md5:" + strings.ToUpper(md5Sum) + "\n", "md5:" + md5Sum},
+		{"sha256:" + sha256Sum, "sha256:" + sha256Sum},
+		{"SHA256:" + sha256Sum, "sha256:" + sha256Sum},
+	}
+	for _, c := range cases {
+		sum, err := parseChecksum(c.s)
+		if err != nil {
+			t.Errorf("parseChecksum(%q): %v", c.s, err)
+			continue
+		}
+		if sum.String() != c.want {
+			t.Errorf("parseChecksum(%q) = %v, want %v", c.s, sum, c.want)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"sha1:86f7e437faa5a7fce15d1ddcb9eaeaea377667b8",
+		"sha256:" + md5Sum,
+		"md5:" + sha256Sum,
+		"md5:0cc175b9c0f1b6a831c399e26977266z",
+	} {
+		if _, err := parseChecksum(s); err == nil {
+			t.Errorf("parseChecksum(%q) succeeded", s)
+		}
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "a")
+	sum := writeTestFile(t, path, []byte("a"))
+
+	for _, s := range []string{sum, "md5:0cc175b9c0f1b6a831c399e269772661", "0cc175b9c0f1b6a831c399e269772661", ""} {
+		if err := verifyChecksum(path, s); err != nil {
+			t.Errorf("verifyChecksum(%q): %v", s, err)
+		}
+	}
+	if err := verifyChecksum(path, "md5:92eb5ffee6ae2fec3ad71c777531578f"); err == nil || !strings.Contains(err.Error(), "expecting 92eb5ffee6ae2fec3ad71c777531578f") {
+		t.Errorf("verifyChecksum of a wrong checksum = %v", err)
+	}
+	if err := verifyChecksum(filepath.Join(dir, "missing"), sum); err == nil {
+		t.Error("verifyChecksum of a missing file succeeded")
+	}
+	if err := verifyChecksum(path, "crc32:e8b7be43"); err == nil || !strings.Contains(err.Error(), "unsupported checksum algorithm") {
+		t.Errorf("verifyChecksum of an unsupported checksum = %v", err)
+	}
+}
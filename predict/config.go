@@ -0,0 +1,63 @@
+package predict
+
+import (
+	"time"
+
+	"github.com/k0kubun/pp"
+	"github.com/rai-project/config"
+	"github.com/rai-project/vipertags"
+)
+
+type caffe2Config struct {
+	PoolSize       int           `json:"pool_size" config:"caffe2.pool_size" default:"1"`
+	BatchLatency   time.Duration `json:"batch_latency" config:"caffe2.batch_latency" default:"0s"`
+	Offline        bool          `json:"offline" config:"caffe2.offline" default:"false"`
+	ModelStore     string        `json:"model_store" config:"caffe2.model_store" default:""`
+	TrustedKeys    []string      `json:"trusted_keys" config:"caffe2.trusted_keys"`
+	CacheDir       string        `json:"cache_dir" config:"caffe2.cache_dir" default:""`
+	CacheBudget    string        `json:"cache_budget" config:"caffe2.cache_budget" default:""`
+	MaxArchiveSize string        `json:"max_archive_size" config:"caffe2.max_archive_size" default:""`
+	done           chan struct{} `json:"-" config:"-"`
+}
+
+// Config holds the caffe2 section of the configuration file.
+var (
+	Config = &caffe2Config{
+		done: make(chan struct{}),
+	}
+)
+
+// ConfigName ...
+func (caffe2Config) ConfigName() string {
+	return "Caffe2"
+}
+
+// SetDefaults ...
+func (a *caffe2Config) SetDefaults() {
+	vipertags.SetDefaults(a)
+}
+
+// Read ...
+func (a *caffe2Config) Read() {
+	defer close(a.done)
+	vipertags.Fill(a)
+}
+
+// Wait ...
+func (c caffe2Config) Wait() {
+	<-c.done
+}
+
+// String ...
+func (c caffe2Config) String() string {
+	return pp.Sprintln(c)
+}
+
+// Debug ...
+func (c caffe2Config) Debug() {
+	log.Debug("Caffe2 Config = ", c)
+}
+
+func init() {
+	config.Register(Config)
+}
@@ -0,0 +1,318 @@
+package predict
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	olog "github.com/opentracing/opentracing-go/log"
+	"github.com/pkg/errors"
+	"github.com/rai-project/dlframework"
+	"github.com/rai-project/downloadmanager"
+)
+
+// modelFile is a file of a model listed in its manifest. The files of an
+// archived model are extracted from the archive unless they are not
+// inArchive.
+type modelFile struct {
+	name      string
+	url       string
+	path      string
+	checksum  string
+	inArchive bool
+}
+
+// downloadModel downloads the model archive, or each of the files when the
+// model is not archived, into workDir. Files given by a file:// url or an
+// absolute path are copied, and in offline mode every file is copied from the
+// model store. The md5 or sha256 checksums of the manifest are verified, those
+// of the files of an archived model once extracted, and the archive itself
+// when the manifest has an archive_checksum attribute. When trusted keys are
+// configured the detached signature of the model must verify, files without a
+// checksum are then accepted. The files are fetched into a staging directory
+// of the call and only moved into workDir once verified, a failed download
+// removes its staging directory and leaves workDir, and the concurrent
+// downloads into it, alone. A TimeoutError is returned once the context ends.
+func downloadModel(ctx context.Context, span opentracing.Span, model dlframework.ModelManifest, workDir string, files []modelFile) (err error) {
+	if err := checkContext(ctx, "download model"); err != nil {
+		return err
+	}
+	keys, err := trustedKeys()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if ctxErr := checkContext(ctx, "download model"); ctxErr != nil {
+			err = ctxErr
+		}
+	}()
+
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return errors.Wrapf(err, "cannot create the work directory %s", workDir)
+	}
+	stageDir, err := ioutil.TempDir(workDir, stageDirPrefix)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create a staging directory in %s", workDir)
+	}
+	defer func() {
+		if err := os.RemoveAll(stageDir); err != nil {
+			log.WithError(err).WithField("path", stageDir).Warn("failed to remove the download staging directory")
+		}
+	}()
+	staged, err := stageFiles(workDir, stageDir, files)
+	if err != nil {
+		return err
+	}
+
+	requireChecksum := len(keys) == 0
+	if err := fetchModelFiles(ctx, span, model, stageDir, staged, requireChecksum); err != nil {
+		return err
+	}
+	if len(keys) != 0 {
+		span.LogFields(
+			olog.String("event", "verify signature"),
+		)
+		signature := modelFile{name: "signature", url: signatureURL(model), path: modelFilePath(stageDir, signatureFile)}
+		if Config.Offline {
+			err = fetchModelFiles(ctx, span, model, stageDir, []modelFile{signature}, false)
+		} else {
+			err = fetchModelFile(ctx, span, signature)
+		}
+		if err != nil {
+			return errors.Wrap(err, "cannot get the model signature")
+		}
+		if err := verifySignature(signature.path, keys, stageDir, staged); err != nil {
+			return err
+		}
+	}
+	if err := checkContext(ctx, "download model"); err != nil {
+		return err
+	}
+	return commitDownload(stageDir, workDir)
+}
+
+// fetchModelFiles gets the files of the model into workDir and verifies their
+// checksums.
+func fetchModelFiles(ctx context.Context, span opentracing.Span, model dlframework.ModelManifest, workDir string, files []modelFile, requireChecksum bool) error {
+	if Config.Offline {
+		dir, err := modelStoreDir(model)
+		if err != nil {
+			return err
+		}
+		if !isDir(dir) {
+			return errors.Errorf("offline mode: model %s version %s is not in the model store %s", model.GetName(), model.GetVersion(), Config.ModelStore)
+		}
+		// the model store holds the files of archived models extracted
+		return copyLocalFiles(ctx, span, dir, workDir, files, requireChecksum)
+	}
+
+	if model.Model.IsArchive {
+		return fetchModelArchive(ctx, span, model, workDir, files, requireChecksum)
+	}
+
+	for _, file := range files {
+		if requireChecksum && file.checksum == "" {
+			return errors.Errorf("Need %s file checksum in the model manifest", file.name)
+		}
+		if err := checkContext(ctx, "download "+file.name); err != nil {
+			return err
+		}
+		if err := fetchModelFile(ctx, span, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchModelArchive downloads, or uses when it is local, the archive of the
+// model and extracts it into workDir. The files of the model must then be in
+// workDir and match their checksums, those that are not inArchive are fetched
+// on their own.
+func fetchModelArchive(ctx context.Context, span opentracing.Span, model dlframework.ModelManifest, workDir string, files []modelFile, requireChecksum bool) error {
+	baseURL := model.Model.BaseUrl
+	if dir, ok := localPath(baseURL); ok && isDir(dir) {
+		// an extracted archive
+		return copyLocalFiles(ctx, span, dir, workDir, files, requireChecksum)
+	}
+	for _, file := range files {
+		if requireChecksum && file.path != "" && (file.inArchive || file.url != "") && file.checksum == "" {
+			return errors.Errorf("Need %s file checksum in the model manifest", file.name)
+		}
+	}
+
+	archive := modelFile{
+		name:     "archive",
+		url:      baseURL,
+		path:     filepath.Join(workDir, archiveFile),
+		checksum: model.GetAttributes()["archive_checksum"],
+	}
+	archivePath, ok := localPath(baseURL)
+	if ok {
+		if err := verifyChecksum(archivePath, archive.checksum); err != nil {
+			return errors.Wrap(err, "invalid model archive")
+		}
+	} else {
+		defer os.Remove(archive.path)
+		if err := fetchModelFile(ctx, span, archive); err != nil {
+			return err
+		}
+		archivePath = archive.path
+	}
+
+	span.LogFields(
+		olog.String("event", "extract model archive"),
+	)
+	if err := extractArchive(ctx, archivePath, workDir); err != nil {
+		return errors.Wrapf(err, "failed to extract model archive from %v", baseURL)
+	}
+
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		if !file.inArchive {
+			if file.url == "" {
+				continue
+			}
+			if err := checkContext(ctx, "download "+file.name); err != nil {
+				return err
+			}
+			if err := fetchModelFile(ctx, span, file); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			rel, _ := filepath.Rel(workDir, file.path)
+			return errors.Errorf("%s file %s is not in the model archive", file.name, rel)
+		}
+		if err := verifyChecksum(file.path, file.checksum); err != nil {
+			return errors.Wrapf(err, "invalid %s file in the model archive", file.name)
+		}
+	}
+	return nil
+}
+
+// fetchModelFile downloads, or copies when it is local, a file of the model
+// and verifies its checksum.
+func fetchModelFile(ctx context.Context, span opentracing.Span, file modelFile) error {
+	if path, ok := localPath(file.url); ok {
+		span.LogFields(
+			olog.String("event", "copy "+file.name),
+		)
+		if err := copyModelFile(path, file.path, file.checksum); err != nil {
+			return errors.Wrapf(err, "%s file", file.name)
+		}
+		return nil
+	}
+
+	if file.checksum != "" && verifyChecksum(file.path, file.checksum) == nil {
+		// linked from the work directory by stageFiles
+		return nil
+	}
+	// a staged file is never written through
+	os.Remove(file.path)
+
+	opts := []downloadmanager.Option{downloadmanager.Context(ctx)}
+	var sum checksum
+	if file.checksum != "" {
+		var err error
+		if sum, err = parseChecksum(file.checksum); err != nil {
+			return errors.Wrapf(err, "invalid %s file checksum", file.name)
+		}
+		if sum.algorithm == "md5" {
+			opts = append(opts, downloadmanager.MD5Sum(sum.sum))
+		}
+	}
+	span.LogFields(
+		olog.String("event", "download "+file.name),
+	)
+	if _, err := downloadmanager.DownloadFile(file.url, file.path, opts...); err != nil {
+		// the file may have been partially overwritten
+		os.Remove(file.path)
+		return errors.Wrapf(err, "failed to download %s file from %v", file.name, file.url)
+	}
+	// md5 checksums are verified by the download manager
+	if sum.algorithm == "sha256" {
+		if err := verifyChecksum(file.path, file.checksum); err != nil {
+			os.Remove(file.path)
+			return errors.Wrapf(err, "%s file", file.name)
+		}
+	}
+	return nil
+}
+
+// stageDirPrefix starts the names of the staging directories of the
+// downloads in a work directory.
+const stageDirPrefix = ".download"
+
+// stageFiles returns the files with their path moved from workDir to
+// stageDir. The files already in workDir with a matching checksum are linked
+// into stageDir so that they are not fetched again, the fetches replace the
+// staged files instead of writing through them.
+func stageFiles(workDir, stageDir string, files []modelFile) ([]modelFile, error) {
+	staged := make([]modelFile, len(files))
+	for ii, file := range files {
+		staged[ii] = file
+		if file.path == "" {
+			continue
+		}
+		rel, err := filepath.Rel(workDir, file.path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, errors.Errorf("%s file %s is not in the work directory %s", file.name, file.path, workDir)
+		}
+		staged[ii].path = filepath.Join(stageDir, rel)
+		if file.checksum == "" || verifyChecksum(file.path, file.checksum) != nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(staged[ii].path), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.Link(file.path, staged[ii].path); err != nil {
+			log.WithError(err).WithField("path", file.path).Debug("cannot link the downloaded file, it is fetched again")
+		}
+	}
+	return staged, nil
+}
+
+// commitDownload moves the files fetched into stageDir to the same place in
+// workDir, replacing the files already there.
+func commitDownload(stageDir, workDir string) error {
+	return filepath.Walk(stageDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(stageDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(workDir, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if err := os.Rename(path, target); err != nil {
+			return errors.Wrapf(err, "cannot move the downloaded file %s into the work directory", rel)
+		}
+		return nil
+	})
+}
+
+// modelFileURL returns the url of a file of the model relative to its base url.
+func modelFileURL(model dlframework.ModelManifest, file string) string {
+	baseURL := model.GetModel().GetBaseUrl()
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return baseURL + file
+}
+
+// modelFilePath returns the path of a file of the model in the work directory.
+func modelFilePath(workDir, file string) string {
+	return filepath.Join(workDir, file)
+}
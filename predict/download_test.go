@@ -0,0 +1,138 @@
+package predict
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/rai-project/dlframework"
+)
+
+// testDownload writes the files of a model to a source directory and returns
+// a manifest and the modelFiles to download them into a new work directory.
+func testDownload(t *testing.T, contents map[string]string) (dlframework.ModelManifest, string, []modelFile) {
+	src, err := ioutil.TempDir("", "download_src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	workDir, err := ioutil.TempDir("", "download_work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := dlframework.ModelManifest{
+		Name:    "download",
+		Version: "1.0",
+		Model:   &dlframework.ModelManifest_Model{BaseUrl: src},
+	}
+	var files []modelFile
+	for name, content := range contents {
+		files = append(files, modelFile{
+			name:     name,
+			url:      modelFileURL(model, name),
+			path:     modelFilePath(workDir, name),
+			checksum: writeTestFile(t, filepath.Join(src, name), []byte(content)),
+		})
+	}
+	return model, workDir, files
+}
+
+func testSpan() opentracing.Span {
+	return opentracing.NoopTracer{}.StartSpan("test")
+}
+
+// stagingDirs returns the staging directories left in the work directory.
+func stagingDirs(t *testing.T, workDir string) []string {
+	matches, err := filepath.Glob(filepath.Join(workDir, stageDirPrefix+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestDownloadModel(t *testing.T) {
+	model, workDir, files := testDownload(t, map[string]string{"graph.pb": "graph", "weights.pb": "weights"})
+	if err := downloadModel(context.Background(), testSpan(), model, workDir, files); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if err := verifyChecksum(file.path, file.checksum); err != nil {
+			t.Errorf("%s: %v", file.name, err)
+		}
+	}
+	if dirs := stagingDirs(t, workDir); len(dirs) != 0 {
+		t.Errorf("staging directories %v are left in the work directory", dirs)
+	}
+
+	// the files already downloaded are kept as they are
+	before, err := os.Stat(files[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := downloadModel(context.Background(), testSpan(), model, workDir, files); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(files[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("a verified file was fetched again")
+	}
+}
+
+// TestDownloadModelFailure checks that a failed download only removes its own
+// files, those of the other downloads into the work directory are kept.
+func TestDownloadModelFailure(t *testing.T) {
+	model, workDir, files := testDownload(t, map[string]string{"graph.pb": "graph", "weights.pb": "weights"})
+	// a file of another model and the staging directory of a concurrent
+	// download
+	other := filepath.Join(workDir, "other.pb")
+	writeTestFile(t, other, []byte("other"))
+	concurrent := filepath.Join(workDir, stageDirPrefix+"concurrent")
+	if err := os.MkdirAll(concurrent, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(concurrent, "partial"), []byte("partial"))
+
+	for ii := range files {
+		if files[ii].name == "weights.pb" {
+			files[ii].checksum = files[1-ii].checksum
+		}
+	}
+	err := downloadModel(context.Background(), testSpan(), model, workDir, files)
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("download with a wrong checksum = %v", err)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file.path); !os.IsNotExist(err) {
+			t.Errorf("%s of the failed download is in the work directory", file.name)
+		}
+	}
+	for _, path := range []string{other, filepath.Join(concurrent, "partial")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("the failed download removed %s: %v", path, err)
+		}
+	}
+	if dirs := stagingDirs(t, workDir); len(dirs) != 1 || dirs[0] != concurrent {
+		t.Errorf("staging directories = %v, want only the concurrent one", dirs)
+	}
+}
+
+func TestDownloadModelCanceled(t *testing.T) {
+	model, workDir, files := testDownload(t, map[string]string{"graph.pb": "graph"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := downloadModel(ctx, testSpan(), model, workDir, files); !IsTimeout(err) {
+		t.Errorf("canceled download = %v, want a timeout", err)
+	}
+	if _, err := os.Stat(files[0].path); !os.IsNotExist(err) {
+		t.Error("the canceled download left its file")
+	}
+	if dirs := stagingDirs(t, workDir); len(dirs) != 0 {
+		t.Errorf("staging directories %v are left in the work directory", dirs)
+	}
+}
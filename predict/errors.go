@@ -0,0 +1,43 @@
+package predict
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// TimeoutError is returned when the context of Load or Predict is canceled or
+// its deadline is exceeded. Err is the error of the context.
+type TimeoutError struct {
+	Op  string
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+// Timeout reports whether the deadline of the context was exceeded, rather
+// than the context being canceled.
+func (e *TimeoutError) Timeout() bool {
+	return e.Err == context.DeadlineExceeded
+}
+
+// Unwrap returns the error of the context.
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// IsTimeout reports whether err, or its cause, is a TimeoutError.
+func IsTimeout(err error) bool {
+	_, ok := errors.Cause(err).(*TimeoutError)
+	return ok
+}
+
+// checkContext returns a TimeoutError for op once the context has ended.
+func checkContext(ctx context.Context, op string) error {
+	if ctx == nil || ctx.Err() == nil {
+		return nil
+	}
+	return &TimeoutError{Op: op, Err: ctx.Err()}
+}
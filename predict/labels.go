@@ -0,0 +1,230 @@
+package predict
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Label names one output of a model. ID holds the synset (e.g. n01440764)
+// when the labels file provides one.
+type Label struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+var synsetRe = regexp.MustCompile(`^(n\d{8})\s+(.*)$`)
+
+// ReadLabels reads a labels file. Files ending in .json hold either a list of
+// names or {"id", "name"} objects, or a map from index to a name or an
+// [id, name] pair. Files ending in .csv hold index,name, id,name or
+// index,id,name rows with an optional header. Any other file holds one label
+// per line, synset lines like "n01440764 tench" are split into id and name.
+func ReadLabels(path string) ([]Label, error) {
+	return readLabels(path, filepath.Ext(path))
+}
+
+// readLabels reads a labels file in the format given by the extension ext.
+func readLabels(path, ext string) ([]Label, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", path)
+	}
+	var labels []Label
+	switch strings.ToLower(ext) {
+	case ".json":
+		labels, err = parseJSONLabels(buf)
+	case ".csv":
+		labels, err = parseCSVLabels(buf)
+	default:
+		labels, err = parseTextLabels(buf)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid labels file %s", path)
+	}
+	return labels, nil
+}
+
+// urlExt returns the extension of the path of a URL, or of a local path.
+func urlExt(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Path != "" {
+		return path.Ext(u.Path)
+	}
+	return filepath.Ext(rawURL)
+}
+
+func parseTextLabels(buf []byte) ([]Label, error) {
+	var labels []Label
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		labels = append(labels, parseLabel(scanner.Text()))
+	}
+	return labels, scanner.Err()
+}
+
+func parseLabel(line string) Label {
+	line = strings.TrimRight(line, "\r")
+	if m := synsetRe.FindStringSubmatch(line); m != nil {
+		return Label{ID: m[1], Name: strings.TrimSpace(m[2])}
+	}
+	return Label{Name: line}
+}
+
+func parseJSONLabels(buf []byte) ([]Label, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(buf, &list); err == nil {
+		labels := make([]Label, len(list))
+		for ii, raw := range list {
+			label, err := parseJSONLabel(raw)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid label %d", ii)
+			}
+			labels[ii] = label
+		}
+		return labels, nil
+	}
+
+	var byIndex map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &byIndex); err != nil {
+		return nil, errors.New("expecting a JSON list or object")
+	}
+	labels := map[int]Label{}
+	for key, raw := range byIndex {
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 {
+			return nil, errors.Errorf("invalid label index %q", key)
+		}
+		label, err := parseJSONLabel(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid label %d", index)
+		}
+		labels[index] = label
+	}
+	return denseLabels(labels)
+}
+
+func parseJSONLabel(raw json.RawMessage) (Label, error) {
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		return parseLabel(name), nil
+	}
+	var pair []string
+	if err := json.Unmarshal(raw, &pair); err == nil {
+		if len(pair) != 2 {
+			return Label{}, errors.Errorf("expecting an [id, name] pair, got %d values", len(pair))
+		}
+		return Label{ID: pair[0], Name: pair[1]}, nil
+	}
+	var label Label
+	if err := json.Unmarshal(raw, &label); err != nil {
+		return Label{}, errors.New("expecting a name, an [id, name] pair or an {\"id\", \"name\"} object")
+	}
+	return label, nil
+}
+
+func parseCSVLabels(buf []byte) ([]Label, error) {
+	reader := csv.NewReader(bytes.NewReader(buf))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	indexCol, idCol, nameCol := -1, -1, -1
+	labels := map[int]Label{}
+	for row := 0; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if row == 0 {
+			// a header names its columns
+			for ii, col := range record {
+				switch strings.ToLower(strings.TrimSpace(col)) {
+				case "index":
+					indexCol = ii
+				case "id", "synset":
+					idCol = ii
+				case "name", "label":
+					nameCol = ii
+				}
+			}
+			if nameCol >= 0 {
+				continue
+			}
+			indexCol, idCol = -1, -1
+			switch len(record) {
+			case 1:
+				nameCol = 0
+			case 2:
+				if _, err := strconv.Atoi(record[0]); err == nil {
+					indexCol = 0
+				} else {
+					idCol = 0
+				}
+				nameCol = 1
+			case 3:
+				indexCol, idCol, nameCol = 0, 1, 2
+			default:
+				return nil, errors.Errorf("unexpected %d columns", len(record))
+			}
+		}
+
+		field := func(col int) (string, error) {
+			if col >= len(record) {
+				return "", errors.Errorf("row %d has %d columns", row+1, len(record))
+			}
+			return strings.TrimSpace(record[col]), nil
+		}
+		index := len(labels)
+		if indexCol >= 0 {
+			s, err := field(indexCol)
+			if err != nil {
+				return nil, err
+			}
+			index, err = strconv.Atoi(s)
+			if err != nil || index < 0 {
+				return nil, errors.Errorf("invalid label index %q on row %d", s, row+1)
+			}
+		}
+		var label Label
+		if label.Name, err = field(nameCol); err != nil {
+			return nil, err
+		}
+		if idCol >= 0 {
+			if label.ID, err = field(idCol); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := labels[index]; ok {
+			return nil, errors.Errorf("duplicate label index %d on row %d", index, row+1)
+		}
+		labels[index] = label
+	}
+	return denseLabels(labels)
+}
+
+// denseLabels turns an index to label map into a list, every index up to the
+// largest one must be present.
+func denseLabels(labels map[int]Label) ([]Label, error) {
+	res := make([]Label, len(labels))
+	for index, label := range labels {
+		if index >= len(res) {
+			return nil, errors.Errorf("labels are not contiguous, index %d is larger than the %d labels", index, len(labels))
+		}
+		res[index] = label
+	}
+	return res, nil
+}
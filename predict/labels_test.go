@@ -0,0 +1,113 @@
+package predict
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadLabels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	synsets := []Label{{ID: "n01440764", Name: "tench, Tinca tinca"}, {ID: "n01443537", Name: "goldfish"}}
+	names := []Label{{Name: "cat"}, {Name: "dog"}}
+	cases := []struct {
+		file    string
+		content string
+		want    []Label
+	}{
+		{"names.txt", "cat\ndog\n", names},
+		{"crlf.txt", "cat\r\ndog\r\n", names},
+		{"synset.txt", "n01440764 tench, Tinca tinca\nn01443537 goldfish\n", synsets},
+		{"mixed.txt", "n01440764  tench\nbackground\n", []Label{{ID: "n01440764", Name: "tench"}, {Name: "background"}}},
+		{"list.json", `["cat", "dog"]`, names},
+		{"synset_list.json", `["n01440764 tench, Tinca tinca", "n01443537 goldfish"]`, synsets},
+		{"objects.json", `[{"id": "n01440764", "name": "tench, Tinca tinca"}, {"id": "n01443537", "name": "goldfish"}]`, synsets},
+		{"map.json", `{"1": "dog", "0": "cat"}`, names},
+		{"pairs.JSON", `{"0": ["n01440764", "tench, Tinca tinca"], "1": ["n01443537", "goldfish"]}`, synsets},
+		{"names.csv", "cat\ndog\n", names},
+		{"index.csv", "1,dog\n0,cat\n", names},
+		{"id.csv", "n01440764,\"tench, Tinca tinca\"\nn01443537,goldfish\n", synsets},
+		{"three.csv", "0,n01440764,\"tench, Tinca tinca\"\n1,n01443537,goldfish\n", synsets},
+		{"header.csv", "name,synset\n\"tench, Tinca tinca\",n01440764\ngoldfish,n01443537\n", synsets},
+		{"index_header.csv", "label,index\ndog,1\ncat,0\n", names},
+	}
+	for _, c := range cases {
+		path := filepath.Join(dir, c.file)
+		if err := ioutil.WriteFile(path, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		labels, err := ReadLabels(path)
+		if err != nil {
+			t.Errorf("ReadLabels(%s): %v", c.file, err)
+			continue
+		}
+		if !reflect.DeepEqual(labels, c.want) {
+			t.Errorf("ReadLabels(%s) = %+v, want %+v", c.file, labels, c.want)
+		}
+	}
+}
+
+func TestReadLabelsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		file    string
+		content string
+		err     string
+	}{
+		{"object.json", `{"a": "cat"}`, `invalid label index "a"`},
+		{"gap.json", `{"0": "cat", "2": "dog"}`, "not contiguous"},
+		{"pair.json", `[["n01440764"]]`, "expecting an [id, name] pair"},
+		{"number.json", `[1]`, "expecting a name"},
+		{"scalar.json", `"cat"`, "expecting a JSON list or object"},
+		{"duplicate.csv", "0,cat\n0,dog\n", "duplicate label index 0"},
+		{"negative.csv", "0,cat\n-1,dog\n", "invalid label index"},
+		{"columns.csv", "0,a,b,c\n", "unexpected 4 columns"},
+		{"short.csv", "index,id,name\n0,n01440764\n", "row 2 has 2 columns"},
+	}
+	for _, c := range cases {
+		path := filepath.Join(dir, c.file)
+		if err := ioutil.WriteFile(path, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadLabels(path); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("ReadLabels(%s) = %v, want an error containing %q", c.file, err, c.err)
+		}
+	}
+	if _, err := ReadLabels(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("ReadLabels of a missing file succeeded")
+	}
+}
+
+func TestPredictSynsetLabels(t *testing.T) {
+	fake := registerFake(3, false)
+	model := testModel(t, "synset_labels", 3, 3)
+	labelsPath := filepath.Join(model.Model.BaseUrl, "labels.json")
+	checksum := writeTestFile(t, labelsPath, []byte(`[["n01440764", "tench"], ["n01443537", "goldfish"], ["n01484850", "great white shark"]]`))
+	model.Output.Parameters["features_url"].Value = labelsPath
+	model.Output.Parameters["features_checksum"].Value = checksum
+
+	p := loadImagePredictor(t, model, fake.name)
+	defer p.Close()
+	features, err := p.Predict(context.Background(), [][]float32{testImage(1)}, TopK(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := features[0][0]
+	if top.Index != 1 || top.Name != "goldfish" || top.Metadata["id"] != "n01443537" {
+		t.Errorf("top feature = %+v, want goldfish with the id n01443537", top)
+	}
+}
@@ -0,0 +1,122 @@
+package predict
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	olog "github.com/opentracing/opentracing-go/log"
+	"github.com/pkg/errors"
+	"github.com/rai-project/dlframework"
+)
+
+// localPath returns the path of a file:// url or of an absolute path.
+func localPath(url string) (string, bool) {
+	if strings.HasPrefix(url, "file://") {
+		return filepath.FromSlash(strings.TrimPrefix(url, "file://")), true
+	}
+	if filepath.IsAbs(url) {
+		return url, true
+	}
+	return "", false
+}
+
+// modelStoreDir returns the directory of the model in the local model store,
+// <model_store>/<name>/<version>.
+func modelStoreDir(model dlframework.ModelManifest) (string, error) {
+	if Config.ModelStore == "" {
+		return "", errors.New("offline mode needs the caffe2.model_store configuration")
+	}
+	return filepath.Join(Config.ModelStore, model.GetName(), model.GetVersion()), nil
+}
+
+// copyLocalFiles copies the files of a model from dir, where they are laid
+// out as in the work directory. Missing checksums are an error only when
+// requireChecksum is set.
+func copyLocalFiles(ctx context.Context, span opentracing.Span, dir, workDir string, files []modelFile, requireChecksum bool) error {
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		if requireChecksum && file.checksum == "" {
+			return errors.Errorf("Need %s file checksum in the model manifest", file.name)
+		}
+		if err := checkContext(ctx, "copy "+file.name); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(workDir, file.path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return errors.Errorf("%s file %s is not in the work directory %s", file.name, file.path, workDir)
+		}
+		span.LogFields(
+			olog.String("event", "copy "+file.name),
+		)
+		if err := copyModelFile(filepath.Join(dir, rel), file.path, file.checksum); err != nil {
+			return errors.Wrapf(err, "%s file", file.name)
+		}
+	}
+	return nil
+}
+
+// copyModelFile copies a local file of the model to dst after verifying its
+// checksum, unless dst already holds it.
+func copyModelFile(src, dst, checksum string) error {
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return errors.Errorf("%s does not exist", src)
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.Errorf("%s is a directory", src)
+	}
+	if err := verifyChecksum(src, checksum); err != nil {
+		return err
+	}
+	if dstInfo, err := os.Stat(dst); err == nil {
+		if os.SameFile(info, dstInfo) {
+			return nil
+		}
+		if checksum != "" && verifyChecksum(dst, checksum) == nil {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// copy next to dst and rename so that dst is never left half written
+	out, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return errors.Wrapf(err, "cannot copy %s", src)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	if err := os.Rename(out.Name(), dst); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,144 @@
+package predict
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	cases := []struct {
+		url   string
+		path  string
+		local bool
+	}{
+		{"file:///models/alexnet/init_net.pb", "/models/alexnet/init_net.pb", true},
+		{"/models/alexnet/init_net.pb", "/models/alexnet/init_net.pb", true},
+		{"http://example.com/init_net.pb", "", false},
+		{"models/init_net.pb", "", false},
+	}
+	for _, c := range cases {
+		path, local := localPath(c.url)
+		if path != filepath.FromSlash(c.path) || local != c.local {
+			t.Errorf("localPath(%q) = %q, %v, want %q, %v", c.url, path, local, c.path, c.local)
+		}
+	}
+}
+
+func TestDownloadFileURL(t *testing.T) {
+	model, workDir, files := testDownload(t, map[string]string{"graph.pb": "graph", "weights.pb": "weights"})
+	model.Model.BaseUrl = "file://" + filepath.ToSlash(model.Model.BaseUrl)
+	for ii := range files {
+		files[ii].url = modelFileURL(model, files[ii].name)
+	}
+	if err := downloadModel(context.Background(), testSpan(), model, workDir, files); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if err := verifyChecksum(file.path, file.checksum); err != nil {
+			t.Errorf("%s: %v", file.name, err)
+		}
+	}
+
+	model, workDir, files = testDownload(t, map[string]string{"graph.pb": "graph"})
+	os.Remove(filepath.Join(model.Model.BaseUrl, "graph.pb"))
+	if err := downloadModel(context.Background(), testSpan(), model, workDir, files); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("download of a missing local file = %v", err)
+	}
+}
+
+// withOffline runs the test in offline mode with a model store.
+func withOffline(t *testing.T) string {
+	store, err := ioutil.TempDir("", "model_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	offline, modelStore := Config.Offline, Config.ModelStore
+	Config.Offline, Config.ModelStore = true, store
+	t.Cleanup(func() {
+		Config.Offline, Config.ModelStore = offline, modelStore
+		os.RemoveAll(store)
+	})
+	return store
+}
+
+func TestDownloadOffline(t *testing.T) {
+	model, workDir, files := testDownload(t, map[string]string{"graph.pb": "graph", "weights.pb": "weights"})
+	src := model.Model.BaseUrl
+	// the urls are never used offline
+	model.Model.BaseUrl = "http://models.invalid/download"
+	for ii := range files {
+		files[ii].url = modelFileURL(model, files[ii].name)
+	}
+	store := withOffline(t)
+
+	err := downloadModel(context.Background(), testSpan(), model, workDir, files)
+	if err == nil || !strings.Contains(err.Error(), "is not in the model store") {
+		t.Errorf("offline download of a model missing from the store = %v", err)
+	}
+
+	storeDir := filepath.Join(store, "download", "1.0")
+	if err := os.MkdirAll(storeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	copyTestFile(t, filepath.Join(src, "graph.pb"), filepath.Join(storeDir, "graph.pb"))
+	err = downloadModel(context.Background(), testSpan(), model, workDir, files)
+	if err == nil || !strings.Contains(err.Error(), "weights.pb does not exist") {
+		t.Errorf("offline download of a model missing a file = %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(storeDir, "weights.pb"), []byte("tampered"))
+	if err := downloadModel(context.Background(), testSpan(), model, workDir, files); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("offline download of a tampered file = %v", err)
+	}
+
+	copyTestFile(t, filepath.Join(src, "weights.pb"), filepath.Join(storeDir, "weights.pb"))
+	if err := downloadModel(context.Background(), testSpan(), model, workDir, files); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if err := verifyChecksum(file.path, file.checksum); err != nil {
+			t.Errorf("%s: %v", file.name, err)
+		}
+	}
+
+	Config.ModelStore = ""
+	if err := downloadModel(context.Background(), testSpan(), model, workDir, files); err == nil || !strings.Contains(err.Error(), "needs the caffe2.model_store") {
+		t.Errorf("offline download without a model store = %v", err)
+	}
+}
+
+func TestLoadOffline(t *testing.T) {
+	fake := registerFake(4, false)
+	model := testModel(t, "load_offline", 4, 4)
+	src := model.Model.BaseUrl
+	model.Model.BaseUrl = "http://models.invalid/load_offline"
+	model.Output.Parameters["features_url"].Value = "http://models.invalid/load_offline/labels.txt"
+
+	store := withOffline(t)
+	storeDir := filepath.Join(store, "load_offline", "1.0")
+	if err := os.MkdirAll(storeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the store holds the files as laid out in the work directory
+	copyTestFile(t, filepath.Join(src, "predict_net.pb"), filepath.Join(storeDir, "predict_net.pb"))
+	copyTestFile(t, filepath.Join(src, "init_net.pb"), filepath.Join(storeDir, "init_net.pb"))
+	copyTestFile(t, filepath.Join(src, "labels.txt"), filepath.Join(storeDir, "load_offline.features"))
+
+	p := loadImagePredictor(t, model, fake.name)
+	defer p.Close()
+	if _, err := p.Predict(context.Background(), [][]float32{testImage(1)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyTestFile(t *testing.T, src, dst string) {
+	buf, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dst, buf)
+}
@@ -0,0 +1,194 @@
+package predict
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	olog "github.com/opentracing/opentracing-go/log"
+	"github.com/pkg/errors"
+	"github.com/rai-project/dlframework/framework/options"
+)
+
+// PoolStats describes the use of the backends of a predictor.
+type PoolStats struct {
+	// Size is the number of backends.
+	Size int
+	// InUse is the number of backends running a prediction.
+	InUse int
+	// Waiting is the number of calls waiting for a backend.
+	Waiting int
+	// Acquired is the number of calls that got a backend.
+	Acquired int64
+	// Canceled is the number of calls whose context ended while waiting.
+	Canceled int64
+	// TotalWait is the time spent waiting by the calls that got a backend.
+	TotalWait time.Duration
+	// MaxWait is the longest wait of a call that got a backend.
+	MaxWait time.Duration
+}
+
+// MeanWait returns the mean time a call waited for a backend.
+func (s PoolStats) MeanWait() time.Duration {
+	if s.Acquired == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(s.Acquired)
+}
+
+// backendPool hands each of its backends to one caller at a time, the other
+// callers wait in line until a backend is released or their context ends.
+// The backends of a pool run the same graph and weights files, which are
+// downloaded and validated once by the predictor. The backends implementing
+// sharingBackend load the weights once for the whole pool.
+type backendPool struct {
+	backends chan Backend
+	all      []Backend
+	done     chan struct{}
+
+	mu     sync.Mutex
+	stats  PoolStats
+	closed bool
+}
+
+// newBackendPool creates size backends of the named kind, or as many as the
+// caffe2.pool_size configuration when size is not positive. The context is
+// checked before creating each backend.
+func newBackendPool(ctx context.Context, name string, size int, opts ...options.Option) (*backendPool, error) {
+	if size <= 0 {
+		size = Config.PoolSize
+	}
+	if size <= 0 {
+		size = 1
+	}
+	if name == "" {
+		name = DefaultBackend
+	}
+	pool := &backendPool{
+		backends: make(chan Backend, size),
+		done:     make(chan struct{}),
+		stats:    PoolStats{Size: size},
+	}
+	for ii := 0; ii < size; ii++ {
+		if err := checkContext(ctx, "create predictor"); err != nil {
+			pool.Close()
+			return nil, err
+		}
+		backend, err := newPoolBackend(pool.all, name, opts...)
+		if err != nil {
+			pool.Close()
+			return nil, errors.Wrapf(err, "cannot create backend %d of %d", ii+1, size)
+		}
+		pool.all = append(pool.all, backend)
+		pool.backends <- backend
+	}
+	return pool, nil
+}
+
+// newPoolBackend shares the weights of the first backend of the pool when it
+// is a sharingBackend, otherwise it loads the model again.
+func newPoolBackend(all []Backend, name string, opts ...options.Option) (Backend, error) {
+	if len(all) != 0 {
+		if shared, ok := all[0].(sharingBackend); ok {
+			return shared.Share()
+		}
+	}
+	return NewBackend(name, opts...)
+}
+
+// acquire waits for an idle backend. The backend must be given back with
+// release.
+func (p *backendPool) acquire(ctx context.Context) (Backend, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	start := time.Now()
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.New("predictor is closed")
+	}
+	p.stats.Waiting++
+	p.mu.Unlock()
+
+	select {
+	case backend := <-p.backends:
+		wait := time.Since(start)
+		p.mu.Lock()
+		p.stats.Waiting--
+		p.stats.InUse++
+		p.stats.Acquired++
+		p.stats.TotalWait += wait
+		if wait > p.stats.MaxWait {
+			p.stats.MaxWait = wait
+		}
+		p.mu.Unlock()
+		if span := opentracing.SpanFromContext(ctx); span != nil {
+			span.LogFields(
+				olog.String("event", "acquired predictor"),
+				olog.Int64("queue_wait_us", int64(wait/time.Microsecond)),
+			)
+		}
+		return backend, nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		p.stats.Waiting--
+		p.stats.Canceled++
+		p.mu.Unlock()
+		return nil, &TimeoutError{Op: "waiting for an idle predictor", Err: ctx.Err()}
+	case <-p.done:
+		p.mu.Lock()
+		p.stats.Waiting--
+		p.mu.Unlock()
+		return nil, errors.New("predictor is closed")
+	}
+}
+
+// release gives back a backend returned by acquire. Backends released after
+// Close are closed.
+func (p *backendPool) release(backend Backend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.InUse--
+	if p.closed {
+		backend.Close()
+		return
+	}
+	p.backends <- backend
+}
+
+// first returns a backend of the pool to query its capabilities.
+func (p *backendPool) first() Backend {
+	return p.all[0]
+}
+
+// Stats returns the current use of the pool.
+func (p *backendPool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// Close closes the idle backends, the busy ones are closed once released.
+func (p *backendPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+
+	var firstErr error
+	for {
+		select {
+		case backend := <-p.backends:
+			if err := backend.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		default:
+			return firstErr
+		}
+	}
+}
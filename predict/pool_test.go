@@ -0,0 +1,223 @@
+package predict
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rai-project/dlframework/framework/options"
+)
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBackendPool(t *testing.T) {
+	fake := registerFake(2, false)
+	pool, err := newBackendPool(context.Background(), fake.name, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backends := fake.created(); len(backends) != 2 || pool.Stats().Size != 2 {
+		t.Fatalf("pool of 2 created %d backends", len(backends))
+	}
+
+	first, err := pool.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatal("the pool handed the same backend twice")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := pool.acquire(ctx); !IsTimeout(err) {
+		t.Errorf("acquire of a busy pool = %v, want a timeout", err)
+	}
+
+	acquired := make(chan Backend)
+	go func() {
+		backend, err := pool.acquire(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- backend
+	}()
+	waitFor(t, "the call to wait", func() bool { return pool.Stats().Waiting == 1 })
+	pool.release(first)
+	if backend := <-acquired; backend != first {
+		t.Error("the waiting call did not get the released backend")
+	}
+
+	stats := pool.Stats()
+	if stats.InUse != 2 || stats.Waiting != 0 || stats.Acquired != 3 || stats.Canceled != 1 || stats.MaxWait <= 0 || stats.MeanWait() > stats.MaxWait {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, backend := range fake.created() {
+		if backend.Closed() {
+			t.Error("Close closed a busy backend")
+		}
+	}
+	if _, err := pool.acquire(context.Background()); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("acquire after Close = %v", err)
+	}
+	pool.release(first)
+	pool.release(second)
+	for ii, backend := range fake.created() {
+		if !backend.Closed() {
+			t.Errorf("backend %d released after Close is not closed", ii)
+		}
+	}
+}
+
+func TestBackendPoolClose(t *testing.T) {
+	fake := registerFake(2, false)
+	pool, err := newBackendPool(context.Background(), fake.name, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := pool.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a waiting call is woken up by Close
+	done := make(chan error)
+	go func() {
+		_, err := pool.acquire(context.Background())
+		done <- err
+	}()
+	waitFor(t, "the call to wait", func() bool { return pool.Stats().Waiting == 1 })
+	pool.Close()
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("waiting acquire after Close = %v", err)
+	}
+	pool.release(backend)
+}
+
+func TestBackendPoolSize(t *testing.T) {
+	fake := registerFake(2, false)
+	defer func(size int) { Config.PoolSize = size }(Config.PoolSize)
+	Config.PoolSize = 3
+	pool, err := newBackendPool(context.Background(), fake.name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if size := len(fake.created()); size != 3 {
+		t.Errorf("pool created %d backends, want the configured 3", size)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := newBackendPool(ctx, fake.name, 1); !IsTimeout(err) {
+		t.Errorf("newBackendPool with a canceled context = %v, want a timeout", err)
+	}
+	if _, err := newBackendPool(context.Background(), "unknown", 1); err == nil || !strings.Contains(err.Error(), "cannot create backend 1 of 1") {
+		t.Errorf("newBackendPool of an unknown backend = %v", err)
+	}
+}
+
+func TestPredictPool(t *testing.T) {
+	fake := registerFake(4, false)
+	p := loadImagePredictor(t, testModel(t, "predict_pool", 4, 4), fake.name, PoolSize(2))
+	defer p.Close()
+	backends := fake.created()
+	if len(backends) != 2 {
+		t.Fatalf("PoolSize(2) created %d backends", len(backends))
+	}
+	var releases []func()
+	for _, backend := range backends {
+		release := backend.Hold()
+		defer release()
+		releases = append(releases, release)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for ii := 0; ii < 3; ii++ {
+		wg.Add(1)
+		go func(ii int) {
+			defer wg.Done()
+			features, err := p.Predict(context.Background(), [][]float32{testImage(float32(ii))}, TopK(1))
+			if err == nil && features[0][0].Index != int64(ii) {
+				t.Errorf("call %d got the features %v", ii, features)
+			}
+			errs <- err
+		}(ii)
+	}
+	waitFor(t, "two running calls and one waiting", func() bool {
+		stats := p.PoolStats()
+		return stats.InUse == 2 && stats.Waiting == 1
+	})
+	for _, release := range releases {
+		release()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if calls := backends[0].Calls() + backends[1].Calls(); calls != 3 {
+		t.Errorf("the backends ran %d forward passes, want 3", calls)
+	}
+	// the backends are released once the calls got their predictions
+	waitFor(t, "the backends to be released", func() bool { return p.PoolStats().InUse == 0 })
+	if stats := p.PoolStats(); stats.Acquired != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Predict(context.Background(), [][]float32{testImage(1)}); err == nil {
+		t.Error("Predict after Close succeeded")
+	}
+}
+
+func TestBackendPoolSharesWeights(t *testing.T) {
+	model := testModel(t, "shared_weights", 3, 3)
+	dir := model.GetModel().GetBaseUrl()
+	pool, err := newBackendPool(context.Background(), "reference", 3,
+		options.Graph([]byte(filepath.Join(dir, model.GetModel().GetGraphPath()))),
+		options.Weights([]byte(filepath.Join(dir, model.GetModel().GetWeightsPath()))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if len(pool.all) != 3 {
+		t.Fatalf("pool of 3 created %d backends", len(pool.all))
+	}
+	first := pool.all[0].(*referenceBackend)
+	for ii, backend := range pool.all[1:] {
+		b := backend.(*referenceBackend)
+		if b == first {
+			t.Fatalf("backend %d is the first backend", ii+1)
+		}
+		if b.exec != first.exec {
+			t.Errorf("backend %d loaded the weights again", ii+1)
+		}
+	}
+}
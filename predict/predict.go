@@ -1,9 +1,10 @@
 package predict
 
 import (
-	"bufio"
+	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	context "context"
 
@@ -16,8 +17,6 @@ import (
 	"github.com/rai-project/dlframework/framework/agent"
 	"github.com/rai-project/dlframework/framework/options"
 	common "github.com/rai-project/dlframework/framework/predict"
-	"github.com/rai-project/downloadmanager"
-	gocaffe2 "github.com/rai-project/go-caffe2"
 	"github.com/rai-project/image"
 	"github.com/rai-project/image/types"
 	"github.com/rai-project/tracer"
@@ -27,30 +26,45 @@ import (
 // ImagePredictor ...
 type ImagePredictor struct {
 	common.ImagePredictor
-	features  []string
-	predictor *gocaffe2.Predictor
-	inputDims []uint32
+	labels      []Label
+	blobs       map[string]bool
+	backendName string
+	pool        *backendPool
+	batcher     *batcher
+	cache       *Cache
+	inputDims   []uint32
 }
 
 // New ...
 func New(model dlframework.ModelManifest, opts ...options.Option) (common.Predictor, error) {
-	modelInputs := model.GetInputs()
-	if len(modelInputs) != 1 {
-		return nil, errors.New("number of inputs not supported")
-	}
+	return NewWithBackend(model, "", opts...)
+}
 
-	firstInputType := modelInputs[0].GetType()
-	if strings.ToLower(firstInputType) != "image" {
-		return nil, errors.New("input type not supported")
+// NewWithBackend is like New but runs the model with the named backend
+// instead of DefaultBackend. Models with a single image input get an
+// ImagePredictor, the others a TensorPredictor.
+func NewWithBackend(model dlframework.ModelManifest, backend string, opts ...options.Option) (common.Predictor, error) {
+	if len(model.GetInputs()) == 0 {
+		return nil, errors.New("number of inputs not supported")
 	}
-
-	predictor := new(ImagePredictor)
-
+	predictor := &ImagePredictor{backendName: backend}
 	return predictor.Load(context.Background(), model, opts...)
 }
 
-// Load ...
+// isImageModel tells whether a model has a single image input.
+func isImageModel(model dlframework.ModelManifest) bool {
+	inputs := model.GetInputs()
+	return len(inputs) == 1 && strings.ToLower(inputs[0].GetType()) == "image"
+}
+
+// Load loads an image model. The agent only registers the ImagePredictor for
+// the framework, the other models are loaded by a TensorPredictor.
 func (p *ImagePredictor) Load(ctx context.Context, model dlframework.ModelManifest, opts ...options.Option) (common.Predictor, error) {
+	if !isImageModel(model) {
+		predictor := &TensorPredictor{backendName: p.backendName}
+		return predictor.Load(ctx, model, opts...)
+	}
+
 	span, ctx := tracer.StartSpanFromContext(ctx, tracer.STEP_TRACE, "Load")
 	defer span.Finish()
 
@@ -59,7 +73,7 @@ func (p *ImagePredictor) Load(ctx context.Context, model dlframework.ModelManife
 		return nil, err
 	}
 
-	workDir, err := model.WorkDir()
+	workDir, err := modelWorkDir(model)
 	if err != nil {
 		return nil, err
 	}
@@ -73,13 +87,18 @@ func (p *ImagePredictor) Load(ctx context.Context, model dlframework.ModelManife
 			},
 			WorkDir: workDir,
 		},
+		backendName: p.backendName,
 	}
 
-	if err = ip.download(ctx); err != nil {
+	ip.cache, err = downloadCached(model, workDir, ip.modelFiles(), func() error {
+		return ip.download(ctx)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	if err = ip.loadPredictor(ctx); err != nil {
+		ip.Close()
 		return nil, err
 	}
 
@@ -128,164 +147,451 @@ func (p *ImagePredictor) download(ctx context.Context) error {
 	)
 	defer span.Finish()
 
-	model := p.Model
-	if model.Model.IsArchive {
-		baseURL := model.Model.BaseUrl
-		span.LogFields(
-			olog.String("event", "download model archive"),
-		)
-		_, err := downloadmanager.DownloadInto(baseURL, p.WorkDir, downloadmanager.Context(ctx))
-		if err != nil {
-			return errors.Wrapf(err, "failed to download model archive from %v", model.Model.BaseUrl)
-		}
-		return nil
-	}
-	checksum := p.GetGraphChecksum()
-	if checksum == "" {
-		return errors.New("Need graph file checksum in the model manifest")
+	return downloadModel(ctx, span, p.Model, p.WorkDir, p.modelFiles())
+}
+
+func (p *ImagePredictor) modelFiles() []modelFile {
+	return []modelFile{
+		{name: "graph", url: p.GetGraphUrl(), path: p.GetGraphPath(), checksum: p.GetGraphChecksum(), inArchive: true},
+		{name: "weights", url: p.GetWeightsUrl(), path: p.GetWeightsPath(), checksum: p.GetWeightsChecksum(), inArchive: true},
+		{name: "features", url: p.GetFeaturesUrl(), path: p.GetFeaturesPath(), checksum: p.GetFeaturesChecksum()},
 	}
+}
+
+func (p *ImagePredictor) loadPredictor(ctx context.Context) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, tracer.STEP_TRACE, "LoadPredictor")
+	defer span.Finish()
 
 	span.LogFields(
-		olog.String("event", "download graph"),
+		olog.String("event", "read features"),
 	)
-	if _, err := downloadmanager.DownloadFile(p.GetGraphUrl(), p.GetGraphPath(), downloadmanager.MD5Sum(checksum)); err != nil {
+
+	// the features are downloaded to a .features file, their format is given
+	// by the extension of their URL
+	labels, err := readLabels(p.GetFeaturesPath(), urlExt(p.GetFeaturesUrl()))
+	if err != nil {
 		return err
 	}
+	p.labels = labels
 
-	checksum = p.GetWeightsChecksum()
-	if checksum == "" {
-		return errors.New("Need weights file checksum in the model manifest")
+	p.inputDims, err = p.GetImageDimensions()
+	if err != nil {
+		return err
 	}
 
 	span.LogFields(
-		olog.String("event", "download weights"),
+		olog.String("event", "validate graph"),
 	)
-	if _, err := downloadmanager.DownloadFile(p.GetWeightsUrl(), p.GetWeightsPath(), downloadmanager.MD5Sum(checksum)); err != nil {
+
+	graph, weights, err := validatePredictNet(p.Model.GetName(), p.GetGraphPath(), p.GetWeightsPath())
+	if err != nil {
 		return err
 	}
+	if err := p.checkLabels(graph, weights); err != nil {
+		return err
+	}
+	p.blobs = graphBlobs(graph)
 
-	checksum = p.GetFeaturesChecksum()
-	if checksum == "" {
-		return errors.New("Need features file checksum in the model manifest")
+	if err := checkContext(ctx, "load model"); err != nil {
+		return err
 	}
 
 	span.LogFields(
-		olog.String("event", "download features"),
+		olog.String("event", "creating predictor"),
 	)
-	if _, err := downloadmanager.DownloadFile(p.GetFeaturesUrl(), p.GetFeaturesPath(), downloadmanager.MD5Sum(checksum)); err != nil {
+
+	opts, err := p.GetPredictionOptions(ctx)
+	if err != nil {
 		return err
 	}
 
+	pool, err := newBackendPool(
+		ctx,
+		p.backendName,
+		predictOptionsFromContext(p.Options.Context()).poolSize,
+		options.WithOptions(opts),
+		options.Graph([]byte(p.GetGraphPath())),
+		options.Weights([]byte(p.GetWeightsPath())),
+	)
+	if err != nil {
+		return err
+	}
+	p.pool = pool
+
+	if latency := p.batchLatency(); latency > 0 && p.BatchSize() > 1 {
+		p.batcher = newBatcher(p, int(p.BatchSize()), latency)
+	}
+
 	return nil
 }
 
-func (p *ImagePredictor) loadPredictor(ctx context.Context) error {
-	span, ctx := tracer.StartSpanFromContext(ctx, tracer.STEP_TRACE, "LoadPredictor")
-	defer span.Finish()
+// batchLatency returns how long a call may wait for others to fill a batch.
+func (p *ImagePredictor) batchLatency() time.Duration {
+	if latency := predictOptionsFromContext(p.Options.Context()).batchLatency; latency != 0 {
+		return latency
+	}
+	return Config.BatchLatency
+}
 
-	span.LogFields(
-		olog.String("event", "read features"),
-	)
+// validatePredictNet checks the downloaded graph before it is handed to
+// caffe2, so that malformed files are reported instead of aborting. Only the
+// names and shapes of the weights are read, caffe2 loads their values. It
+// returns the graph and the shapes of the weights.
+func validatePredictNet(modelName, graphPath, weightsPath string) (*caffe2.NetDef, map[string][]int64, error) {
+	graph, err := readNetDef(graphPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	weights, err := readInitNetShapes(weightsPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	issues := caffe2.ValidatePredictNet(graph, weights)
+	for _, issue := range issues.Warnings() {
+		log.WithField("model", modelName).Warn(issue.String())
+	}
+	if err := issues.Err(); err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid graph %s", graphPath)
+	}
+	return graph, weights, nil
+}
+
+// checkLabels makes sure that every output of the model has a label, when the
+// output size can be inferred from the graph.
+func (p *ImagePredictor) checkLabels(graph *caffe2.NetDef, weights map[string][]int64) error {
+	size, ok := outputSize(graph, weights, p.inputDims)
+	if !ok {
+		log.WithField("model", p.Model.GetName()).Debug("unable to infer the output size, the labels are not checked")
+		return nil
+	}
+	switch {
+	case int64(len(p.labels)) < size:
+		return errors.Errorf("model has %d outputs but %s only has %d labels", size, p.GetFeaturesPath(), len(p.labels))
+	case int64(len(p.labels)) > size:
+		log.WithField("model", p.Model.GetName()).Warnf("model has %d outputs but %s has %d labels", size, p.GetFeaturesPath(), len(p.labels))
+	}
+	return nil
+}
+
+// outputSize infers the number of elements of the first output of the graph
+// for one image.
+func outputSize(graph *caffe2.NetDef, weights map[string][]int64, inputDims []uint32) (int64, bool) {
+	shapes := map[string][]int64{}
+	for name, dims := range weights {
+		if dims != nil {
+			shapes[name] = dims
+		}
+	}
+	var inputs []string
+	for _, input := range graph.GetExternalInput() {
+		if _, ok := weights[input]; !ok {
+			inputs = append(inputs, input)
+		}
+	}
+	if len(inputs) != 1 || len(inputDims) != 3 {
+		return 0, false
+	}
+	shapes[inputs[0]] = []int64{1, int64(inputDims[0]), int64(inputDims[1]), int64(inputDims[2])}
+
+	output := graphOutput(graph)
+	if output == "" {
+		return 0, false
+	}
+	inferred, err := caffe2.InferShapes(graph, shapes)
+	if err != nil {
+		return 0, false
+	}
+	shape := inferred.Lookup(output)
+	if shape == nil || shape.GetUnknownShape() || len(shape.GetUnknownDims()) != 0 || len(shape.GetDims()) == 0 {
+		return 0, false
+	}
+	size := int64(1)
+	for _, dim := range shape.GetDims()[1:] {
+		size *= dim
+	}
+	return size, true
+}
+
+// graphOutput returns the first external output of the graph, or the output
+// of its last operator, which is what the predictors return.
+func graphOutput(graph *caffe2.NetDef) string {
+	outputs := graph.GetExternalOutput()
+	if ops := graph.GetOp(); len(outputs) == 0 && len(ops) != 0 {
+		outputs = ops[len(ops)-1].GetOutput()
+	}
+	if len(outputs) == 0 {
+		return ""
+	}
+	return outputs[0]
+}
 
-	var features []string
-	f, err := os.Open(p.GetFeaturesPath())
+func readInitNetShapes(path string) (map[string][]int64, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return errors.Wrapf(err, "cannot read %s", p.GetFeaturesPath())
+		return nil, errors.Wrapf(err, "cannot read %s", path)
 	}
 	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		features = append(features, line)
+	shapes, err := caffe2.ReadInitNetShapes(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot unmarshal %s", path)
 	}
-	p.features = features
+	return shapes, nil
+}
 
-	p.inputDims, err = p.GetImageDimensions()
+func readNetDef(path string) (*caffe2.NetDef, error) {
+	buf, err := ioutil.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, errors.Wrapf(err, "cannot read %s", path)
 	}
+	net := &caffe2.NetDef{}
+	if err := net.Unmarshal(buf); err != nil {
+		return nil, errors.Wrapf(err, "cannot unmarshal %s", path)
+	}
+	return net, nil
+}
 
-	span.LogFields(
-		olog.String("event", "creating predictor"),
+// Predict runs a batch on one of the backends of the predictor, waiting for
+// an idle one until the context ends. It is safe for concurrent use. When a
+// batch latency is set, the calls with fewer images than the batch size are
+// coalesced with the concurrent ones into a single forward pass.
+func (p *ImagePredictor) Predict(ctx context.Context, data [][]float32, opts ...options.Option) ([]dlframework.Features, error) {
+	popts := predictOptionsFromContext(options.New(opts...).Context())
+	// the blobs are only returned by PredictBlobs
+	popts.fetchBlobs = nil
+
+	var (
+		predictions []Prediction
+		err         error
 	)
+	if p.batcher != nil && len(data) < int(p.BatchSize()) {
+		predictions, err = p.batcher.predict(ctx, data)
+	} else {
+		predictions, _, err = p.forward(ctx, data, popts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p.features(predictions, len(data), popts)
+}
 
-	opts, err := p.GetPredictionOptions(ctx)
+// PredictBlobs is Predict also returning the blobs fetched with the FetchBlobs
+// option by name. The first dimension of the blobs is the batch, their
+// padding is dropped. The calls are not batched with the others.
+func (p *ImagePredictor) PredictBlobs(ctx context.Context, data [][]float32, opts ...options.Option) ([]dlframework.Features, map[string]*caffe2.TensorProto, error) {
+	popts := predictOptionsFromContext(options.New(opts...).Context())
+	if err := checkBlobs(p.blobs, popts.fetchBlobs); err != nil {
+		return nil, nil, err
+	}
+	predictions, blobs, err := p.forward(ctx, data, popts)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	features, err := p.features(predictions, len(data), popts)
+	if err != nil {
+		return nil, nil, err
 	}
+	return features, blobs, nil
+}
 
-	pred, err := gocaffe2.New(
-		options.WithOptions(opts),
-		options.Graph([]byte(p.GetGraphPath())),
-		options.Weights([]byte(p.GetWeightsPath())),
-	)
+// forward runs the images on an idle backend and returns the predictions of
+// each image, one after the other, and the blobs fetched. It returns once the
+// context ends, the backend being released when its forward pass is done.
+func (p *ImagePredictor) forward(ctx context.Context, data [][]float32, popts predictOptions) ([]Prediction, map[string]*caffe2.TensorProto, error) {
+	if err := checkContext(ctx, "predict"); err != nil {
+		return nil, nil, err
+	}
+	backend, err := p.pool.acquire(ctx)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	p.predictor = pred
 
-	return nil
+	done := make(chan batchResult, 1)
+	go func() {
+		defer p.pool.release(backend)
+		predictions, blobs, err := p.run(ctx, backend, data, popts)
+		done <- batchResult{predictions: predictions, blobs: blobs, err: err}
+	}()
+	select {
+	case res := <-done:
+		return res.predictions, res.blobs, res.err
+	case <-ctx.Done():
+		return nil, nil, checkContext(ctx, "predict")
+	}
 }
 
-// Predict ...
-func (p *ImagePredictor) Predict(ctx context.Context, data [][]float32, opts ...options.Option) ([]dlframework.Features, error) {
+// run predicts the images on the backend.
+func (p *ImagePredictor) run(ctx context.Context, backend Backend, data [][]float32, popts predictOptions) ([]Prediction, map[string]*caffe2.TensorProto, error) {
 	if p.TraceLevel() >= tracer.FRAMEWORK_TRACE {
-		if err := p.predictor.StartProfiling("caffe2", "predict"); err == nil {
+		if err := backend.StartProfiling("caffe2", "predict"); err == nil {
 			defer func() {
-				p.predictor.EndProfiling()
-				profBuffer, err := p.predictor.ReadProfile()
+				backend.EndProfiling()
+				profBuffer, err := backend.ReadProfile()
 				if err != nil {
 					return
 				}
 				if t, err := ctimer.New(profBuffer); err == nil {
 					t.Publish(ctx)
 				}
-				p.predictor.DisableProfiling()
 			}()
 		}
 	}
 
-	var input []float32
-	for _, v := range data {
-		input = append(input, v...)
+	input, batchSize, err := p.batchInput(backend, data)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	predictions, err := p.predictor.Predict(input, int(p.BatchSize()), int(p.inputDims[0]), int(p.inputDims[1]), int(p.inputDims[2]))
+	predictions, blobs, err := p.predict(backend, input, batchSize, len(data), popts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	if len(predictions)%batchSize != 0 {
+		return nil, nil, errors.Errorf("got %d predictions for a batch of %d images", len(predictions), batchSize)
+	}
+	// the padding added by batchInput is dropped
+	return predictions[:len(predictions)/batchSize*len(data)], blobs, nil
+}
 
+// features turns the predictions of n images into labeled features.
+func (p *ImagePredictor) features(predictions []Prediction, n int, popts predictOptions) ([]dlframework.Features, error) {
 	var output []dlframework.Features
-	batchSize := int(p.BatchSize())
 
-	length := len(predictions) / batchSize
-	for i := 0; i < batchSize; i++ {
-		rprobs := make([]*dlframework.Feature, length)
+	length := len(predictions) / n
+	if length > len(p.labels) {
+		return nil, errors.Errorf("model has %d outputs but only %d labels", length, len(p.labels))
+	}
+	for i := 0; i < n; i++ {
+		probs := make([]Prediction, length)
 		for j := 0; j < length; j++ {
-			rprobs[j] = &dlframework.Feature{
-				Index:       int64(j),
-				Name:        p.features[j],
+			probs[j] = Prediction{
+				Index:       j,
 				Probability: predictions[i*length+j].Probability,
 			}
 		}
+		probs = selectPredictions(probs, popts)
+		rprobs := make([]*dlframework.Feature, len(probs))
+		for j, prob := range probs {
+			label := p.labels[prob.Index]
+			rprobs[j] = &dlframework.Feature{
+				Index:       int64(prob.Index),
+				Name:        label.Name,
+				Probability: prob.Probability,
+			}
+			if label.ID != "" {
+				rprobs[j].Metadata = map[string]string{"id": label.ID}
+			}
+		}
 		output = append(output, rprobs)
 	}
 	return output, nil
 }
 
+// predict runs the backend, fetching the blobs requested by the options.
+func (p *ImagePredictor) predict(backend Backend, input []float32, batchSize, length int, popts predictOptions) ([]Prediction, map[string]*caffe2.TensorProto, error) {
+	channels, width, height := int(p.inputDims[0]), int(p.inputDims[1]), int(p.inputDims[2])
+	if len(popts.fetchBlobs) == 0 {
+		predictions, err := backend.Predict(input, batchSize, channels, width, height)
+		return predictions, nil, err
+	}
+
+	blobBackend, ok := backend.(BlobBackend)
+	if !ok {
+		return nil, nil, errors.New("the backend cannot fetch intermediate blobs")
+	}
+	predictions, blobs, err := blobBackend.PredictBlobs(input, batchSize, channels, width, height, popts.fetchBlobs)
+	if err != nil {
+		return nil, nil, err
+	}
+	res := make(map[string]*caffe2.TensorProto, len(popts.fetchBlobs))
+	for _, name := range popts.fetchBlobs {
+		blob, ok := blobs[name]
+		if !ok {
+			return nil, nil, errors.Errorf("backend did not return blob %s", name)
+		}
+		res[name] = trimBatch(blob, batchSize, length)
+	}
+	return predictions, res, nil
+}
+
+// batchInput flattens the images of a batch, checking that each one matches
+// the input dimensions. Batches smaller than the configured batch size are
+// padded with zeros unless the backend accepts variable batch sizes.
+func (p *ImagePredictor) batchInput(backend Backend, data [][]float32) ([]float32, int, error) {
+	maxBatchSize := int(p.BatchSize())
+	if len(data) == 0 {
+		return nil, 0, errors.New("no input to predict")
+	}
+	if len(data) > maxBatchSize {
+		return nil, 0, errors.Errorf("got %d images but the batch size is %d", len(data), maxBatchSize)
+	}
+
+	if err := p.checkImages(data); err != nil {
+		return nil, 0, err
+	}
+	elementSize := p.imageSize()
+
+	batchSize := maxBatchSize
+	if b, ok := backend.(VariableBatchBackend); ok && b.SupportsVariableBatch() {
+		batchSize = len(data)
+	}
+	input := make([]float32, batchSize*elementSize)
+	for ii, v := range data {
+		copy(input[ii*elementSize:], v)
+	}
+	return input, batchSize, nil
+}
+
+// imageSize is the number of elements of an image.
+func (p *ImagePredictor) imageSize() int {
+	size := 1
+	for _, dim := range p.inputDims {
+		size *= int(dim)
+	}
+	return size
+}
+
+// checkImages checks that each image matches the input dimensions.
+func (p *ImagePredictor) checkImages(data [][]float32) error {
+	size := p.imageSize()
+	for ii, v := range data {
+		if len(v) != size {
+			return errors.Errorf("image %d has %d elements but the input dimensions %v need %d", ii, len(v), p.inputDims, size)
+		}
+	}
+	return nil
+}
+
 // Reset ...
 func (p *ImagePredictor) Reset(ctx context.Context) error {
 
 	return nil
 }
 
+// PoolStats returns the use of the backends of the predictor.
+func (p *ImagePredictor) PoolStats() PoolStats {
+	if p.pool == nil {
+		return PoolStats{}
+	}
+	return p.pool.Stats()
+}
+
 // Close ...
 func (p *ImagePredictor) Close() error {
-	if p.predictor != nil {
-		p.predictor.Close()
+	if p.batcher != nil {
+		p.batcher.close()
+	}
+	var err error
+	if p.pool != nil {
+		err = p.pool.Close()
+	}
+	if p.cache != nil {
+		if releaseErr := p.cache.release(p.Model); err == nil {
+			err = releaseErr
+		}
+		p.cache = nil
 	}
 
-	return nil
+	return err
 }
 
 func init() {
@@ -0,0 +1,175 @@
+package predict
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/rai-project/dlframework/framework/options"
+)
+
+// predictOptions are the per call options of Predict, and the pool size and
+// batch latency given to Load. They travel in the options context since options.Options cannot be
+// extended.
+type predictOptions struct {
+	topK           int
+	minProbability float32
+	sorted         bool
+	outputs        []string
+	fetchBlobs     []string
+	poolSize       int
+	batchLatency   time.Duration
+}
+
+type predictOptionsKey struct{}
+
+func withPredictOptions(f func(*predictOptions)) options.Option {
+	return func(o *options.Options) {
+		ctx := o.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		popts := predictOptionsFromContext(ctx)
+		f(&popts)
+		options.Context(context.WithValue(ctx, predictOptionsKey{}, popts))(o)
+	}
+}
+
+func predictOptionsFromContext(ctx context.Context) predictOptions {
+	if ctx == nil {
+		return predictOptions{}
+	}
+	popts, _ := ctx.Value(predictOptionsKey{}).(predictOptions)
+	return popts
+}
+
+// TopK makes Predict return only the k most probable features of every
+// image, k <= 0 keeps all of them.
+func TopK(k int) options.Option {
+	return withPredictOptions(func(o *predictOptions) {
+		o.topK = k
+	})
+}
+
+// MinProbability makes Predict drop the features less probable than p.
+func MinProbability(p float32) options.Option {
+	return withPredictOptions(func(o *predictOptions) {
+		o.minProbability = p
+	})
+}
+
+// SortedOutput makes Predict order the features of every image by decreasing
+// probability.
+func SortedOutput(sorted bool) options.Option {
+	return withPredictOptions(func(o *predictOptions) {
+		o.sorted = sorted
+	})
+}
+
+// Outputs selects the blobs returned by a TensorPredictor instead of the
+// model outputs.
+func Outputs(names ...string) options.Option {
+	return withPredictOptions(func(o *predictOptions) {
+		o.outputs = append([]string{}, names...)
+	})
+}
+
+// FetchBlobs makes ImagePredictor.PredictBlobs and
+// TensorPredictor.PredictTensors also return the named blobs, such as the
+// activations of an intermediate layer. The blobs must be computed by the
+// graph and the backend must implement BlobBackend.
+func FetchBlobs(names ...string) options.Option {
+	return withPredictOptions(func(o *predictOptions) {
+		o.fetchBlobs = append([]string{}, names...)
+	})
+}
+
+// PoolSize makes Load create n backends for the model so that up to n Predict
+// calls run concurrently, the others wait for an idle backend. It defaults to
+// the caffe2.pool_size configuration.
+func PoolSize(n int) options.Option {
+	return withPredictOptions(func(o *predictOptions) {
+		o.poolSize = n
+	})
+}
+
+// BatchLatency makes Load enable the batching of the concurrent Predict calls
+// of an ImagePredictor, a call waits up to d for others to fill the batch.
+// It defaults to the caffe2.batch_latency configuration, a negative d
+// disables the batching.
+func BatchLatency(d time.Duration) options.Option {
+	return withPredictOptions(func(o *predictOptions) {
+		o.batchLatency = d
+	})
+}
+
+// selectPredictions applies the options to the predictions of one image. The
+// top k are found with a partial selection so that only they get sorted. The
+// predictions slice is reordered in place.
+func selectPredictions(predictions []Prediction, o predictOptions) []Prediction {
+	if o.minProbability > 0 {
+		kept := predictions[:0]
+		for _, pred := range predictions {
+			if pred.Probability >= o.minProbability {
+				kept = append(kept, pred)
+			}
+		}
+		predictions = kept
+	}
+	if o.topK > 0 && o.topK < len(predictions) {
+		selectTop(predictions, o.topK)
+		predictions = predictions[:o.topK]
+	}
+	if o.sorted {
+		sort.Slice(predictions, func(ii, jj int) bool {
+			return moreProbable(predictions[ii], predictions[jj])
+		})
+	}
+	return predictions
+}
+
+// moreProbable orders the predictions by decreasing probability, breaking ties
+// by index so that the selection is deterministic.
+func moreProbable(a, b Prediction) bool {
+	if a.Probability != b.Probability {
+		return a.Probability > b.Probability
+	}
+	return a.Index < b.Index
+}
+
+// selectTop moves the k most probable predictions to the front of the slice
+// (in no particular order) using quickselect.
+func selectTop(predictions []Prediction, k int) {
+	lo, hi := 0, len(predictions)-1
+	for lo < hi {
+		// median of three pivot, moved to hi
+		mid := lo + (hi-lo)/2
+		if moreProbable(predictions[mid], predictions[lo]) {
+			predictions[mid], predictions[lo] = predictions[lo], predictions[mid]
+		}
+		if moreProbable(predictions[hi], predictions[lo]) {
+			predictions[hi], predictions[lo] = predictions[lo], predictions[hi]
+		}
+		if moreProbable(predictions[mid], predictions[hi]) {
+			predictions[mid], predictions[hi] = predictions[hi], predictions[mid]
+		}
+		pivot := predictions[hi]
+		store := lo
+		for ii := lo; ii < hi; ii++ {
+			if moreProbable(predictions[ii], pivot) {
+				predictions[ii], predictions[store] = predictions[store], predictions[ii]
+				store++
+			}
+		}
+		predictions[store], predictions[hi] = predictions[hi], predictions[store]
+
+		switch {
+		case store == k-1 || store == k:
+			return
+		case store < k:
+			lo = store + 1
+		default:
+			hi = store - 1
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package predict
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/rai-project/dlframework/framework/options"
+)
+
+// testPredictions returns n predictions with shuffled, partly tied,
+// probabilities.
+func testPredictions(n int, seed int64) []Prediction {
+	rng := rand.New(rand.NewSource(seed))
+	predictions := make([]Prediction, n)
+	for ii := range predictions {
+		predictions[ii] = Prediction{Index: ii, Probability: float32(rng.Intn(n/2+1)) / float32(n)}
+	}
+	rng.Shuffle(n, func(ii, jj int) {
+		predictions[ii], predictions[jj] = predictions[jj], predictions[ii]
+	})
+	return predictions
+}
+
+func sortedPredictions(predictions []Prediction) []Prediction {
+	res := append([]Prediction{}, predictions...)
+	sort.Slice(res, func(ii, jj int) bool {
+		return moreProbable(res[ii], res[jj])
+	})
+	return res
+}
+
+func TestSelectTop(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 10, 1000} {
+		for _, k := range []int{1, 2, 5, n - 1, n} {
+			if k <= 0 || k > n {
+				continue
+			}
+			for seed := int64(0); seed < 5; seed++ {
+				predictions := testPredictions(n, seed)
+				want := sortedPredictions(predictions)[:k]
+				selectTop(predictions, k)
+				got := sortedPredictions(predictions[:k])
+				for ii := range want {
+					if got[ii] != want[ii] {
+						t.Fatalf("selectTop(%d of %d, seed %d) = %v, want %v", k, n, seed, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSelectPredictions(t *testing.T) {
+	predictions := func() []Prediction {
+		return []Prediction{
+			{Index: 0, Probability: 0.1},
+			{Index: 1, Probability: 0.4},
+			{Index: 2, Probability: 0.05},
+			{Index: 3, Probability: 0.4},
+			{Index: 4, Probability: 0.05},
+		}
+	}
+	cases := []struct {
+		name string
+		opts predictOptions
+		want []int
+	}{
+		{"none", predictOptions{}, []int{0, 1, 2, 3, 4}},
+		{"sorted", predictOptions{sorted: true}, []int{1, 3, 0, 2, 4}},
+		{"top 2", predictOptions{topK: 2, sorted: true}, []int{1, 3}},
+		{"top 3", predictOptions{topK: 3, sorted: true}, []int{1, 3, 0}},
+		{"top larger than the outputs", predictOptions{topK: 10, sorted: true}, []int{1, 3, 0, 2, 4}},
+		{"negative top", predictOptions{topK: -1}, []int{0, 1, 2, 3, 4}},
+		{"min probability", predictOptions{minProbability: 0.1}, []int{0, 1, 3}},
+		{"min probability and top", predictOptions{minProbability: 0.1, topK: 1, sorted: true}, []int{1}},
+		{"min probability above all", predictOptions{minProbability: 0.5}, []int{}},
+	}
+	for _, c := range cases {
+		got := selectPredictions(predictions(), c.opts)
+		indices := []int{}
+		for _, pred := range got {
+			indices = append(indices, pred.Index)
+		}
+		if !c.opts.sorted {
+			sort.Ints(indices)
+		}
+		if len(indices) != len(c.want) {
+			t.Errorf("%s: selected %v, want %v", c.name, indices, c.want)
+			continue
+		}
+		for ii := range indices {
+			if indices[ii] != c.want[ii] {
+				t.Errorf("%s: selected %v, want %v", c.name, indices, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestPredictOptions(t *testing.T) {
+	fake := registerFake(4, false)
+	p := loadImagePredictor(t, testModel(t, "predict_options", 4, 4), fake.name, options.BatchSize(2))
+	defer p.Close()
+
+	images := [][]float32{testImage(2), testImage(3)}
+	features, err := p.Predict(context.Background(), images, TopK(2), SortedOutput(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ii, want := range []int64{2, 3} {
+		if len(features[ii]) != 2 {
+			t.Fatalf("image %d has %d features, want the top 2", ii, len(features[ii]))
+		}
+		if top := features[ii][0]; top.Index != want || top.Probability != 0.5 {
+			t.Errorf("top feature of image %d = %+v, want index %d", ii, top, want)
+		}
+		if features[ii][1].Probability > features[ii][0].Probability {
+			t.Errorf("features of image %d are not sorted: %+v", ii, features[ii])
+		}
+	}
+
+	features, err = p.Predict(context.Background(), images, MinProbability(0.3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ii, want := range []int64{2, 3} {
+		if len(features[ii]) != 1 || features[ii][0].Index != want {
+			t.Errorf("features of image %d above 0.3 = %+v, want only index %d", ii, features[ii], want)
+		}
+	}
+
+	// the options of a call do not leak into the next one
+	features, err = p.Predict(context.Background(), images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features[0]) != 4 {
+		t.Errorf("Predict without options returned %d features, want 4", len(features[0]))
+	}
+}
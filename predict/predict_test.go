@@ -0,0 +1,263 @@
+package predict
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rai-project/caffe2"
+	"github.com/rai-project/dlframework"
+	"github.com/rai-project/dlframework/framework/options"
+	"github.com/rai-project/tracer"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "caffe2_predict")
+	if err != nil {
+		panic(err)
+	}
+	// the files of the tests, and the cache holding the work directories of
+	// the models, are in the temporary directory
+	os.Setenv("TMPDIR", dir)
+	Config.CacheDir = filepath.Join(dir, "cache")
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	log = logger.WithField("pkg", "caffe2/predict")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testImageDims are the CHW dimensions of the images of the test model.
+var testImageDims = []int64{3, 2, 2}
+
+// testModel writes a classifier of the images with numOutputs classes and
+// its labels to a directory and returns its manifest, the files are copied
+// from there when the model is loaded.
+func testModel(t *testing.T, name string, numOutputs, numLabels int) dlframework.ModelManifest {
+	size := testImageDims[0] * testImageDims[1] * testImageDims[2]
+	graph := &caffe2.NetDef{
+		Name:          name,
+		ExternalInput: []string{"data", "fc_w", "fc_b"},
+		Op: []*caffe2.OperatorDef{
+			{Type: "FC", Input: []string{"data", "fc_w", "fc_b"}, Output: []string{"fc"}},
+			{Type: "Softmax", Input: []string{"fc"}, Output: []string{"prob"}},
+		},
+		ExternalOutput: []string{"prob"},
+	}
+	weights := &caffe2.NetDef{
+		Name: name + "_init",
+		Op: []*caffe2.OperatorDef{
+			{Type: "GivenTensorFill", Output: []string{"fc_w"}, Arg: []*caffe2.Argument{
+				{Name: "shape", Ints: []int64{int64(numOutputs), size}},
+				{Name: "values", Floats: make([]float32, int64(numOutputs)*size)},
+			}},
+			{Type: "ConstantFill", Output: []string{"fc_b"}, Arg: []*caffe2.Argument{
+				{Name: "shape", Ints: []int64{int64(numOutputs)}},
+			}},
+		},
+	}
+	var labels []string
+	for ii := 0; ii < numLabels; ii++ {
+		labels = append(labels, "label"+strconv.Itoa(ii))
+	}
+
+	dir, err := ioutil.TempDir("", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graphChecksum := writeTestFile(t, filepath.Join(dir, "predict_net.pb"), marshalNet(t, graph))
+	weightsChecksum := writeTestFile(t, filepath.Join(dir, "init_net.pb"), marshalNet(t, weights))
+	labelsChecksum := writeTestFile(t, filepath.Join(dir, "labels.txt"), []byte(strings.Join(labels, "\n")))
+
+	dims, _ := json.Marshal(testImageDims)
+	return dlframework.ModelManifest{
+		Name:    name,
+		Version: "1.0",
+		Inputs: []*dlframework.ModelManifest_Type{{
+			Type: "image",
+			Parameters: map[string]*dlframework.ModelManifest_Type_Parameter{
+				"dimensions": {Value: string(dims)},
+			},
+		}},
+		Output: &dlframework.ModelManifest_Type{
+			Type: "feature",
+			Parameters: map[string]*dlframework.ModelManifest_Type_Parameter{
+				"features_url":      {Value: filepath.Join(dir, "labels.txt")},
+				"features_checksum": {Value: labelsChecksum},
+			},
+		},
+		Model: &dlframework.ModelManifest_Model{
+			BaseUrl:         dir,
+			GraphPath:       "predict_net.pb",
+			WeightsPath:     "init_net.pb",
+			GraphChecksum:   graphChecksum,
+			WeightsChecksum: weightsChecksum,
+		},
+	}
+}
+
+func marshalNet(t *testing.T, net *caffe2.NetDef) []byte {
+	buf, err := net.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// writeTestFile writes a file and returns its sha256 checksum.
+func writeTestFile(t *testing.T, path string, buf []byte) string {
+	if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(buf)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// testImage returns an image whose values sum to sum.
+func testImage(sum float32) []float32 {
+	img := make([]float32, testImageDims[0]*testImageDims[1]*testImageDims[2])
+	img[0] = sum
+	return img
+}
+
+func loadImagePredictor(t *testing.T, model dlframework.ModelManifest, backend string, opts ...options.Option) *ImagePredictor {
+	predictor, err := NewWithBackend(model, backend, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, ok := predictor.(*ImagePredictor)
+	if !ok {
+		t.Fatalf("NewWithBackend returned a %T, want an *ImagePredictor", predictor)
+	}
+	return ip
+}
+
+func TestLoadPredict(t *testing.T) {
+	fake := registerFake(4, false)
+	model := testModel(t, "load_predict", 4, 4)
+	p := loadImagePredictor(t, model, fake.name, options.BatchSize(2))
+
+	workDir, _ := modelWorkDir(model)
+	for _, file := range []string{"predict_net.pb", "init_net.pb", "load_predict.features"} {
+		if _, err := os.Stat(filepath.Join(workDir, file)); err != nil {
+			t.Errorf("%s is not in the work directory: %v", file, err)
+		}
+	}
+	if backends := fake.created(); len(backends) != 1 {
+		t.Fatalf("Load created %d backends, want 1", len(backends))
+	}
+
+	features, err := p.Predict(context.Background(), [][]float32{testImage(1), testImage(6)}, SortedOutput(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 2 {
+		t.Fatalf("Predict returned the features of %d images, want 2", len(features))
+	}
+	for ii, want := range []int64{1, 2} {
+		top := features[ii][0]
+		if len(features[ii]) != 4 || top.Index != want || top.Name != "label"+strconv.FormatInt(want, 10) || top.Probability != 0.5 {
+			t.Errorf("top feature of image %d = %+v, want label%d with a probability of 0.5", ii, top, want)
+		}
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !fake.created()[0].Closed() {
+		t.Error("Close did not close the backend")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	fake := registerFake(4, false)
+
+	missingLabels := testModel(t, "missing_labels", 4, 3)
+	if _, err := NewWithBackend(missingLabels, fake.name); err == nil || !strings.Contains(err.Error(), "only has 3 labels") {
+		t.Errorf("Load with too few labels: %v", err)
+	}
+
+	noChecksum := testModel(t, "no_checksum", 4, 4)
+	noChecksum.Model.GraphChecksum = ""
+	if _, err := NewWithBackend(noChecksum, fake.name); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Load without graph checksum: %v", err)
+	}
+
+	badChecksum := testModel(t, "bad_checksum", 4, 4)
+	badChecksum.Model.WeightsChecksum = badChecksum.Model.GraphChecksum
+	if _, err := NewWithBackend(badChecksum, fake.name); err == nil {
+		t.Error("Load with a wrong weights checksum succeeded")
+	}
+
+	if _, err := NewWithBackend(testModel(t, "unknown_backend", 4, 4), "unknown"); err == nil || !strings.Contains(err.Error(), "backend unknown is not available") {
+		t.Errorf("Load with an unknown backend: %v", err)
+	}
+}
+
+func TestPredictProfiling(t *testing.T) {
+	fake := registerFake(4, false)
+	model := testModel(t, "profiling", 4, 4)
+	p := loadImagePredictor(t, model, fake.name, options.TraceLevel(tracer.FRAMEWORK_TRACE))
+	defer p.Close()
+
+	for ii := 0; ii < 2; ii++ {
+		if _, err := p.Predict(context.Background(), [][]float32{testImage(1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	profile, err := fake.created()[0].ReadProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []fakeProfileEntry
+	if err := json.Unmarshal([]byte(profile), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("profile = %s, want one session per Predict", profile)
+	}
+	for _, entry := range entries {
+		if entry.Name != "caffe2" || entry.Metadata != "predict" || entry.Predicts != 1 {
+			t.Errorf("profiling session = %+v", entry)
+		}
+	}
+	// the profiling sessions were ended
+	if err := fake.created()[0].StartProfiling("again", ""); err != nil {
+		t.Errorf("StartProfiling after Predict: %v", err)
+	}
+
+	// profiling is off at the default trace level
+	quiet := registerFake(4, false)
+	p2 := loadImagePredictor(t, testModel(t, "no_profiling", 4, 4), quiet.name)
+	defer p2.Close()
+	if _, err := p2.Predict(context.Background(), [][]float32{testImage(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if profile, _ := quiet.created()[0].ReadProfile(); profile != "null" {
+		t.Errorf("profile without tracing = %s, want none", profile)
+	}
+}
+
+func TestBackends(t *testing.T) {
+	fake := registerFake(2, false)
+	found := false
+	for _, name := range Backends() {
+		found = found || name == fake.name
+	}
+	if !found {
+		t.Errorf("Backends() = %v, missing %s", Backends(), fake.name)
+	}
+	if _, err := NewBackend("unknown"); err == nil {
+		t.Error("NewBackend of an unknown backend succeeded")
+	}
+}
@@ -0,0 +1,108 @@
+package predict
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/dlframework"
+	"golang.org/x/crypto/ed25519"
+)
+
+// signatureFile is the name of the detached signature of a model, next to
+// its files.
+const signatureFile = "model.sig"
+
+// signatureURL returns the url of the detached signature of a model, given by
+// the signature_url attribute of the manifest, or next to the model files or
+// archive.
+func signatureURL(model dlframework.ModelManifest) string {
+	if url := model.GetAttributes()["signature_url"]; url != "" {
+		return url
+	}
+	baseURL := model.GetModel().GetBaseUrl()
+	if dir, ok := localPath(baseURL); model.GetModel().GetIsArchive() && !(ok && isDir(dir)) {
+		return baseURL + ".sig"
+	}
+	return modelFileURL(model, signatureFile)
+}
+
+// trustedKeys parses the caffe2.trusted_keys configuration, hex or base64
+// encoded ed25519 public keys.
+func trustedKeys() ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, s := range Config.TrustedKeys {
+		buf, err := decodeKey(s)
+		if err != nil || len(buf) != ed25519.PublicKeySize {
+			return nil, errors.Errorf("invalid trusted key %q", s)
+		}
+		keys = append(keys, ed25519.PublicKey(buf))
+	}
+	return keys, nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if buf, err := hex.DecodeString(s); err == nil {
+		return buf, nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// signedMessage returns what the signature of a model covers: the
+// "sha256sum" listing of its files, sorted by their path relative to the
+// work directory.
+func signedMessage(workDir string, files []modelFile) ([]byte, error) {
+	var lines []string
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		rel, err := filepath.Rel(workDir, file.path)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := fileHash(file.path, sha256.New())
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot hash the %s file", file.name)
+		}
+		lines = append(lines, fmt.Sprintf("%s  %s\n", sum, filepath.ToSlash(rel)))
+	}
+	sort.Slice(lines, func(ii, jj int) bool {
+		return lines[ii][2*sha256.Size+2:] < lines[jj][2*sha256.Size+2:]
+	})
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// verifySignature checks that the signature file, raw or base64 encoded,
+// holds a signature of the model files by one of the keys.
+func verifySignature(path string, keys []ed25519.PublicKey, workDir string, files []modelFile) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "cannot read the model signature")
+	}
+	signature := buf
+	if len(buf) != ed25519.SignatureSize {
+		signature, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(buf)))
+		if err != nil || len(signature) != ed25519.SignatureSize {
+			return errors.Errorf("invalid model signature %s", path)
+		}
+	}
+	message, err := signedMessage(workDir, files)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, message, signature) {
+			return nil
+		}
+	}
+	return errors.Errorf("model signature %s does not match any trusted key", path)
+}
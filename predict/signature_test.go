@@ -0,0 +1,121 @@
+package predict
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rai-project/dlframework"
+	"golang.org/x/crypto/ed25519"
+)
+
+// withTrustedKey configures a new trusted key for the test and returns its
+// private key.
+func withTrustedKey(t *testing.T) ed25519.PrivateKey {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := Config.TrustedKeys
+	Config.TrustedKeys = []string{base64.StdEncoding.EncodeToString(public)}
+	t.Cleanup(func() { Config.TrustedKeys = keys })
+	return private
+}
+
+func TestSignedMessage(t *testing.T) {
+	model, workDir, files := testDownload(t, map[string]string{"b.pb": "b", "a.pb": "a"})
+	files = append(files, modelFile{name: "unused"})
+	for _, file := range files {
+		if file.path != "" {
+			copyTestFile(t, filepath.Join(model.Model.BaseUrl, file.name), file.path)
+		}
+	}
+	message, err := signedMessage(workDir, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b"))
+	want := fmt.Sprintf("%s  a.pb\n%s  b.pb\n", hex.EncodeToString(a[:]), hex.EncodeToString(b[:]))
+	if string(message) != want {
+		t.Errorf("signed message = %q, want %q", message, want)
+	}
+}
+
+func TestDownloadSigned(t *testing.T) {
+	private := withTrustedKey(t)
+	contents := map[string]string{"graph.pb": "graph", "weights.pb": "weights"}
+	a, b := sha256.Sum256([]byte("graph")), sha256.Sum256([]byte("weights"))
+	message := fmt.Sprintf("%s  graph.pb\n%s  weights.pb\n", hex.EncodeToString(a[:]), hex.EncodeToString(b[:]))
+	signature := ed25519.Sign(private, []byte(message))
+
+	// the files need no checksum, raw and base64 encoded signatures
+	for _, sig := range [][]byte{signature, []byte(base64.StdEncoding.EncodeToString(signature) + "\n")} {
+		model, workDir, files := testDownload(t, contents)
+		for ii := range files {
+			files[ii].checksum = ""
+		}
+		writeTestFile(t, filepath.Join(model.Model.BaseUrl, signatureFile), sig)
+		if err := downloadModel(context.Background(), testSpan(), model, workDir, files); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	model, workDir, files := testDownload(t, contents)
+	err := downloadModel(context.Background(), testSpan(), model, workDir, files)
+	if err == nil || !strings.Contains(err.Error(), "cannot get the model signature") {
+		t.Errorf("download without a signature = %v", err)
+	}
+
+	tampered := append([]byte(nil), signature...)
+	tampered[0] ^= 1
+	writeTestFile(t, filepath.Join(model.Model.BaseUrl, signatureFile), tampered)
+	err = downloadModel(context.Background(), testSpan(), model, workDir, files)
+	if err == nil || !strings.Contains(err.Error(), "does not match any trusted key") {
+		t.Errorf("download with a tampered signature = %v", err)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file.path); !os.IsNotExist(err) {
+			t.Errorf("%s of a model with a tampered signature is in the work directory", file.name)
+		}
+	}
+
+	writeTestFile(t, filepath.Join(model.Model.BaseUrl, signatureFile), []byte("invalid"))
+	err = downloadModel(context.Background(), testSpan(), model, workDir, files)
+	if err == nil || !strings.Contains(err.Error(), "invalid model signature") {
+		t.Errorf("download with an invalid signature = %v", err)
+	}
+
+	Config.TrustedKeys = []string{"invalid"}
+	err = downloadModel(context.Background(), testSpan(), model, workDir, files)
+	if err == nil || !strings.Contains(err.Error(), "invalid trusted key") {
+		t.Errorf("download with an invalid trusted key = %v", err)
+	}
+}
+
+func TestSignatureURL(t *testing.T) {
+	const dir = "/models/alexnet"
+	cases := []struct {
+		model dlframework.ModelManifest_Model
+		attrs map[string]string
+		want  string
+	}{
+		{dlframework.ModelManifest_Model{BaseUrl: "http://example.com/alexnet"}, nil, "http://example.com/alexnet/model.sig"},
+		{dlframework.ModelManifest_Model{BaseUrl: "http://example.com/alexnet.tar.gz", IsArchive: true}, nil, "http://example.com/alexnet.tar.gz.sig"},
+		{dlframework.ModelManifest_Model{BaseUrl: "http://example.com/alexnet.tar.gz", IsArchive: true}, map[string]string{"signature_url": "http://example.com/alexnet.sig"}, "http://example.com/alexnet.sig"},
+		{dlframework.ModelManifest_Model{BaseUrl: dir}, nil, dir + "/" + signatureFile},
+	}
+	for _, c := range cases {
+		model := c.model
+		got := signatureURL(dlframework.ModelManifest{Model: &model, Attributes: c.attrs})
+		if got != c.want {
+			t.Errorf("signatureURL(%s) = %s, want %s", c.model.BaseUrl, got, c.want)
+		}
+	}
+}
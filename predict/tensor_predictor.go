@@ -0,0 +1,642 @@
+package predict
+
+import (
+	"context"
+	"strings"
+
+	olog "github.com/opentracing/opentracing-go/log"
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+	"github.com/rai-project/dlframework"
+	"github.com/rai-project/dlframework/framework/options"
+	common "github.com/rai-project/dlframework/framework/predict"
+	"github.com/rai-project/tracer"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// TensorInput describes an input of a TensorPredictor. It is read from the
+// parameters of a manifest input of type tensor:
+//
+//	inputs:
+//	  - type: tensor
+//	    parameters:
+//	      name: data
+//	      dtype: float32
+//	      shape: [-1, 128]
+type TensorInput struct {
+	Name     string
+	DataType caffe2.TensorProto_DataType
+	// Shape of the input, -1 marks a dimension of any size such as the batch.
+	Shape []int64
+}
+
+// TensorPredictor runs models whose inputs are not images, such as embedding
+// or tabular models, on raw tensors. The gocaffe2 backend only runs the models
+// with a single 4D float input and a single output, the other models run on
+// the reference backend when the predictor does not select a backend.
+type TensorPredictor struct {
+	common.Base
+	WorkDir     string
+	inputs      []TensorInput
+	outputs     []string
+	blobs       map[string]bool
+	backendName string
+	pool        *backendPool
+	cache       *Cache
+}
+
+// NewTensorPredictor ...
+func NewTensorPredictor(model dlframework.ModelManifest, opts ...options.Option) (common.Predictor, error) {
+	predictor := new(TensorPredictor)
+	return predictor.Load(context.Background(), model, opts...)
+}
+
+// Load ...
+func (p *TensorPredictor) Load(ctx context.Context, model dlframework.ModelManifest, opts ...options.Option) (common.Predictor, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, tracer.STEP_TRACE, "Load")
+	defer span.Finish()
+
+	framework, err := model.ResolveFramework()
+	if err != nil {
+		return nil, err
+	}
+
+	workDir, err := modelWorkDir(model)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, err := tensorInputs(model)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := &TensorPredictor{
+		Base: common.Base{
+			Framework: framework,
+			Model:     model,
+			Options:   options.New(opts...),
+		},
+		WorkDir:     workDir,
+		inputs:      inputs,
+		backendName: p.backendName,
+	}
+
+	tp.cache, err = downloadCached(model, workDir, tp.modelFiles(), func() error {
+		return tp.download(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tp.loadPredictor(ctx); err != nil {
+		tp.Close()
+		return nil, err
+	}
+
+	return tp, nil
+}
+
+func (p *TensorPredictor) graphPath() string {
+	return modelFilePath(p.WorkDir, p.Model.GetModel().GetGraphPath())
+}
+
+func (p *TensorPredictor) weightsPath() string {
+	return modelFilePath(p.WorkDir, p.Model.GetModel().GetWeightsPath())
+}
+
+func (p *TensorPredictor) download(ctx context.Context) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, tracer.STEP_TRACE, "Download")
+	defer span.Finish()
+
+	return downloadModel(ctx, span, p.Model, p.WorkDir, p.modelFiles())
+}
+
+func (p *TensorPredictor) modelFiles() []modelFile {
+	model := p.Model.GetModel()
+	return []modelFile{
+		{name: "graph", url: modelFileURL(p.Model, model.GetGraphPath()), path: p.graphPath(), checksum: model.GetGraphChecksum(), inArchive: true},
+		{name: "weights", url: modelFileURL(p.Model, model.GetWeightsPath()), path: p.weightsPath(), checksum: model.GetWeightsChecksum(), inArchive: true},
+	}
+}
+
+func (p *TensorPredictor) loadPredictor(ctx context.Context) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, tracer.STEP_TRACE, "LoadPredictor")
+	defer span.Finish()
+
+	span.LogFields(
+		olog.String("event", "validate graph"),
+	)
+
+	graph, weights, err := validatePredictNet(p.Model.GetName(), p.graphPath(), p.weightsPath())
+	if err != nil {
+		return err
+	}
+
+	p.inputs, err = resolveInputs(p.inputs, graph, weights)
+	if err != nil {
+		return err
+	}
+
+	p.blobs = graphBlobs(graph)
+
+	p.outputs, err = tensorOutputs(p.Model, graph)
+	if err != nil {
+		return err
+	}
+	if err := p.checkOutputs(p.outputs); err != nil {
+		return err
+	}
+
+	if err := checkContext(ctx, "load model"); err != nil {
+		return err
+	}
+
+	span.LogFields(
+		olog.String("event", "creating predictor"),
+	)
+
+	opts, err := p.GetPredictionOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	name := p.backendName
+	if name == "" {
+		name = DefaultBackend
+	}
+	pool, err := p.newPool(ctx, name, opts)
+	if err != nil {
+		return err
+	}
+	checkErr := checkTensorBackend(pool.first(), p.inputs, p.outputs)
+	if checkErr != nil && p.backendName == "" && name != fallbackTensorBackend {
+		// the default gocaffe2 backend only runs single input and output
+		// models, the others run on the reference backend
+		pool.Close()
+		log.WithField("model", p.Model.GetName()).
+			Warnf("%v, falling back to the %s backend", checkErr, fallbackTensorBackend)
+		name = fallbackTensorBackend
+		if pool, err = p.newPool(ctx, name, opts); err != nil {
+			return err
+		}
+		checkErr = checkTensorBackend(pool.first(), p.inputs, p.outputs)
+	}
+	if checkErr != nil {
+		pool.Close()
+		return errors.Wrapf(checkErr, "cannot load model %s on the %s backend", p.Model.GetName(), name)
+	}
+	p.backendName = name
+	p.pool = pool
+
+	return nil
+}
+
+// fallbackTensorBackend runs the models that the default backend rejects
+// when the predictor does not select a backend.
+const fallbackTensorBackend = "reference"
+
+func (p *TensorPredictor) newPool(ctx context.Context, name string, opts *options.Options) (*backendPool, error) {
+	return newBackendPool(
+		ctx,
+		name,
+		predictOptionsFromContext(p.Options.Context()).poolSize,
+		options.WithOptions(opts),
+		options.Graph([]byte(p.graphPath())),
+		options.Weights([]byte(p.weightsPath())),
+	)
+}
+
+func checkTensorBackend(backend Backend, inputs []TensorInput, outputs []string) error {
+	tb, ok := backend.(TensorBackend)
+	if !ok {
+		return errors.New("the backend does not support tensor inputs")
+	}
+	return tb.CheckTensors(inputs, outputs)
+}
+
+// Inputs returns the inputs expected by PredictTensors.
+func (p *TensorPredictor) Inputs() []TensorInput {
+	return p.inputs
+}
+
+// Outputs returns the names of the outputs returned by PredictTensors unless
+// others are selected with the Outputs option.
+func (p *TensorPredictor) Outputs() []string {
+	return p.outputs
+}
+
+// checkOutputs makes sure that the outputs are blobs computed by the graph.
+func (p *TensorPredictor) checkOutputs(outputs []string) error {
+	return checkBlobs(p.blobs, outputs)
+}
+
+// selectedOutputs returns the outputs selected by the options.
+func (p *TensorPredictor) selectedOutputs(opts ...options.Option) ([]string, error) {
+	outputs := predictOptionsFromContext(options.New(opts...).Context()).outputs
+	if len(outputs) == 0 {
+		return p.outputs, nil
+	}
+	if err := p.checkOutputs(outputs); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// GetPreprocessOptions ...
+func (p *TensorPredictor) GetPreprocessOptions(ctx context.Context) (common.PreprocessOptions, error) {
+	return common.PreprocessOptions{
+		Context: ctx,
+	}, nil
+}
+
+// PredictTensors runs the model on the named inputs and returns each of its
+// outputs, or of the blobs selected with the Outputs option, and the blobs
+// fetched with the FetchBlobs option by name. The
+// inputs must match the data type and shape of the manifest. It is safe for
+// concurrent use.
+func (p *TensorPredictor) PredictTensors(ctx context.Context, inputs map[string]*caffe2.TensorProto, opts ...options.Option) (map[string]*caffe2.TensorProto, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, tracer.STEP_TRACE, "PredictTensors")
+	defer span.Finish()
+
+	if err := p.checkInputs(inputs); err != nil {
+		return nil, err
+	}
+	outputs, err := p.selectedOutputs(opts...)
+	if err != nil {
+		return nil, err
+	}
+	popts := predictOptionsFromContext(options.New(opts...).Context())
+	if err := checkBlobs(p.blobs, popts.fetchBlobs); err != nil {
+		return nil, err
+	}
+	tensors, err := p.run(ctx, inputs, append(append([]string{}, outputs...), popts.fetchBlobs...))
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]*caffe2.TensorProto, len(outputs))
+	for _, name := range outputs {
+		output, ok := tensors[name]
+		if !ok {
+			return nil, errors.Errorf("backend did not return output %s", name)
+		}
+		res[name] = output
+	}
+	for _, name := range popts.fetchBlobs {
+		blob, ok := tensors[name]
+		if !ok {
+			return nil, errors.Errorf("backend did not return blob %s", name)
+		}
+		res[name] = blob
+	}
+	return res, nil
+}
+
+// run runs the inputs on an idle backend. It returns once the context ends,
+// the backend being released when its run is done.
+func (p *TensorPredictor) run(ctx context.Context, inputs map[string]*caffe2.TensorProto, outputs []string) (map[string]*caffe2.TensorProto, error) {
+	if err := checkContext(ctx, "predict"); err != nil {
+		return nil, err
+	}
+	backend, err := p.pool.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		tensors map[string]*caffe2.TensorProto
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer p.pool.release(backend)
+		tensors, err := backend.(TensorBackend).Run(inputs, outputs)
+		done <- result{tensors: tensors, err: err}
+	}()
+	select {
+	case res := <-done:
+		return res.tensors, res.err
+	case <-ctx.Done():
+		return nil, checkContext(ctx, "predict")
+	}
+}
+
+func (p *TensorPredictor) checkInputs(inputs map[string]*caffe2.TensorProto) error {
+	expected := map[string]bool{}
+	for _, input := range p.inputs {
+		expected[input.Name] = true
+		tensor, ok := inputs[input.Name]
+		if !ok {
+			return errors.Errorf("missing input %s", input.Name)
+		}
+		if tensor.GetDataType() != input.DataType {
+			return errors.Errorf("input %s is a %v tensor, expecting %v", input.Name, tensor.GetDataType(), input.DataType)
+		}
+		if !matchShape(input.Shape, tensor.GetDims()) {
+			return errors.Errorf("input %s has dimensions %v, expecting %v", input.Name, tensor.GetDims(), input.Shape)
+		}
+		if _, err := caffe2.DecodeTensor(tensor); err != nil {
+			return errors.Wrapf(err, "invalid input %s", input.Name)
+		}
+	}
+	for name := range inputs {
+		if !expected[name] {
+			return errors.Errorf("unexpected input %s", name)
+		}
+	}
+	return nil
+}
+
+// Predict runs a batch on a model with a single float32 input whose first
+// dimension is the batch, each element of data holding one batch element.
+// Every value of the output is returned as a feature named after it. Models
+// with several outputs need the Outputs option to select one of them, or
+// PredictOutputs.
+func (p *TensorPredictor) Predict(ctx context.Context, data [][]float32, opts ...options.Option) ([]dlframework.Features, error) {
+	if _, err := p.batchInput(); err != nil {
+		return nil, err
+	}
+	names, err := p.selectedOutputs(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) != 1 {
+		return nil, errors.Errorf("Predict returns a single output but %s are selected, use PredictOutputs or the Outputs option", strings.Join(names, ", "))
+	}
+	outputs, err := p.PredictOutputs(ctx, data, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return outputs[names[0]], nil
+}
+
+// PredictOutputs is Predict returning the features of each tensor returned by
+// PredictTensors by name.
+func (p *TensorPredictor) PredictOutputs(ctx context.Context, data [][]float32, opts ...options.Option) (map[string][]dlframework.Features, error) {
+	input, err := p.batchInput()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("no input to predict")
+	}
+
+	dims := append([]int64{int64(len(data))}, input.Shape[1:]...)
+	elementSize := int64(1)
+	for _, dim := range dims[1:] {
+		if dim < 0 {
+			return nil, errors.Errorf("input %s has more than one dynamic dimension", input.Name)
+		}
+		elementSize *= dim
+	}
+	flat := make([]float32, 0, int64(len(data))*elementSize)
+	for ii, v := range data {
+		if int64(len(v)) != elementSize {
+			return nil, errors.Errorf("element %d has %d values but the input dimensions %v need %d", ii, len(v), input.Shape, elementSize)
+		}
+		flat = append(flat, v...)
+	}
+	tensor, err := caffe2.NewTensorProto(input.Name, dims, flat)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := p.PredictTensors(ctx, map[string]*caffe2.TensorProto{input.Name: tensor}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string][]dlframework.Features, len(outputs))
+	for name, output := range outputs {
+		t, err := caffe2.DecodeTensor(output)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid output %s", name)
+		}
+		values, err := t.Float32s()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid output %s", name)
+		}
+		if len(values)%len(data) != 0 {
+			return nil, errors.Errorf("output %s has %d values for a batch of %d", name, len(values), len(data))
+		}
+		length := len(values) / len(data)
+		features := make([]dlframework.Features, len(data))
+		for ii := range features {
+			features[ii] = make(dlframework.Features, length)
+			for jj := range features[ii] {
+				features[ii][jj] = &dlframework.Feature{
+					Index:       int64(jj),
+					Name:        name,
+					Probability: values[ii*length+jj],
+				}
+			}
+		}
+		res[name] = features
+	}
+	return res, nil
+}
+
+// batchInput returns the input of the models run by Predict, a single float32
+// input whose first dimension is the batch.
+func (p *TensorPredictor) batchInput() (TensorInput, error) {
+	if len(p.inputs) != 1 || p.inputs[0].DataType != caffe2.TensorProto_FLOAT {
+		return TensorInput{}, errors.New("Predict needs a model with a single float32 input, use PredictTensors")
+	}
+	input := p.inputs[0]
+	if len(input.Shape) == 0 || input.Shape[0] >= 0 {
+		return TensorInput{}, errors.Errorf("the first dimension of input %s is not a batch dimension", input.Name)
+	}
+	return input, nil
+}
+
+// Reset ...
+func (p *TensorPredictor) Reset(ctx context.Context) error {
+	return nil
+}
+
+// PoolStats returns the use of the backends of the predictor.
+func (p *TensorPredictor) PoolStats() PoolStats {
+	if p.pool == nil {
+		return PoolStats{}
+	}
+	return p.pool.Stats()
+}
+
+// Close ...
+func (p *TensorPredictor) Close() error {
+	var err error
+	if p.pool != nil {
+		err = p.pool.Close()
+	}
+	if p.cache != nil {
+		if releaseErr := p.cache.release(p.Model); err == nil {
+			err = releaseErr
+		}
+		p.cache = nil
+	}
+	return err
+}
+
+// tensorInputs reads the inputs of a model from its manifest. Image inputs
+// are float32 NCHW tensors of the given dimensions with a batch dimension.
+// Unnamed inputs are named by resolveInputs once the graph is known.
+func tensorInputs(model dlframework.ModelManifest) ([]TensorInput, error) {
+	var inputs []TensorInput
+	seen := map[string]bool{}
+	for ii, modelInput := range model.GetInputs() {
+		input := TensorInput{
+			Name:     typeParameter(modelInput, "name"),
+			DataType: caffe2.TensorProto_FLOAT,
+		}
+		if input.Name != "" {
+			if seen[input.Name] {
+				return nil, errors.Errorf("duplicate input %s", input.Name)
+			}
+			seen[input.Name] = true
+		}
+
+		switch t := strings.ToLower(modelInput.GetType()); t {
+		case "tensor":
+			if dtype := typeParameter(modelInput, "dtype"); dtype != "" {
+				var err error
+				input.DataType, err = ParseDataType(dtype)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid dtype for input %d", ii)
+				}
+			}
+			if shape := typeParameter(modelInput, "shape"); shape != "" {
+				if err := yaml.Unmarshal([]byte(shape), &input.Shape); err != nil {
+					return nil, errors.Wrapf(err, "invalid shape for input %d", ii)
+				}
+			}
+		case "image":
+			var dims []int64
+			if err := yaml.Unmarshal([]byte(typeParameter(modelInput, "dimensions")), &dims); err != nil || len(dims) != 3 {
+				return nil, errors.Errorf("image input %d needs [channels, height, width] dimensions", ii)
+			}
+			input.Shape = append([]int64{-1}, dims...)
+		default:
+			return nil, errors.Errorf("input %d has the unsupported type %s", ii, t)
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, nil
+}
+
+// resolveInputs maps the manifest inputs to the external inputs of the graph
+// that are not filled by the init net. Unnamed inputs take the graph inputs
+// in order, and every graph input must be fed.
+func resolveInputs(inputs []TensorInput, graph *caffe2.NetDef, weights map[string][]int64) ([]TensorInput, error) {
+	var graphInputs []string
+	isGraphInput := map[string]bool{}
+	for _, input := range graph.GetExternalInput() {
+		if _, filled := weights[input]; !filled {
+			graphInputs = append(graphInputs, input)
+			isGraphInput[input] = true
+		}
+	}
+
+	res := make([]TensorInput, len(inputs))
+	used := map[string]bool{}
+	for ii, input := range inputs {
+		if input.Name == "" {
+			continue
+		}
+		if !isGraphInput[input.Name] {
+			return nil, errors.Errorf("input %s is not an external input of the graph, expecting one of %s", input.Name, strings.Join(graphInputs, ", "))
+		}
+		used[input.Name] = true
+		res[ii] = input
+	}
+	next := 0
+	for ii, input := range inputs {
+		if input.Name != "" {
+			continue
+		}
+		for next < len(graphInputs) && used[graphInputs[next]] {
+			next++
+		}
+		if next == len(graphInputs) {
+			return nil, errors.Errorf("the manifest has %d inputs but the graph only has %d", len(inputs), len(graphInputs))
+		}
+		input.Name = graphInputs[next]
+		used[input.Name] = true
+		res[ii] = input
+	}
+	for _, name := range graphInputs {
+		if !used[name] {
+			return nil, errors.Errorf("graph input %s has no matching input in the manifest", name)
+		}
+	}
+	return res, nil
+}
+
+// tensorOutputs reads the outputs of a model from the names parameter of its
+// manifest output, defaulting to the external outputs of the graph.
+func tensorOutputs(model dlframework.ModelManifest, graph *caffe2.NetDef) ([]string, error) {
+	var outputs []string
+	if names := typeParameter(model.GetOutput(), "names"); names != "" {
+		if err := yaml.Unmarshal([]byte(names), &outputs); err != nil {
+			return nil, errors.Wrap(err, "invalid output names")
+		}
+	}
+	if len(outputs) == 0 {
+		outputs = graph.GetExternalOutput()
+	}
+	if len(outputs) == 0 {
+		return nil, errors.New("the model has no outputs, list them in the names parameter of the manifest output")
+	}
+	return outputs, nil
+}
+
+func typeParameter(t *dlframework.ModelManifest_Type, name string) string {
+	param, ok := t.GetParameters()[name]
+	if !ok || param == nil {
+		return ""
+	}
+	return strings.TrimSpace(param.Value)
+}
+
+var dataTypeNames = map[string]caffe2.TensorProto_DataType{
+	"float32": caffe2.TensorProto_FLOAT,
+	"float":   caffe2.TensorProto_FLOAT,
+	"float64": caffe2.TensorProto_DOUBLE,
+	"double":  caffe2.TensorProto_DOUBLE,
+	"float16": caffe2.TensorProto_FLOAT16,
+	"half":    caffe2.TensorProto_FLOAT16,
+	"int32":   caffe2.TensorProto_INT32,
+	"int64":   caffe2.TensorProto_INT64,
+	"int16":   caffe2.TensorProto_INT16,
+	"uint16":  caffe2.TensorProto_UINT16,
+	"int8":    caffe2.TensorProto_INT8,
+	"uint8":   caffe2.TensorProto_UINT8,
+	"bool":    caffe2.TensorProto_BOOL,
+	"string":  caffe2.TensorProto_STRING,
+}
+
+// ParseDataType parses a data type given either as a numpy style name
+// (float32, int64, ...) or a caffe2 name (FLOAT, INT64, ...).
+func ParseDataType(s string) (caffe2.TensorProto_DataType, error) {
+	if dtype, ok := dataTypeNames[strings.ToLower(s)]; ok {
+		return dtype, nil
+	}
+	if dtype, ok := caffe2.TensorProto_DataType_value[strings.ToUpper(s)]; ok {
+		return caffe2.TensorProto_DataType(dtype), nil
+	}
+	return 0, errors.Errorf("unknown data type %s", s)
+}
+
+func matchShape(shape, dims []int64) bool {
+	if shape == nil {
+		return true
+	}
+	if len(shape) != len(dims) {
+		return false
+	}
+	for ii, dim := range shape {
+		if dim >= 0 && dim != dims[ii] {
+			return false
+		}
+	}
+	return true
+}
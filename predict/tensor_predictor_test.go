@@ -0,0 +1,394 @@
+package predict
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+	"github.com/rai-project/dlframework"
+	"github.com/rai-project/dlframework/framework/options"
+)
+
+// tensorInput returns a manifest input of type tensor.
+func tensorInput(name, dtype, shape string) *dlframework.ModelManifest_Type {
+	params := map[string]*dlframework.ModelManifest_Type_Parameter{}
+	for key, value := range map[string]string{"name": name, "dtype": dtype, "shape": shape} {
+		if value != "" {
+			params[key] = &dlframework.ModelManifest_Type_Parameter{Value: value}
+		}
+	}
+	return &dlframework.ModelManifest_Type{Type: "tensor", Parameters: params}
+}
+
+// testTensorModel writes a graph and its weights to a directory and returns
+// the manifest of a model with the given inputs.
+func testTensorModel(t *testing.T, name string, inputs []*dlframework.ModelManifest_Type, graph, weights *caffe2.NetDef) dlframework.ModelManifest {
+	dir, err := ioutil.TempDir("", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dlframework.ModelManifest{
+		Name:    name,
+		Version: "1.0",
+		Inputs:  inputs,
+		Output:  &dlframework.ModelManifest_Type{Type: "tensor"},
+		Model: &dlframework.ModelManifest_Model{
+			BaseUrl:         dir,
+			GraphPath:       "predict_net.pb",
+			WeightsPath:     "init_net.pb",
+			GraphChecksum:   writeTestFile(t, filepath.Join(dir, "predict_net.pb"), marshalNet(t, graph)),
+			WeightsChecksum: writeTestFile(t, filepath.Join(dir, "init_net.pb"), marshalNet(t, weights)),
+		},
+	}
+}
+
+// testFCModel returns a model computing y = W x + b for an input x of 4
+// values, with W = [[1 0 0 0] [0 1 0 0] [1 1 1 1]] and b = [0 0 1].
+func testFCModel(t *testing.T, name, dtype string) dlframework.ModelManifest {
+	graph := &caffe2.NetDef{
+		Name:           name,
+		ExternalInput:  []string{"x", "fc_w", "fc_b"},
+		Op:             []*caffe2.OperatorDef{{Type: "FC", Input: []string{"x", "fc_w", "fc_b"}, Output: []string{"y"}}},
+		ExternalOutput: []string{"y"},
+	}
+	weights := &caffe2.NetDef{
+		Name: name + "_init",
+		Op: []*caffe2.OperatorDef{
+			{Type: "GivenTensorFill", Output: []string{"fc_w"}, Arg: []*caffe2.Argument{
+				{Name: "shape", Ints: []int64{3, 4}},
+				{Name: "values", Floats: []float32{1, 0, 0, 0, 0, 1, 0, 0, 1, 1, 1, 1}},
+			}},
+			{Type: "GivenTensorFill", Output: []string{"fc_b"}, Arg: []*caffe2.Argument{
+				{Name: "shape", Ints: []int64{3}},
+				{Name: "values", Floats: []float32{0, 0, 1}},
+			}},
+		},
+	}
+	return testTensorModel(t, name, []*dlframework.ModelManifest_Type{tensorInput("x", dtype, "[-1, 4]")}, graph, weights)
+}
+
+func loadTensorPredictor(t *testing.T, model dlframework.ModelManifest, backend string) *TensorPredictor {
+	predictor, err := NewWithBackend(model, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp, ok := predictor.(*TensorPredictor)
+	if !ok {
+		t.Fatalf("NewWithBackend returned a %T, want a *TensorPredictor", predictor)
+	}
+	return tp
+}
+
+func newTensor(t *testing.T, name string, dims []int64, data interface{}) *caffe2.TensorProto {
+	tensor, err := caffe2.NewTensorProto(name, dims, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tensor
+}
+
+func TestTensorPredictor(t *testing.T) {
+	fake := registerFake(3, false)
+	p := loadTensorPredictor(t, testFCModel(t, "tensor_predictor", ""), fake.name)
+	defer p.Close()
+
+	if want := []TensorInput{{Name: "x", DataType: caffe2.TensorProto_FLOAT, Shape: []int64{-1, 4}}}; !reflect.DeepEqual(p.Inputs(), want) {
+		t.Errorf("Inputs() = %+v, want %+v", p.Inputs(), want)
+	}
+	if want := []string{"y"}; !reflect.DeepEqual(p.Outputs(), want) {
+		t.Errorf("Outputs() = %v, want %v", p.Outputs(), want)
+	}
+
+	x := newTensor(t, "x", []int64{2, 4}, []float32{1, 0, 0, 0, 2, 0, 0, 0})
+	res, err := p.PredictTensors(context.Background(), map[string]*caffe2.TensorProto{"x": x})
+	if err != nil {
+		t.Fatal(err)
+	}
+	y, ok := res["y"]
+	if !ok || len(res) != 1 {
+		t.Fatalf("PredictTensors returned %v, want y", res)
+	}
+	if want := []int64{2, 3}; !reflect.DeepEqual(y.GetDims(), want) {
+		t.Errorf("y has dimensions %v, want %v", y.GetDims(), want)
+	}
+	if want := []float32{0.25, 0.5, 0.25, 0.25, 0.25, 0.5}; !reflect.DeepEqual(y.GetFloatData(), want) {
+		t.Errorf("y = %v, want %v", y.GetFloatData(), want)
+	}
+
+	features, err := p.Predict(context.Background(), [][]float32{{1, 0, 0, 0}, {2, 0, 0, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 2 || len(features[0]) != 3 {
+		t.Fatalf("Predict returned %v, want 3 features for each of the 2 elements", features)
+	}
+	if top := features[1][2]; top.Name != "y" || top.Index != 2 || top.Probability != 0.5 {
+		t.Errorf("feature 2 of element 1 = %+v, want y[2] = 0.5", top)
+	}
+}
+
+func TestTensorPredictorErrors(t *testing.T) {
+	fake := registerFake(3, false)
+	p := loadTensorPredictor(t, testFCModel(t, "tensor_predictor_errors", ""), fake.name)
+	defer p.Close()
+
+	x := newTensor(t, "x", []int64{1, 4}, []float32{1, 2, 3, 4})
+	cases := []struct {
+		name   string
+		inputs map[string]*caffe2.TensorProto
+		err    string
+	}{
+		{"missing input", map[string]*caffe2.TensorProto{}, "missing input x"},
+		{"unexpected input", map[string]*caffe2.TensorProto{"x": x, "z": x}, "unexpected input z"},
+		{"data type", map[string]*caffe2.TensorProto{"x": newTensor(t, "x", []int64{1, 4}, []int32{1, 2, 3, 4})}, "is a INT32 tensor"},
+		{"shape", map[string]*caffe2.TensorProto{"x": newTensor(t, "x", []int64{1, 3}, []float32{1, 2, 3})}, "has dimensions [1 3]"},
+	}
+	for _, c := range cases {
+		if _, err := p.PredictTensors(context.Background(), c.inputs); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: PredictTensors = %v, want an error containing %q", c.name, err, c.err)
+		}
+	}
+	inputs := map[string]*caffe2.TensorProto{"x": x}
+	if _, err := p.PredictTensors(context.Background(), inputs, Outputs("fc_w")); err == nil || !strings.Contains(err.Error(), "not computed by the graph") {
+		t.Errorf("PredictTensors of a weight: %v", err)
+	}
+	if _, err := p.Predict(context.Background(), [][]float32{{1, 2, 3}}); err == nil || !strings.Contains(err.Error(), "has 3 values") {
+		t.Errorf("Predict of a short element: %v", err)
+	}
+
+	unknown := testFCModel(t, "unknown_input", "")
+	unknown.Inputs[0].Parameters["name"].Value = "z"
+	if _, err := NewWithBackend(unknown, fake.name); err == nil || !strings.Contains(err.Error(), "input z is not an external input of the graph") {
+		t.Errorf("Load with an unknown input: %v", err)
+	}
+}
+
+func TestTensorPredictorReference(t *testing.T) {
+	p := loadTensorPredictor(t, testFCModel(t, "tensor_reference", "float32"), "reference")
+	defer p.Close()
+
+	x := newTensor(t, "x", []int64{2, 4}, []float32{1, 2, 3, 4, -1, 0, 1, 0})
+	res, err := p.PredictTensors(context.Background(), map[string]*caffe2.TensorProto{"x": x})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float32{1, 2, 11, -1, 0, 1}; !reflect.DeepEqual(res["y"].GetFloatData(), want) {
+		t.Errorf("y = %v, want %v", res["y"].GetFloatData(), want)
+	}
+}
+
+// TestLoadDispatch checks that the ImagePredictor registered with the agent
+// loads the models without an image input with a TensorPredictor.
+func TestLoadDispatch(t *testing.T) {
+	fake := registerFake(4, false)
+	registered := &ImagePredictor{backendName: fake.name}
+
+	predictor, err := registered.Load(context.Background(), testFCModel(t, "dispatch_tensor", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer predictor.Close()
+	if _, ok := predictor.(*TensorPredictor); !ok {
+		t.Errorf("Load of a tensor model returned a %T, want a *TensorPredictor", predictor)
+	}
+
+	predictor, err = registered.Load(context.Background(), testModel(t, "dispatch_image", 4, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer predictor.Close()
+	if _, ok := predictor.(*ImagePredictor); !ok {
+		t.Errorf("Load of an image model returned a %T, want an *ImagePredictor", predictor)
+	}
+}
+
+func TestTensorBackendCheck(t *testing.T) {
+	_, err := NewWithBackend(testFCModel(t, "string_input", "string"), "reference")
+	if err == nil || !strings.Contains(err.Error(), "does not support the string input x") {
+		t.Errorf("Load of a string input on the reference backend: %v", err)
+	}
+}
+
+// fcWeights returns the fill operators of the weights and bias of an FC
+// operator with n outputs of inputSize values.
+func fcWeights(prefix string, n, inputSize int64) []*caffe2.OperatorDef {
+	return []*caffe2.OperatorDef{
+		{Type: "GivenTensorFill", Output: []string{prefix + "_w"}, Arg: []*caffe2.Argument{
+			{Name: "shape", Ints: []int64{n, inputSize}},
+			{Name: "values", Floats: make([]float32, n*inputSize)},
+		}},
+		{Type: "ConstantFill", Output: []string{prefix + "_b"}, Arg: []*caffe2.Argument{
+			{Name: "shape", Ints: []int64{n}},
+		}},
+	}
+}
+
+func TestTensorPredictorMultipleInputsOutputs(t *testing.T) {
+	graph := &caffe2.NetDef{
+		Name:          "two_inputs",
+		ExternalInput: []string{"a", "b", "fa_w", "fa_b", "fb_w", "fb_b"},
+		Op: []*caffe2.OperatorDef{
+			{Type: "FC", Input: []string{"a", "fa_w", "fa_b"}, Output: []string{"y"}},
+			{Type: "FC", Input: []string{"b", "fb_w", "fb_b"}, Output: []string{"z"}},
+		},
+		ExternalOutput: []string{"y", "z"},
+	}
+	weights := &caffe2.NetDef{
+		Name: "two_inputs_init",
+		Op:   append(fcWeights("fa", 3, 2), fcWeights("fb", 3, 2)...),
+	}
+	// the unnamed input takes the graph input left
+	inputs := []*dlframework.ModelManifest_Type{tensorInput("b", "float32", "[-1, 2]"), tensorInput("", "", "[-1, 2]")}
+	fake := registerFake(3, false)
+	p := loadTensorPredictor(t, testTensorModel(t, "two_inputs", inputs, graph, weights), fake.name)
+	defer p.Close()
+
+	if names := []string{p.Inputs()[0].Name, p.Inputs()[1].Name}; !reflect.DeepEqual(names, []string{"b", "a"}) {
+		t.Errorf("inputs are named %v, want [b a]", names)
+	}
+	if want := []string{"y", "z"}; !reflect.DeepEqual(p.Outputs(), want) {
+		t.Errorf("Outputs() = %v, want %v", p.Outputs(), want)
+	}
+
+	feed := map[string]*caffe2.TensorProto{
+		"a": newTensor(t, "a", []int64{1, 2}, []float32{1, 0}),
+		"b": newTensor(t, "b", []int64{1, 2}, []float32{2, 0}),
+	}
+	res, err := p.PredictTensors(context.Background(), feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("PredictTensors returned %d outputs, want y and z", len(res))
+	}
+	// y is computed from a and z from b
+	if want := []float32{0.25, 0.5, 0.25}; !reflect.DeepEqual(res["y"].GetFloatData(), want) {
+		t.Errorf("y = %v, want %v", res["y"].GetFloatData(), want)
+	}
+	if want := []float32{0.25, 0.25, 0.5}; !reflect.DeepEqual(res["z"].GetFloatData(), want) {
+		t.Errorf("z = %v, want %v", res["z"].GetFloatData(), want)
+	}
+
+	res, err = p.PredictTensors(context.Background(), feed, Outputs("z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res["z"]; !ok || len(res) != 1 {
+		t.Errorf("PredictTensors of z returned %v, want only z", res)
+	}
+
+	if _, err := p.Predict(context.Background(), [][]float32{{1, 0}}); err == nil || !strings.Contains(err.Error(), "single float32 input") {
+		t.Errorf("Predict with two inputs: %v", err)
+	}
+}
+
+// singleTensorBackend rejects the models with several inputs or outputs, as
+// the gocaffe2 backend does.
+type singleTensorBackend struct {
+	*fakeBackend
+}
+
+func (b singleTensorBackend) CheckTensors(inputs []TensorInput, outputs []string) error {
+	if len(inputs) != 1 || len(outputs) != 1 {
+		return errors.Errorf("got %d inputs and %d outputs", len(inputs), len(outputs))
+	}
+	return nil
+}
+
+func TestTensorPredictorFallback(t *testing.T) {
+	RegisterBackend("single_tensor", func(opts ...options.Option) (Backend, error) {
+		return singleTensorBackend{newFakeBackend(3)}, nil
+	})
+	defaultBackend := DefaultBackend
+	DefaultBackend = "single_tensor"
+	defer func() { DefaultBackend = defaultBackend }()
+
+	graph := &caffe2.NetDef{
+		Name:          "fallback",
+		ExternalInput: []string{"a", "b", "fa_w", "fa_b", "fb_w", "fb_b"},
+		Op: []*caffe2.OperatorDef{
+			{Type: "FC", Input: []string{"a", "fa_w", "fa_b"}, Output: []string{"y"}},
+			{Type: "FC", Input: []string{"b", "fb_w", "fb_b"}, Output: []string{"z"}},
+		},
+		ExternalOutput: []string{"y", "z"},
+	}
+	weights := &caffe2.NetDef{
+		Name: "fallback_init",
+		Op:   append(fcWeights("fa", 3, 2), fcWeights("fb", 3, 2)...),
+	}
+	inputs := []*dlframework.ModelManifest_Type{tensorInput("a", "", "[-1, 2]"), tensorInput("b", "", "[-1, 2]")}
+	model := testTensorModel(t, "fallback", inputs, graph, weights)
+
+	// the model runs on the reference backend unless a backend is selected
+	p := loadTensorPredictor(t, model, "")
+	defer p.Close()
+	if p.backendName != "reference" {
+		t.Errorf("the model runs on the %s backend, want reference", p.backendName)
+	}
+	feed := map[string]*caffe2.TensorProto{
+		"a": newTensor(t, "a", []int64{1, 2}, []float32{1, 0}),
+		"b": newTensor(t, "b", []int64{1, 2}, []float32{2, 0}),
+	}
+	res, err := p.PredictTensors(context.Background(), feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || !reflect.DeepEqual(res["y"].GetDims(), []int64{1, 3}) {
+		t.Errorf("PredictTensors returned %v, want y and z of dimensions [1 3]", res)
+	}
+
+	_, err = NewWithBackend(model, "single_tensor")
+	if err == nil || !strings.Contains(err.Error(), "on the single_tensor backend: got 2 inputs") {
+		t.Errorf("NewWithBackend on the selected backend: %v", err)
+	}
+}
+
+func TestTensorPredictorOutputs(t *testing.T) {
+	graph := &caffe2.NetDef{
+		Name:          "two_outputs",
+		ExternalInput: []string{"x", "fc_w", "fc_b"},
+		Op: []*caffe2.OperatorDef{
+			{Type: "FC", Input: []string{"x", "fc_w", "fc_b"}, Output: []string{"y"}},
+			{Type: "Softmax", Input: []string{"y"}, Output: []string{"z"}},
+		},
+		ExternalOutput: []string{"y", "z"},
+	}
+	weights := &caffe2.NetDef{Name: "two_outputs_init", Op: fcWeights("fc", 3, 2)}
+	fake := registerFake(3, false)
+	p := loadTensorPredictor(t, testTensorModel(t, "two_outputs", []*dlframework.ModelManifest_Type{tensorInput("x", "", "[-1, 2]")}, graph, weights), fake.name)
+	defer p.Close()
+
+	data := [][]float32{{1, 0}, {2, 0}}
+	outputs, err := p.PredictOutputs(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 2 {
+		t.Fatalf("PredictOutputs returned %d outputs, want y and z", len(outputs))
+	}
+	for _, name := range []string{"y", "z"} {
+		features := outputs[name]
+		if len(features) != 2 || len(features[0]) != 3 {
+			t.Fatalf("output %s = %v, want 3 features for each of the 2 elements", name, features)
+		}
+		if f := features[1][2]; f.Name != name || f.Probability != 0.5 {
+			t.Errorf("feature 2 of element 1 of %s = %+v", name, f)
+		}
+	}
+
+	if _, err := p.Predict(context.Background(), data); err == nil || !strings.Contains(err.Error(), "y, z are selected") {
+		t.Errorf("Predict of two outputs: %v", err)
+	}
+	features, err := p.Predict(context.Background(), data, Outputs("z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 2 || features[0][0].Name != "z" {
+		t.Errorf("Predict of z = %v", features)
+	}
+}
@@ -0,0 +1,155 @@
+package caffe2
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Quantize stores a numeric tensor in a QTensorProto using precision bits per
+// element. The values are mapped linearly onto the integer range so that
+// value = data * scale + bias.
+func Quantize(tensor *TensorProto, precision int, signed bool) (*QTensorProto, error) {
+	if precision < 1 || precision > 31 {
+		return nil, errors.Errorf("invalid quantization precision %d, expecting 1 to 31 bits", precision)
+	}
+	decoded, err := DecodeTensor(tensor)
+	if err != nil {
+		return nil, err
+	}
+	values, err := decoded.Float32s()
+	if err != nil {
+		return nil, err
+	}
+
+	minVal, maxVal := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, errors.Errorf("tensor %s holds non finite values", tensor.GetName())
+		}
+		minVal = math.Min(minVal, f)
+		maxVal = math.Max(maxVal, f)
+	}
+	if len(values) == 0 {
+		minVal, maxVal = 0, 0
+	}
+
+	levels := float64(uint64(1)<<uint(precision) - 1)
+	qmin := 0.0
+	if signed {
+		qmin = -float64(uint64(1) << uint(precision-1))
+	}
+	qmax := qmin + levels
+
+	scale := (maxVal - minVal) / levels
+	if scale == 0 {
+		scale = 1
+	}
+	bias := minVal - qmin*scale
+
+	data := make([]int32, len(values))
+	for ii, v := range values {
+		q := math.Floor((float64(v)-bias)/scale + 0.5)
+		data[ii] = int32(math.Max(qmin, math.Min(qmax, q)))
+	}
+
+	return &QTensorProto{
+		Name:      tensor.GetName(),
+		Dims:      append([]int64{}, tensor.GetDims()...),
+		Precision: int32(precision),
+		Scale:     scale,
+		Bias:      bias,
+		IsSigned:  signed,
+		Data:      data,
+	}, nil
+}
+
+// Dequantize restores a float tensor from a QTensorProto.
+func Dequantize(qtensor *QTensorProto) (*TensorProto, error) {
+	data := qtensor.GetData()
+	if expected := dimsProduct(qtensor.GetDims()); int64(len(data)) != expected {
+		return nil, errors.Errorf("quantized tensor %s has %d elements but its dimensions %v need %d", qtensor.GetName(), len(data), qtensor.GetDims(), expected)
+	}
+	values := make([]float32, len(data))
+	for ii, q := range data {
+		values[ii] = float32(float64(q)*qtensor.GetScale() + qtensor.GetBias())
+	}
+	return NewTensorProto(qtensor.GetName(), qtensor.GetDims(), values)
+}
+
+// QuantizationReport measures the error introduced by quantizing a tensor.
+type QuantizationReport struct {
+	Name         string  `json:"name"`
+	Precision    int32   `json:"precision"`
+	Elements     int64   `json:"elements"`
+	MaxAbsError  float64 `json:"max_abs_error"`
+	MeanAbsError float64 `json:"mean_abs_error"`
+	// SNR is the signal to noise ratio in decibels, +Inf if lossless. JSON
+	// has no infinity, the infinite ratios are encoded as null.
+	SNR            float64 `json:"snr_db"`
+	OriginalBytes  int64   `json:"original_bytes"`
+	QuantizedBytes int64   `json:"quantized_bytes"`
+}
+
+// MarshalJSON encodes the report, with a null SNR when it is not finite.
+func (r QuantizationReport) MarshalJSON() ([]byte, error) {
+	type report QuantizationReport
+	res := struct {
+		report
+		SNR *float64 `json:"snr_db"`
+	}{report: report(r)}
+	if !math.IsInf(r.SNR, 0) && !math.IsNaN(r.SNR) {
+		res.SNR = &r.SNR
+	}
+	return json.Marshal(res)
+}
+
+// QuantizationError compares a tensor with its quantized version.
+func QuantizationError(original *TensorProto, qtensor *QTensorProto) (*QuantizationReport, error) {
+	decoded, err := DecodeTensor(original)
+	if err != nil {
+		return nil, err
+	}
+	values, err := decoded.Float32s()
+	if err != nil {
+		return nil, err
+	}
+	restored, err := Dequantize(qtensor)
+	if err != nil {
+		return nil, err
+	}
+	approx := restored.GetFloatData()
+	if len(approx) != len(values) {
+		return nil, errors.Errorf("tensor %s has %d elements but its quantized version has %d", original.GetName(), len(values), len(approx))
+	}
+
+	report := &QuantizationReport{
+		Name:           original.GetName(),
+		Precision:      qtensor.GetPrecision(),
+		Elements:       int64(len(values)),
+		OriginalBytes:  int64(len(values)) * DataTypeSize(original.GetDataType()),
+		QuantizedBytes: (int64(len(values))*int64(qtensor.GetPrecision()) + 7) / 8,
+	}
+	var signal, noise, absSum float64
+	for ii, v := range values {
+		diff := math.Abs(float64(v) - float64(approx[ii]))
+		report.MaxAbsError = math.Max(report.MaxAbsError, diff)
+		absSum += diff
+		signal += float64(v) * float64(v)
+		noise += diff * diff
+	}
+	if len(values) != 0 {
+		report.MeanAbsError = absSum / float64(len(values))
+	}
+	switch {
+	case noise == 0:
+		report.SNR = math.Inf(1)
+	case signal == 0:
+		report.SNR = math.Inf(-1)
+	default:
+		report.SNR = 10 * math.Log10(signal/noise)
+	}
+	return report, nil
+}
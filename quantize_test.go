@@ -0,0 +1,92 @@
+package caffe2
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestQuantize(t *testing.T) {
+	tensor, _ := NewTensorProto("w", []int64{5}, []float32{-1, -0.5, 0, 0.5, 1})
+	qtensor, err := Quantize(tensor, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4 levels over [-1, 1]
+	if qtensor.GetScale() != 2.0/3 || qtensor.GetBias() != -1 {
+		t.Errorf("scale, bias = %v, %v, want 2/3, -1", qtensor.GetScale(), qtensor.GetBias())
+	}
+	if want := []int32{0, 1, 2, 2, 3}; !reflect.DeepEqual(qtensor.GetData(), want) {
+		t.Errorf("quantized data = %v, want %v", qtensor.GetData(), want)
+	}
+
+	signed, err := Quantize(tensor, 8, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := signed.GetData(); data[0] != -128 || data[4] != 127 {
+		t.Errorf("signed quantized data = %v, want it in [-128, 127]", data)
+	}
+	restored, err := Dequantize(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ii, v := range restored.GetFloatData() {
+		if want := tensor.GetFloatData()[ii]; math.Abs(float64(v-want)) > signed.GetScale()/2+1e-6 {
+			t.Errorf("dequantized %d = %v, want %v", ii, v, want)
+		}
+	}
+
+	if _, err := Quantize(tensor, 32, false); err == nil {
+		t.Error("Quantize with 32 bits succeeded")
+	}
+	nan, _ := NewTensorProto("nan", []int64{1}, []float32{float32(math.NaN())})
+	if _, err := Quantize(nan, 8, false); err == nil {
+		t.Error("Quantize of a NaN succeeded")
+	}
+}
+
+func TestQuantizationError(t *testing.T) {
+	tensor, _ := NewTensorProto("w", []int64{4}, []float32{0, 1, 2, 3})
+	qtensor, err := Quantize(tensor, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := QuantizationError(tensor, qtensor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.MaxAbsError > 1e-6 || !math.IsInf(report.SNR, 1) {
+		t.Errorf("lossless quantization report = %+v", report)
+	}
+	if report.OriginalBytes != 16 || report.QuantizedBytes != 1 {
+		t.Errorf("bytes = %d, %d, want 16, 1", report.OriginalBytes, report.QuantizedBytes)
+	}
+
+	// the infinite ratio is not valid JSON
+	buf, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if snr, ok := decoded["snr_db"]; !ok || snr != nil {
+		t.Errorf("snr_db = %v in %s, want null", snr, buf)
+	}
+	if decoded["name"] != "w" || decoded["elements"] != 4.0 {
+		t.Errorf("report JSON = %s", buf)
+	}
+
+	report.SNR = 12.5
+	buf, err = json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back QuantizationReport
+	if err := json.Unmarshal(buf, &back); err != nil || !reflect.DeepEqual(back, *report) {
+		t.Errorf("report JSON round trip = %+v, %v, want %+v", back, err, *report)
+	}
+}
@@ -4,33 +4,92 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 
 	"github.com/Unknwon/com"
 	"github.com/k0kubun/pp"
+	"github.com/pkg/errors"
 	"github.com/rai-project/caffe2"
 )
 
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{}
+
+func init() {
+	commands["print"] = command{
+		usage: "print <predict_net.pb>",
+		run:   printCommand,
+	}
+}
+
 // example Usage:
-// go run caffe_reader.go ~/data/carml/dlframework/caffe2_0.8.1/squeezenet_1.0/predict_net.pb
+// go run *.go ~/data/carml/dlframework/caffe2_0.8.1/squeezenet_1.0/predict_net.pb
+// go run *.go dot -collapse ~/data/carml/dlframework/caffe2_0.8.1/squeezenet_1.0/predict_net.pb
 func main() {
-	modelFile := os.Args[1]
-	if !com.IsFile(modelFile) {
-		fmt.Println("unable to find", modelFile)
+	if len(os.Args) < 2 {
+		usage()
 		os.Exit(-1)
 	}
+
+	name, args := os.Args[1], os.Args[2:]
+	cmd, ok := commands[name]
+	if !ok {
+		// keep the original behavior of printing the operators of a file
+		cmd, args = commands["print"], os.Args[1:]
+	}
+	if err := cmd.run(args); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println("usage:")
+	for _, name := range names {
+		fmt.Println("  caffe_reader", commands[name].usage)
+	}
+}
+
+func usageError(name string) error {
+	return errors.New("usage: caffe_reader " + commands[name].usage)
+}
+
+func readNetDef(modelFile string) (*caffe2.NetDef, error) {
+	if !com.IsFile(modelFile) {
+		return nil, errors.Errorf("unable to find %v", modelFile)
+	}
 	buf, err := ioutil.ReadFile(modelFile)
 	if err != nil {
-		fmt.Println("unable to read file", err)
-		os.Exit(-1)
+		return nil, errors.Wrap(err, "unable to read file")
 	}
 	def := &caffe2.NetDef{}
 	err = def.Unmarshal(buf)
 	if err != nil {
-		fmt.Println("unable to unmarshal file", modelFile)
-		os.Exit(-1)
+		return nil, errors.Errorf("unable to unmarshal file %v", modelFile)
+	}
+	return def, nil
+}
+
+func printCommand(args []string) error {
+	if len(args) != 1 {
+		return usageError("print")
+	}
+	def, err := readNetDef(args[0])
+	if err != nil {
+		return err
 	}
 	// pp.Println(def.DeviceOption)
 	for _, op := range def.Op {
 		pp.Println(op.GetType())
 	}
+	return nil
 }
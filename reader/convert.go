@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+)
+
+func init() {
+	commands["convert"] = command{
+		usage: "convert [-type " + strings.Join(caffe2.MessageTypes(), "|") + "] [-from format] [-to format] <input> <output|->",
+		run:   convertCommand,
+	}
+}
+
+func convertCommand(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	typeName := flags.String("type", "NetDef", "message type stored in the input file")
+	fromFormat := flags.String("from", "", "input format (binary, text or json), guessed from the extension by default")
+	toFormat := flags.String("to", "", "output format (binary, text or json), guessed from the extension by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usageError("convert")
+	}
+	inputFile, outputFile := flags.Arg(0), flags.Arg(1)
+
+	from, err := formatFlag(*fromFormat, inputFile)
+	if err != nil {
+		return err
+	}
+	to, err := formatFlag(*toFormat, outputFile)
+	if err != nil {
+		return err
+	}
+	if outputFile == "-" && *toFormat == "" {
+		to = caffe2.FormatText
+	}
+
+	buf, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to read file")
+	}
+	res, err := caffe2.Convert(buf, *typeName, from, to)
+	if err != nil {
+		return err
+	}
+	if outputFile == "-" {
+		_, err = os.Stdout.Write(res)
+		return err
+	}
+	return errors.Wrapf(ioutil.WriteFile(outputFile, res, 0644), "unable to write %v", outputFile)
+}
+
+func formatFlag(value, path string) (caffe2.Format, error) {
+	if value == "" {
+		return caffe2.FormatFromPath(path), nil
+	}
+	return caffe2.ParseFormat(value)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+)
+
+func init() {
+	commands["diff"] = command{
+		usage: "diff [-json] <old_predict_net.pb> <new_predict_net.pb>",
+		run:   diffCommand,
+	}
+}
+
+func diffCommand(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "output the difference as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usageError("diff")
+	}
+
+	from, err := readNetDef(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	to, err := readNetDef(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	diff := caffe2.DiffNets(from, to)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return errors.Wrap(enc.Encode(diff), "unable to encode the difference")
+	}
+	_, err = diff.WriteTo(os.Stdout)
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+)
+
+func init() {
+	commands["dot"] = command{
+		usage: "dot [-collapse] [-init init_net.pb] [-rankdir TB] [-o out.dot] <predict_net.pb>",
+		run:   dotCommand,
+	}
+}
+
+func dotCommand(args []string) error {
+	flags := flag.NewFlagSet("dot", flag.ContinueOnError)
+	collapse := flags.Bool("collapse", false, "collapse weight blobs into the operator nodes")
+	initFile := flags.String("init", "", "init_net.pb used to detect the weight blobs")
+	rankDir := flags.String("rankdir", "TB", "graphviz rank direction")
+	outputFile := flags.String("o", "", "output file (defaults to stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError("dot")
+	}
+
+	net, err := readNetDef(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	opts := caffe2.DotOptions{
+		CollapseWeights: *collapse,
+		RankDir:         *rankDir,
+	}
+	if *initFile != "" {
+		opts.InitNet, err = readNetDef(*initFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *outputFile != "" {
+		f, err := os.Create(*outputFile)
+		if err != nil {
+			return errors.Wrapf(err, "unable to create %v", *outputFile)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return caffe2.WriteDot(w, net, opts)
+}
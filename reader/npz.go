@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+)
+
+func init() {
+	commands["export"] = command{
+		usage: "export <init_net.pb> <weights.npz>",
+		run:   exportCommand,
+	}
+	commands["import"] = command{
+		usage: "import [-name net] [-to format] <weights.npz> <init_net.pb>",
+		run:   importCommand,
+	}
+}
+
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usageError("export")
+	}
+	init, err := readNetDef(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	tensors, err := caffe2.InitNetTensors(init)
+	if err != nil {
+		return err
+	}
+
+	outputFile := flags.Arg(1)
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %v", outputFile)
+	}
+	if err := caffe2.WriteNpz(f, tensors); err != nil {
+		f.Close()
+		os.Remove(outputFile)
+		return err
+	}
+	return errors.Wrapf(f.Close(), "unable to write %v", outputFile)
+}
+
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	name := flags.String("name", "", "name of the generated init net")
+	toFormat := flags.String("to", "", "output format (binary, text or json), guessed from the extension by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usageError("import")
+	}
+	inputFile, outputFile := flags.Arg(0), flags.Arg(1)
+
+	to, err := formatFlag(*toFormat, outputFile)
+	if err != nil {
+		return err
+	}
+	tensors, err := caffe2.ReadNpzFile(inputFile)
+	if err != nil {
+		return err
+	}
+	init, err := caffe2.NewInitNet(*name, tensors)
+	if err != nil {
+		return err
+	}
+	buf, err := caffe2.MarshalFormat(init, to)
+	if err != nil {
+		return err
+	}
+	return errors.Wrapf(ioutil.WriteFile(outputFile, buf, 0644), "unable to write %v", outputFile)
+}
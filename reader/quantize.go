@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+)
+
+func init() {
+	commands["quantize"] = command{
+		usage: "quantize [-bits 8] [-signed] [-to format] <init_net.pb> <output_dir>",
+		run:   quantizeCommand,
+	}
+}
+
+func quantizeCommand(args []string) error {
+	flags := flag.NewFlagSet("quantize", flag.ContinueOnError)
+	bits := flags.Int("bits", 8, "quantization precision in bits")
+	signed := flags.Bool("signed", false, "use a signed integer range")
+	toFormat := flags.String("to", "binary", "output format (binary, text or json)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usageError("quantize")
+	}
+	outputDir := flags.Arg(1)
+
+	to, err := caffe2.ParseFormat(*toFormat)
+	if err != nil {
+		return err
+	}
+	init, err := readNetDef(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return errors.Wrapf(err, "unable to create %v", outputDir)
+	}
+	tensors, err := caffe2.InitNetTensors(init)
+	if err != nil {
+		return err
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Blob", "Elements", "Max Abs Error", "Mean Abs Error", "SNR (dB)", "Bytes", "Quantized Bytes"})
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	var originalBytes, quantizedBytes int64
+	for _, tensor := range tensors.GetProtos() {
+		if caffe2.DataTypeSize(tensor.GetDataType()) == 0 {
+			continue
+		}
+		qtensor, err := caffe2.Quantize(tensor, *bits, *signed)
+		if err != nil {
+			return err
+		}
+		report, err := caffe2.QuantizationError(tensor, qtensor)
+		if err != nil {
+			return err
+		}
+		if err := writeQTensor(outputDir, qtensor, to); err != nil {
+			return err
+		}
+		originalBytes += report.OriginalBytes
+		quantizedBytes += report.QuantizedBytes
+		table.Append([]string{
+			report.Name,
+			strconv.FormatInt(report.Elements, 10),
+			fmt.Sprintf("%.6g", report.MaxAbsError),
+			fmt.Sprintf("%.6g", report.MeanAbsError),
+			fmt.Sprintf("%.2f", report.SNR),
+			strconv.FormatInt(report.OriginalBytes, 10),
+			strconv.FormatInt(report.QuantizedBytes, 10),
+		})
+	}
+	table.SetFooter([]string{"", "", "", "", "Total", strconv.FormatInt(originalBytes, 10), strconv.FormatInt(quantizedBytes, 10)})
+	table.Render()
+	return nil
+}
+
+// writeQTensor writes a quantized tensor in its own file of the output
+// directory, named after its blob.
+func writeQTensor(dir string, qtensor *caffe2.QTensorProto, format caffe2.Format) error {
+	buf, err := caffe2.MarshalFormat(qtensor, format)
+	if err != nil {
+		return err
+	}
+	ext := map[caffe2.Format]string{
+		caffe2.FormatBinary: ".pb",
+		caffe2.FormatText:   ".pbtxt",
+		caffe2.FormatJSON:   ".json",
+	}[format]
+	name := strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(qtensor.GetName())
+	outputFile := filepath.Join(dir, name+ext)
+	return errors.Wrapf(ioutil.WriteFile(outputFile, buf, 0644), "unable to write %v", outputFile)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+	"github.com/rai-project/caffe2/executor"
+)
+
+func init() {
+	commands["run"] = command{
+		usage: "run -init init_net.pb -input data=input.npy [-output blob] [-o outputs.npz] <predict_net.pb>",
+		run:   runCommand,
+	}
+}
+
+// stringsFlag collects repeated flags
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func runCommand(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	initFile := flags.String("init", "", "init_net.pb holding the weights")
+	outputFile := flags.String("o", "", "write the outputs to an .npz file")
+	var inputs, outputs stringsFlag
+	flags.Var(&inputs, "input", "external input read from an .npy file as name=path (can be repeated)")
+	flags.Var(&outputs, "output", "blob to output instead of the net outputs (can be repeated)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *initFile == "" {
+		return usageError("run")
+	}
+
+	net, err := readNetDef(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	init, err := readNetDef(*initFile)
+	if err != nil {
+		return err
+	}
+	exec, err := executor.New(net, init)
+	if err != nil {
+		return err
+	}
+
+	blobs := map[string]*executor.Blob{}
+	for _, input := range inputs {
+		kv := strings.SplitN(input, "=", 2)
+		if len(kv) != 2 {
+			return errors.Errorf("invalid input %v, expecting name=path", input)
+		}
+		tensor, err := caffe2.ReadNpyFile(kv[1])
+		if err != nil {
+			return err
+		}
+		blob, err := executor.BlobFromTensor(tensor)
+		if err != nil {
+			return errors.Wrapf(err, "invalid input %v", kv[0])
+		}
+		blobs[kv[0]] = blob
+	}
+
+	res, err := exec.Run(blobs, outputs...)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(res))
+	for name := range res {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if *outputFile != "" {
+		tensors := &caffe2.TensorProtos{}
+		for _, name := range names {
+			tensor, err := res[name].TensorProto(name)
+			if err != nil {
+				return err
+			}
+			tensors.Protos = append(tensors.Protos, tensor)
+		}
+		f, err := os.Create(*outputFile)
+		if err != nil {
+			return errors.Wrapf(err, "unable to create %v", *outputFile)
+		}
+		if err := caffe2.WriteNpz(f, tensors); err != nil {
+			f.Close()
+			return err
+		}
+		return errors.Wrapf(f.Close(), "unable to write %v", *outputFile)
+	}
+
+	for _, name := range names {
+		blob := res[name]
+		fmt.Printf("%s %v\n", name, blob.Dims)
+		if len(blob.Dims) == 0 {
+			continue
+		}
+		// show the top entries of each row of the last dimension
+		width := int(blob.Dims[len(blob.Dims)-1])
+		for start := 0; width > 0 && start < len(blob.Data); start += width {
+			row := blob.Data[start : start+width]
+			index := make([]int, len(row))
+			for ii := range index {
+				index[ii] = ii
+			}
+			sort.SliceStable(index, func(ii, jj int) bool {
+				return row[index[ii]] > row[index[jj]]
+			})
+			if len(index) > 5 {
+				index = index[:5]
+			}
+			top := make([]string, len(index))
+			for ii, idx := range index {
+				top[ii] = fmt.Sprintf("%d:%.6g", idx, row[idx])
+			}
+			fmt.Printf("  %s\n", strings.Join(top, " "))
+		}
+	}
+	return nil
+}
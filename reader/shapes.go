@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+)
+
+func init() {
+	commands["shapes"] = command{
+		usage: "shapes [-init init_net.pb] -input data=1,3,224,224 <predict_net.pb>",
+		run:   shapesCommand,
+	}
+}
+
+// inputShapesFlag collects repeated name=d0,d1,... flags
+type inputShapesFlag map[string][]int64
+
+func (f inputShapesFlag) String() string {
+	return fmt.Sprint(map[string][]int64(f))
+}
+
+func (f inputShapesFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 {
+		return errors.Errorf("invalid input shape %v, expecting name=d0,d1,...", value)
+	}
+	var dims []int64
+	for _, s := range strings.Split(kv[1], ",") {
+		dim, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "invalid dimension in %v", value)
+		}
+		dims = append(dims, dim)
+	}
+	f[kv[0]] = dims
+	return nil
+}
+
+func readInputShapes(flags *flag.FlagSet) inputShapesFlag {
+	inputs := inputShapesFlag{}
+	flags.Var(inputs, "input", "shape of an external input as name=d0,d1,... (can be repeated)")
+	return inputs
+}
+
+func shapesCommand(args []string) error {
+	flags := flag.NewFlagSet("shapes", flag.ContinueOnError)
+	initFile := flags.String("init", "", "init_net.pb used to get the weight shapes")
+	inputs := readInputShapes(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError("shapes")
+	}
+
+	net, err := readNetDef(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	inputShapes := map[string][]int64{}
+	if *initFile != "" {
+		init, err := readNetDef(*initFile)
+		if err != nil {
+			return err
+		}
+		inputShapes = caffe2.InitNetShapes(init)
+	}
+	for name, dims := range inputs {
+		inputShapes[name] = dims
+	}
+
+	shapes, err := caffe2.InferShapes(net, inputShapes)
+	if err != nil {
+		return err
+	}
+	for _, shape := range shapes.GetShapes() {
+		fmt.Printf("%-40s %s\n", shape.GetName(), formatShape(shape))
+	}
+	return nil
+}
+
+func formatShape(shape *caffe2.TensorShape) string {
+	if shape.GetUnknownShape() {
+		return "?"
+	}
+	dims := make([]string, len(shape.GetDims()))
+	for ii, dim := range shape.GetDims() {
+		if dim < 0 {
+			dims[ii] = "?"
+			continue
+		}
+		dims[ii] = strconv.FormatInt(dim, 10)
+	}
+	return "[" + strings.Join(dims, ", ") + "]"
+}
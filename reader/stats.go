@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+	"github.com/rai-project/caffe2"
+)
+
+func init() {
+	commands["stats"] = command{
+		usage: "stats -init init_net.pb -input data=1,3,224,224 [-json] <predict_net.pb>",
+		run:   statsCommand,
+	}
+}
+
+func statsCommand(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	initFile := flags.String("init", "", "init_net.pb holding the model weights")
+	asJSON := flags.Bool("json", false, "output the report as JSON")
+	inputs := readInputShapes(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *initFile == "" {
+		return usageError("stats")
+	}
+
+	net, err := readNetDef(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	init, err := readNetDef(*initFile)
+	if err != nil {
+		return err
+	}
+
+	stats, err := caffe2.AnalyzeNet(net, init, inputs)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return errors.Wrap(enc.Encode(stats), "unable to encode the report")
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"#", "Name", "Type", "Output Shape", "MACs", "FLOPs", "Params", "Param Bytes", "Activation Bytes"})
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	for _, op := range stats.Operators {
+		name := op.Name
+		if name == "" && len(op.Outputs) != 0 {
+			name = op.Outputs[0]
+		}
+		shape := "?"
+		if len(op.OutputShapes) != 0 && op.OutputShapes[0] != nil {
+			shape = fmt.Sprint(op.OutputShapes[0])
+		}
+		flops := strconv.FormatInt(op.FLOPs, 10)
+		if op.Unknown {
+			flops += "?"
+		}
+		table.Append([]string{
+			strconv.Itoa(op.Index),
+			name,
+			op.Type,
+			shape,
+			strconv.FormatInt(op.MultiplyAdds, 10),
+			flops,
+			strconv.FormatInt(op.Parameters, 10),
+			strconv.FormatInt(op.ParameterBytes, 10),
+			strconv.FormatInt(op.ActivationBytes, 10),
+		})
+	}
+	table.SetFooter([]string{"", "", "", "Total",
+		strconv.FormatInt(stats.TotalMultiplyAdds, 10),
+		strconv.FormatInt(stats.TotalFLOPs, 10),
+		strconv.FormatInt(stats.TotalParameters, 10),
+		strconv.FormatInt(stats.TotalParameterBytes, 10),
+		strconv.FormatInt(stats.TotalActivationBytes, 10),
+	})
+	table.Render()
+	return nil
+}
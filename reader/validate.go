@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/rai-project/caffe2"
+)
+
+func init() {
+	commands["validate"] = command{
+		usage: "validate [-init init_net.pb] <predict_net.pb>",
+		run:   validateCommand,
+	}
+}
+
+func validateCommand(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	initFile := flags.String("init", "", "init_net.pb holding the model weights")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError("validate")
+	}
+
+	net, err := readNetDef(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var init *caffe2.NetDef
+	if *initFile != "" {
+		init, err = readNetDef(*initFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	issues := caffe2.ValidateNet(net, init)
+	for _, issue := range issues.Warnings() {
+		fmt.Println(issue)
+	}
+	if err := issues.Err(); err != nil {
+		return err
+	}
+	fmt.Println("ok")
+	return nil
+}
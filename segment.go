@@ -0,0 +1,227 @@
+package caffe2
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// AssembleSegments stitches a tensor stored in chunks back together. Every
+// part must have the same name, data type and (full) dimensions and carry a
+// segment giving the [begin, end) element range of the flattened tensor it
+// holds. Overlapping segments and gaps are reported as errors.
+func AssembleSegments(parts []*TensorProto) (*TensorProto, error) {
+	if len(parts) == 0 {
+		return nil, errors.New("no tensor segments to assemble")
+	}
+
+	first := parts[0]
+	name := first.GetName()
+	size := dimsProduct(first.GetDims())
+	if size < 0 {
+		return nil, errors.Errorf("tensor %s has invalid dimensions %v", name, first.GetDims())
+	}
+
+	sorted := make([]*TensorProto, len(parts))
+	copy(sorted, parts)
+	for _, part := range sorted {
+		if part.GetName() != name {
+			return nil, errors.Errorf("cannot assemble segments of tensors %s and %s", name, part.GetName())
+		}
+		if part.GetDataType() != first.GetDataType() {
+			return nil, errors.Errorf("segments of tensor %s have different data types %v and %v", name, first.GetDataType(), part.GetDataType())
+		}
+		if !equalDims(part.GetDims(), first.GetDims()) {
+			return nil, errors.Errorf("segments of tensor %s have different dimensions %v and %v", name, first.GetDims(), part.GetDims())
+		}
+		begin, end := segmentRange(part, size)
+		if begin < 0 || end < begin || end > size {
+			return nil, errors.Errorf("segment [%d, %d) of tensor %s is out of the [0, %d) range", begin, end, name, size)
+		}
+		if n := tensorStorageLen(part); n != end-begin {
+			return nil, errors.Errorf("segment [%d, %d) of tensor %s holds %d elements", begin, end, name, n)
+		}
+	}
+	sort.SliceStable(sorted, func(ii, jj int) bool {
+		bi, _ := segmentRange(sorted[ii], size)
+		bj, _ := segmentRange(sorted[jj], size)
+		return bi < bj
+	})
+
+	res := &TensorProto{
+		Name:         name,
+		Dims:         append([]int64{}, first.GetDims()...),
+		DataType:     first.GetDataType().Enum(),
+		DeviceDetail: first.GetDeviceDetail(),
+	}
+	offset := int64(0)
+	for _, part := range sorted {
+		begin, end := segmentRange(part, size)
+		switch {
+		case begin < offset:
+			return nil, errors.Errorf("segment [%d, %d) of tensor %s overlaps the previous segment ending at %d", begin, end, name, offset)
+		case begin > offset:
+			return nil, errors.Errorf("tensor %s is missing the elements [%d, %d)", name, offset, begin)
+		}
+		appendTensorStorage(res, part)
+		offset = end
+	}
+	if offset != size {
+		return nil, errors.Errorf("tensor %s is missing the elements [%d, %d)", name, offset, size)
+	}
+	return res, nil
+}
+
+// AssembleTensorProtos groups the segmented tensors by name and assembles
+// them, tensors without segments are kept as is. The order of first
+// appearance is preserved.
+func AssembleTensorProtos(protos *TensorProtos) (*TensorProtos, error) {
+	var names []string
+	groups := map[string][]*TensorProto{}
+	for _, proto := range protos.GetProtos() {
+		name := proto.GetName()
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], proto)
+	}
+
+	res := &TensorProtos{}
+	for _, name := range names {
+		group := groups[name]
+		if len(group) == 1 && group[0].GetSegment() == nil {
+			res.Protos = append(res.Protos, group[0])
+			continue
+		}
+		tensor, err := AssembleSegments(group)
+		if err != nil {
+			return nil, err
+		}
+		res.Protos = append(res.Protos, tensor)
+	}
+	return res, nil
+}
+
+// SplitTensor splits a tensor into chunks of at most segmentSize elements,
+// each carrying its segment. A tensor that fits in one chunk is returned
+// unchanged.
+func SplitTensor(tensor *TensorProto, segmentSize int64) ([]*TensorProto, error) {
+	if segmentSize <= 0 {
+		return nil, errors.Errorf("invalid segment size %d", segmentSize)
+	}
+	if tensor.GetSegment() != nil {
+		return nil, errors.Errorf("tensor %s is already a segment", tensor.GetName())
+	}
+	size := tensorStorageLen(tensor)
+	if expected := dimsProduct(tensor.GetDims()); size != expected {
+		return nil, errors.Errorf("tensor %s has %d elements but its dimensions %v need %d", tensor.GetName(), size, tensor.GetDims(), expected)
+	}
+	if size <= segmentSize {
+		return []*TensorProto{tensor}, nil
+	}
+
+	var parts []*TensorProto
+	for begin := int64(0); begin < size; begin += segmentSize {
+		end := begin + segmentSize
+		if end > size {
+			end = size
+		}
+		part := &TensorProto{
+			Name:         tensor.GetName(),
+			Dims:         tensor.GetDims(),
+			DataType:     tensor.GetDataType().Enum(),
+			DeviceDetail: tensor.GetDeviceDetail(),
+			Segment: &TensorProto_Segment{
+				Begin: begin,
+				End:   end,
+			},
+		}
+		sliceTensorStorage(part, tensor, begin, end)
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// SplitTensorProtos splits every tensor larger than segmentSize elements.
+func SplitTensorProtos(protos *TensorProtos, segmentSize int64) (*TensorProtos, error) {
+	res := &TensorProtos{}
+	for _, proto := range protos.GetProtos() {
+		parts, err := SplitTensor(proto, segmentSize)
+		if err != nil {
+			return nil, err
+		}
+		res.Protos = append(res.Protos, parts...)
+	}
+	return res, nil
+}
+
+func segmentRange(part *TensorProto, size int64) (int64, int64) {
+	if segment := part.GetSegment(); segment != nil {
+		return segment.GetBegin(), segment.GetEnd()
+	}
+	return 0, size
+}
+
+func equalDims(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for ii := range a {
+		if a[ii] != b[ii] {
+			return false
+		}
+	}
+	return true
+}
+
+// tensorStorageLen returns the number of elements held by the storage field
+// used for the tensor data type.
+func tensorStorageLen(t *TensorProto) int64 {
+	switch t.GetDataType() {
+	case TensorProto_FLOAT:
+		return int64(len(t.GetFloatData()))
+	case TensorProto_DOUBLE:
+		return int64(len(t.GetDoubleData()))
+	case TensorProto_INT64:
+		return int64(len(t.GetInt64Data()))
+	case TensorProto_BYTE:
+		return int64(len(t.GetByteData()))
+	case TensorProto_STRING:
+		return int64(len(t.GetStringData()))
+	}
+	return int64(len(t.GetInt32Data()))
+}
+
+func appendTensorStorage(dst, src *TensorProto) {
+	switch src.GetDataType() {
+	case TensorProto_FLOAT:
+		dst.FloatData = append(dst.FloatData, src.GetFloatData()...)
+	case TensorProto_DOUBLE:
+		dst.DoubleData = append(dst.DoubleData, src.GetDoubleData()...)
+	case TensorProto_INT64:
+		dst.Int64Data = append(dst.Int64Data, src.GetInt64Data()...)
+	case TensorProto_BYTE:
+		dst.ByteData = append(dst.ByteData, src.GetByteData()...)
+	case TensorProto_STRING:
+		dst.StringData = append(dst.StringData, src.GetStringData()...)
+	default:
+		dst.Int32Data = append(dst.Int32Data, src.GetInt32Data()...)
+	}
+}
+
+func sliceTensorStorage(dst, src *TensorProto, begin, end int64) {
+	switch src.GetDataType() {
+	case TensorProto_FLOAT:
+		dst.FloatData = src.GetFloatData()[begin:end]
+	case TensorProto_DOUBLE:
+		dst.DoubleData = src.GetDoubleData()[begin:end]
+	case TensorProto_INT64:
+		dst.Int64Data = src.GetInt64Data()[begin:end]
+	case TensorProto_BYTE:
+		dst.ByteData = src.GetByteData()[begin:end]
+	case TensorProto_STRING:
+		dst.StringData = src.GetStringData()[begin:end]
+	default:
+		dst.Int32Data = src.GetInt32Data()[begin:end]
+	}
+}
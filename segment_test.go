@@ -0,0 +1,104 @@
+package caffe2
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitAssembleSegments(t *testing.T) {
+	for _, data := range []interface{}{
+		[]float32{0, 1, 2, 3, 4, 5, 6},
+		[]int64{0, 1, 2, 3, 4, 5, 6},
+		[]uint8{0, 1, 2, 3, 4, 5, 6},
+		[]string{"a", "b", "c", "d", "e", "f", "g"},
+	} {
+		tensor, err := NewTensorProto("t", []int64{7}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts, err := SplitTensor(tensor, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ranges [][2]int64
+		for _, part := range parts {
+			ranges = append(ranges, [2]int64{part.GetSegment().GetBegin(), part.GetSegment().GetEnd()})
+		}
+		if want := [][2]int64{{0, 3}, {3, 6}, {6, 7}}; !reflect.DeepEqual(ranges, want) {
+			t.Errorf("segments of %T = %v, want %v", data, ranges, want)
+		}
+
+		// the order of the segments does not matter
+		parts[0], parts[2] = parts[2], parts[0]
+		assembled, err := AssembleSegments(parts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(assembled, tensor) {
+			t.Errorf("assembled %T = %v, want %v", data, assembled, tensor)
+		}
+	}
+}
+
+func TestSplitTensorSmall(t *testing.T) {
+	tensor, _ := NewTensorProto("t", []int64{2}, []float32{1, 2})
+	parts, err := SplitTensor(tensor, 2)
+	if err != nil || len(parts) != 1 || parts[0] != tensor {
+		t.Errorf("SplitTensor of a tensor fitting one segment = %v, %v", parts, err)
+	}
+	if _, err := SplitTensor(tensor, 0); err == nil {
+		t.Error("SplitTensor with a segment size of 0 succeeded")
+	}
+}
+
+func TestAssembleSegmentsErrors(t *testing.T) {
+	segment := func(name string, begin, end int64) *TensorProto {
+		return &TensorProto{
+			Name:      name,
+			Dims:      []int64{4},
+			DataType:  TensorProto_FLOAT.Enum(),
+			FloatData: make([]float32, end-begin),
+			Segment:   &TensorProto_Segment{Begin: begin, End: end},
+		}
+	}
+	tests := []struct {
+		name  string
+		parts []*TensorProto
+		err   string
+	}{
+		{"empty", nil, "no tensor segments"},
+		{"names", []*TensorProto{segment("a", 0, 2), segment("b", 2, 4)}, "tensors a and b"},
+		{"gap", []*TensorProto{segment("a", 0, 1), segment("a", 2, 4)}, "missing the elements [1, 2)"},
+		{"end", []*TensorProto{segment("a", 0, 3)}, "missing the elements [3, 4)"},
+		{"overlap", []*TensorProto{segment("a", 0, 3), segment("a", 2, 4)}, "overlaps"},
+		{"range", []*TensorProto{segment("a", 0, 5)}, "out of the [0, 4) range"},
+	}
+	for _, test := range tests {
+		_, err := AssembleSegments(test.parts)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: AssembleSegments error = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestSplitAssembleTensorProtos(t *testing.T) {
+	big, _ := NewTensorProto("big", []int64{2, 3}, []int32{1, 2, 3, 4, 5, 6})
+	small, _ := NewTensorProto("small", []int64{1}, []float64{1})
+	protos := &TensorProtos{Protos: []*TensorProto{big, small}}
+
+	split, err := SplitTensorProtos(protos, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(split.GetProtos()) != 3 {
+		t.Fatalf("SplitTensorProtos = %d tensors, want 3", len(split.GetProtos()))
+	}
+	assembled, err := AssembleTensorProtos(split)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(assembled, protos) {
+		t.Errorf("AssembleTensorProtos = %v, want %v", assembled, protos)
+	}
+}
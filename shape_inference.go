@@ -0,0 +1,367 @@
+package caffe2
+
+import (
+	"github.com/pkg/errors"
+)
+
+// UnknownDim marks a dimension whose size could not be inferred.
+const UnknownDim int64 = -1
+
+type shapeInferenceFunc func(op *OperatorDef, inputs [][]int64) ([][]int64, error)
+
+var shapeInferenceFuncs = map[string]shapeInferenceFunc{
+	"Conv":        inferConvShape,
+	"MaxPool":     inferPoolShape,
+	"AveragePool": inferPoolShape,
+	"FC":          inferFCShape,
+	"Relu":        inferIdentityShape,
+	"Dropout":     inferIdentityShape,
+	"LRN":         inferIdentityShape,
+	"Softmax":     inferIdentityShape,
+	"Sum":         inferIdentityShape,
+	"SpatialBN":   inferSpatialBNShape,
+	"Concat":      inferConcatShape,
+	"Flatten":     inferFlattenShape,
+	"Reshape":     inferReshapeShape,
+}
+
+// InferShapes computes the shape of every blob in the net by walking its
+// operators in order. The shapes of the external inputs (including the
+// weights, see InitNetShapes) are given in inputShapes. Dimensions that cannot
+// be inferred are reported through the unknown_dims field of the resulting
+// TensorShape and blobs produced by unsupported operators are marked with
+// unknown_shape.
+func InferShapes(net *NetDef, inputShapes map[string][]int64) (*TensorShapes, error) {
+	shapes := map[string][]int64{}
+	unknownShape := map[string]bool{}
+	var order []string
+	set := func(name string, dims []int64, unknown bool) {
+		if _, ok := shapes[name]; !ok {
+			order = append(order, name)
+		}
+		shapes[name] = dims
+		unknownShape[name] = unknown
+	}
+	shapeOf := func(name string) []int64 {
+		if unknownShape[name] {
+			return nil
+		}
+		return shapes[name]
+	}
+
+	for _, input := range net.GetExternalInput() {
+		dims, ok := inputShapes[input]
+		set(input, append([]int64{}, dims...), !ok)
+	}
+
+	for _, op := range net.GetOp() {
+		infer, ok := shapeInferenceFuncs[op.GetType()]
+		inputs := make([][]int64, len(op.GetInput()))
+		known := true
+		for ii, input := range op.GetInput() {
+			inputs[ii] = shapeOf(input)
+			if inputs[ii] == nil {
+				known = false
+			}
+		}
+		if ok && len(inputs) == 0 {
+			// every operator with a shape inference function takes an input
+			return nil, errors.Errorf("the %s operator %s has no input", op.GetType(), op.GetName())
+		}
+		if !ok || (!known && !partialShapeInference[op.GetType()]) {
+			for _, output := range op.GetOutput() {
+				set(output, nil, true)
+			}
+			continue
+		}
+		outputs, err := infer(op, inputs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to infer the shape of the %s operator %s", op.GetType(), op.GetName())
+		}
+		for ii, output := range op.GetOutput() {
+			if ii >= len(outputs) || outputs[ii] == nil {
+				set(output, nil, true)
+				continue
+			}
+			set(output, outputs[ii], false)
+		}
+	}
+
+	res := &TensorShapes{}
+	for _, name := range order {
+		shape := &TensorShape{
+			Name: name,
+			Dims: shapes[name],
+		}
+		if unknownShape[name] {
+			unknown := true
+			shape.UnknownShape = &unknown
+		}
+		for ii, dim := range shape.Dims {
+			if dim < 0 {
+				shape.UnknownDims = append(shape.UnknownDims, int32(ii))
+			}
+		}
+		res.Shapes = append(res.Shapes, shape)
+	}
+	return res, nil
+}
+
+// InitNetShapes returns the shapes of the blobs filled by an init net, as
+// given by the shape (or values) argument of its fill operators.
+func InitNetShapes(init *NetDef) map[string][]int64 {
+	shapes := map[string][]int64{}
+	for name, param := range InitNetParameters(init) {
+		shapes[name] = append([]int64{}, param.Shape...)
+	}
+	return shapes
+}
+
+// Lookup returns the shape of the named blob or nil.
+func (m *TensorShapes) Lookup(name string) *TensorShape {
+	for _, shape := range m.GetShapes() {
+		if shape.GetName() == name {
+			return shape
+		}
+	}
+	return nil
+}
+
+// partialShapeInference lists the operators that can infer an output shape
+// even if the shape of some of their inputs (e.g. the weights) is unknown.
+var partialShapeInference = map[string]bool{
+	"Conv": true,
+	"FC":   true,
+}
+
+func inferIdentityShape(op *OperatorDef, inputs [][]int64) ([][]int64, error) {
+	outputs := make([][]int64, len(op.GetOutput()))
+	for ii := range outputs {
+		outputs[ii] = copyDims(inputs[0])
+	}
+	return outputs, nil
+}
+
+func inferSpatialBNShape(op *OperatorDef, inputs [][]int64) ([][]int64, error) {
+	x := inputs[0]
+	channels := UnknownDim
+	if len(x) >= 2 {
+		if op.GetArgString("order", "NCHW") == "NHWC" {
+			channels = x[len(x)-1]
+		} else {
+			channels = x[1]
+		}
+	}
+	// Y followed by the running and saved mean/var in training mode
+	outputs := make([][]int64, len(op.GetOutput()))
+	outputs[0] = copyDims(x)
+	for ii := 1; ii < len(outputs); ii++ {
+		outputs[ii] = []int64{channels}
+	}
+	return outputs, nil
+}
+
+func inferConvShape(op *OperatorDef, inputs [][]int64) ([][]int64, error) {
+	if inputs[0] == nil {
+		return nil, nil
+	}
+	var filter []int64
+	if len(inputs) > 1 {
+		filter = inputs[1]
+	}
+	if len(inputs[0]) != 4 {
+		return nil, errors.Errorf("expecting a 4D input, got %v", inputs[0])
+	}
+	args, err := ParseConvArgs(op, filter)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := inferWindowShape(args, inputs[0])
+	if err != nil || outputs[0] == nil {
+		return outputs, err
+	}
+	channels := UnknownDim
+	if len(filter) > 0 {
+		channels = filter[0]
+	}
+	if op.GetArgString("order", "NCHW") == "NHWC" {
+		outputs[0][3] = channels
+	} else {
+		outputs[0][1] = channels
+	}
+	return outputs, nil
+}
+
+func inferPoolShape(op *OperatorDef, inputs [][]int64) ([][]int64, error) {
+	if len(inputs[0]) != 4 {
+		return nil, errors.Errorf("expecting a 4D input, got %v", inputs[0])
+	}
+	args, err := ParseConvPoolArgs(op)
+	if err != nil {
+		return nil, err
+	}
+	return inferWindowShape(args, inputs[0])
+}
+
+func inferWindowShape(args *ConvPoolArgs, x []int64) ([][]int64, error) {
+	out := copyDims(x)
+	spatial := x[2:4]
+	if args.Order == "NHWC" {
+		spatial = x[1:3]
+	}
+	if spatial[0] < 0 || spatial[1] < 0 {
+		return [][]int64{out}, nil
+	}
+	size, _, err := args.OutputSize(spatial)
+	if err != nil {
+		return nil, err
+	}
+	if args.Order == "NHWC" {
+		copy(out[1:3], size)
+	} else {
+		copy(out[2:4], size)
+	}
+	return [][]int64{out}, nil
+}
+
+func inferFCShape(op *OperatorDef, inputs [][]int64) ([][]int64, error) {
+	x := inputs[0]
+	if x == nil {
+		return nil, nil
+	}
+	axis := canonicalAxis(op.GetArgInt("axis", 1), len(x))
+	if axis < 0 || axis > len(x) {
+		return nil, errors.Errorf("invalid axis %d for an input of rank %d", axis, len(x))
+	}
+	out := copyDims(x[:axis])
+	var w []int64
+	if len(inputs) > 1 {
+		w = inputs[1]
+	}
+	if w == nil {
+		return [][]int64{append(out, UnknownDim)}, nil
+	}
+	axisW := canonicalAxis(op.GetArgInt("axis_w", 1), len(w))
+	if axisW < 0 || axisW > len(w) {
+		return nil, errors.Errorf("invalid axis_w %d for weights of rank %d", op.GetArgInt("axis_w", 1), len(w))
+	}
+	// the outputs are the product of the weights dimensions before axis_w
+	return [][]int64{append(out, dimsProduct(w[:axisW]))}, nil
+}
+
+func inferConcatShape(op *OperatorDef, inputs [][]int64) ([][]int64, error) {
+	x := inputs[0]
+	rank := len(x)
+	addAxis := op.GetArgInt("add_axis", 0) != 0
+	if addAxis {
+		rank++
+	}
+	axis := 1
+	if op.GetArgString("order", "NCHW") == "NHWC" {
+		axis = 3
+	}
+	axis = canonicalAxis(op.GetArgInt("axis", int64(axis)), rank)
+	if axis < 0 || axis >= rank {
+		return nil, errors.Errorf("invalid axis %d for an input of rank %d", axis, len(x))
+	}
+
+	var out []int64
+	if addAxis {
+		out = append(copyDims(x[:axis]), int64(len(inputs)))
+		out = append(out, x[axis:]...)
+	} else {
+		out = copyDims(x)
+		for _, input := range inputs[1:] {
+			if len(input) != len(x) {
+				return nil, errors.Errorf("cannot concatenate inputs of shape %v and %v", x, input)
+			}
+			if out[axis] < 0 || input[axis] < 0 {
+				out[axis] = UnknownDim
+				continue
+			}
+			out[axis] += input[axis]
+		}
+	}
+	return [][]int64{out, {int64(len(inputs))}}, nil
+}
+
+func inferFlattenShape(op *OperatorDef, inputs [][]int64) ([][]int64, error) {
+	x := inputs[0]
+	axis := canonicalAxis(op.GetArgInt("axis", 1), len(x))
+	if axis < 0 || axis > len(x) {
+		return nil, errors.Errorf("invalid axis %d for an input of rank %d", axis, len(x))
+	}
+	return [][]int64{{dimsProduct(x[:axis]), dimsProduct(x[axis:])}}, nil
+}
+
+func inferReshapeShape(op *OperatorDef, inputs [][]int64) ([][]int64, error) {
+	x := inputs[0]
+	oldShape := []int64{int64(len(x))}
+	if !op.HasArgument("shape") {
+		// the new shape is given as a tensor and only known at run time
+		return [][]int64{nil, oldShape}, nil
+	}
+	shape := op.GetArgInts("shape")
+	out := make([]int64, len(shape))
+	inferred := -1
+	for ii, dim := range shape {
+		switch {
+		case dim == 0:
+			if ii >= len(x) {
+				return nil, errors.Errorf("dimension %d of the new shape copies a missing input dimension", ii)
+			}
+			out[ii] = x[ii]
+		case dim == -1:
+			if inferred != -1 {
+				return nil, errors.New("the new shape has more than one -1 dimension")
+			}
+			inferred = ii
+		case dim < 0:
+			return nil, errors.Errorf("invalid dimension %d in the new shape", dim)
+		default:
+			out[ii] = dim
+		}
+	}
+	if inferred != -1 {
+		total := dimsProduct(x)
+		rest := dimsProduct(append(append([]int64{}, out[:inferred]...), out[inferred+1:]...))
+		switch {
+		case total < 0 || rest < 0:
+			out[inferred] = UnknownDim
+		case rest == 0 || total%rest != 0:
+			return nil, errors.Errorf("cannot reshape %v into %v", x, shape)
+		default:
+			out[inferred] = total / rest
+		}
+	} else if total, size := dimsProduct(x), dimsProduct(out); total >= 0 && size >= 0 && total != size {
+		return nil, errors.Errorf("cannot reshape %v into %v", x, shape)
+	}
+	return [][]int64{out, oldShape}, nil
+}
+
+func canonicalAxis(axis int64, rank int) int {
+	if axis < 0 {
+		return int(axis) + rank
+	}
+	return int(axis)
+}
+
+func copyDims(dims []int64) []int64 {
+	if dims == nil {
+		return nil
+	}
+	return append([]int64{}, dims...)
+}
+
+// dimsProduct returns the number of elements of the given dimensions or
+// UnknownDim if any of them is unknown.
+func dimsProduct(dims []int64) int64 {
+	res := int64(1)
+	for _, dim := range dims {
+		if dim < 0 {
+			return UnknownDim
+		}
+		res *= dim
+	}
+	return res
+}
@@ -0,0 +1,249 @@
+package caffe2
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConvPoolOutputSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []*Argument
+		in       []int64
+		wantOut  []int64
+		wantPads []int64
+	}{
+		{"kernel", []*Argument{intArg("kernel", 3)}, []int64{7, 7}, []int64{5, 5}, []int64{0, 0, 0, 0}},
+		{"pad", []*Argument{intArg("kernel", 3), intArg("pad", 1)}, []int64{7, 7}, []int64{7, 7}, []int64{1, 1, 1, 1}},
+		{"stride", []*Argument{intArg("kernel", 3), intArg("stride", 2)}, []int64{7, 8}, []int64{3, 3}, []int64{0, 0, 0, 0}},
+		{"kernel hw", []*Argument{intArg("kernel_h", 3), intArg("kernel_w", 1)}, []int64{7, 7}, []int64{5, 7}, []int64{0, 0, 0, 0}},
+		{"pads", []*Argument{intArg("kernel", 3), intsArg("pads", 1, 0, 1, 0)}, []int64{7, 7}, []int64{7, 5}, []int64{1, 0, 1, 0}},
+		{"dilation", []*Argument{intArg("kernel", 3), intArg("dilation", 2)}, []int64{7, 7}, []int64{3, 3}, []int64{0, 0, 0, 0}},
+		{"valid", []*Argument{intArg("kernel", 3), intArg("stride", 2), intArg("legacy_pad", int64(LegacyPadding_VALID))}, []int64{7, 7}, []int64{3, 3}, []int64{0, 0, 0, 0}},
+		{"same", []*Argument{intArg("kernel", 3), intArg("stride", 2), intArg("legacy_pad", int64(LegacyPadding_SAME))}, []int64{7, 7}, []int64{4, 4}, []int64{1, 1, 1, 1}},
+		// caffe rounds up: ceil((14 - 3) / 2) + 1 = 7 instead of 6
+		{"caffe legacy pooling", []*Argument{intArg("kernel", 3), intArg("stride", 2), intArg("legacy_pad", int64(LegacyPadding_CAFFE_LEGACY_POOLING))}, []int64{14, 13}, []int64{7, 6}, []int64{0, 0, 2, 0}},
+		{"global pooling", []*Argument{intArg("global_pooling", 1)}, []int64{7, 5}, []int64{1, 1}, []int64{0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		args, err := ParseConvPoolArgs(newOp("MaxPool", nil, nil, test.args...))
+		if err != nil {
+			t.Errorf("%s: ParseConvPoolArgs failed: %v", test.name, err)
+			continue
+		}
+		out, pads, err := args.OutputSize(test.in)
+		if err != nil {
+			t.Errorf("%s: OutputSize failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, test.wantOut) || !reflect.DeepEqual(pads, test.wantPads) {
+			t.Errorf("%s: OutputSize(%v) = %v, pads %v, want %v, pads %v", test.name, test.in, out, pads, test.wantOut, test.wantPads)
+		}
+	}
+}
+
+func TestParseConvPoolArgsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []*Argument
+	}{
+		{"no kernel", nil},
+		{"zero stride", []*Argument{intArg("kernel", 3), intArg("stride", 0)}},
+		{"bad kernels", []*Argument{intsArg("kernels", 3)}},
+		{"bad order", []*Argument{intArg("kernel", 3), stringArg("order", "CHWN")}},
+		{"legacy pad and pads", []*Argument{intArg("kernel", 3), intArg("pad", 1), intArg("legacy_pad", int64(LegacyPadding_SAME))}},
+	}
+	for _, test := range tests {
+		if _, err := ParseConvPoolArgs(newOp("Conv", nil, nil, test.args...)); err == nil {
+			t.Errorf("%s: ParseConvPoolArgs succeeded", test.name)
+		}
+	}
+}
+
+func inferShapes(t *testing.T, net *NetDef, inputShapes map[string][]int64) *TensorShapes {
+	shapes, err := InferShapes(net, inputShapes)
+	if err != nil {
+		t.Fatalf("InferShapes failed: %v", err)
+	}
+	return shapes
+}
+
+func TestInferShapes(t *testing.T) {
+	inputShapes := InitNetShapes(testInitNet())
+	inputShapes["data"] = []int64{2, 3, 8, 8}
+	shapes := inferShapes(t, testNet(), inputShapes)
+
+	for name, want := range map[string][]int64{
+		"data":   {2, 3, 8, 8},
+		"conv_w": {4, 3, 3, 3},
+		"conv":   {2, 4, 8, 8},
+		"pool":   {2, 4, 4, 4},
+		"fc":     {2, 10},
+		"prob":   {2, 10},
+	} {
+		shape := shapes.Lookup(name)
+		if shape == nil {
+			t.Errorf("no shape for %s", name)
+			continue
+		}
+		if !reflect.DeepEqual(shape.GetDims(), want) || shape.GetUnknownShape() || len(shape.GetUnknownDims()) != 0 {
+			t.Errorf("shape of %s = %v, want %v", name, shape, want)
+		}
+	}
+	if shapes.Lookup("missing") != nil {
+		t.Error("Lookup of a missing blob is not nil")
+	}
+}
+
+func TestInferShapesUnknown(t *testing.T) {
+	net := testNet()
+	net.Op = append(net.Op, newOp("Custom", []string{"prob"}, []string{"custom"}))
+	net.ExternalOutput = []string{"custom"}
+	// the batch size is unknown and the weights are not given
+	shapes := inferShapes(t, net, map[string][]int64{"data": {-1, 3, 8, 8}})
+
+	pool := shapes.Lookup("pool")
+	if !reflect.DeepEqual(pool.GetDims(), []int64{-1, -1, 4, 4}) || !reflect.DeepEqual(pool.GetUnknownDims(), []int32{0, 1}) {
+		t.Errorf("shape of pool = %v, want [-1 -1 4 4] with unknown dims [0 1]", pool)
+	}
+	fc := shapes.Lookup("fc")
+	if !reflect.DeepEqual(fc.GetDims(), []int64{-1, -1}) {
+		t.Errorf("shape of fc = %v, want [-1 -1]", fc)
+	}
+	if custom := shapes.Lookup("custom"); !custom.GetUnknownShape() {
+		t.Errorf("shape of the output of an unsupported operator = %v, want an unknown shape", custom)
+	}
+	if w := shapes.Lookup("conv_w"); !w.GetUnknownShape() {
+		t.Errorf("shape of the missing input conv_w = %v, want an unknown shape", w)
+	}
+}
+
+func TestInferShapesOperators(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     *OperatorDef
+		inputs map[string][]int64
+		want   map[string][]int64
+	}{
+		{
+			"concat",
+			newOp("Concat", []string{"a", "b"}, []string{"y", "split"}),
+			map[string][]int64{"a": {1, 2, 4, 4}, "b": {1, 3, 4, 4}},
+			map[string][]int64{"y": {1, 5, 4, 4}, "split": {2}},
+		},
+		{
+			"concat add axis",
+			newOp("Concat", []string{"a", "b"}, []string{"y"}, intArg("axis", 0), intArg("add_axis", 1)),
+			map[string][]int64{"a": {2, 3}, "b": {2, 3}},
+			map[string][]int64{"y": {2, 2, 3}},
+		},
+		{
+			"sum",
+			newOp("Sum", []string{"a", "b"}, []string{"y"}),
+			map[string][]int64{"a": {2, 3}, "b": {2, 3}},
+			map[string][]int64{"y": {2, 3}},
+		},
+		{
+			"spatial bn",
+			newOp("SpatialBN", []string{"x", "scale", "bias", "mean", "var"}, []string{"y", "rm", "rv"}),
+			map[string][]int64{"x": {1, 3, 4, 4}, "scale": {3}, "bias": {3}, "mean": {3}, "var": {3}},
+			map[string][]int64{"y": {1, 3, 4, 4}, "rm": {3}, "rv": {3}},
+		},
+		{
+			"flatten",
+			newOp("Flatten", []string{"x"}, []string{"y"}),
+			map[string][]int64{"x": {2, 3, 4, 5}},
+			map[string][]int64{"y": {2, 60}},
+		},
+		{
+			"reshape",
+			newOp("Reshape", []string{"x"}, []string{"y", "old"}, intsArg("shape", 0, -1, 5)),
+			map[string][]int64{"x": {2, 3, 4, 5}},
+			map[string][]int64{"y": {2, 12, 5}, "old": {4}},
+		},
+		{
+			"nhwc conv",
+			newOp("Conv", []string{"x", "w"}, []string{"y"}, intArg("kernel", 3), intArg("stride", 2), stringArg("order", "NHWC")),
+			map[string][]int64{"x": {1, 9, 9, 3}, "w": {16, 3, 3, 3}},
+			map[string][]int64{"y": {1, 4, 4, 16}},
+		},
+		{
+			"fc negative axis_w",
+			newOp("FC", []string{"x", "w", "b"}, []string{"y"}, intArg("axis_w", -1)),
+			map[string][]int64{"x": {2, 3, 4}, "w": {10, 12}, "b": {10}},
+			map[string][]int64{"y": {2, 10}},
+		},
+		{
+			"fc axis_w 2",
+			newOp("FC", []string{"x", "w", "b"}, []string{"y"}, intArg("axis_w", 2)),
+			map[string][]int64{"x": {2, 3, 4}, "w": {5, 2, 12}, "b": {10}},
+			map[string][]int64{"y": {2, 10}},
+		},
+		{
+			"average pool",
+			newOp("AveragePool", []string{"x"}, []string{"y"}, intArg("global_pooling", 1)),
+			map[string][]int64{"x": {1, 8, 7, 7}},
+			map[string][]int64{"y": {1, 8, 1, 1}},
+		},
+	}
+	for _, test := range tests {
+		var inputs []string
+		for name := range test.inputs {
+			inputs = append(inputs, name)
+		}
+		net := &NetDef{ExternalInput: inputs, Op: []*OperatorDef{test.op}}
+		shapes, err := InferShapes(net, test.inputs)
+		if err != nil {
+			t.Errorf("%s: InferShapes failed: %v", test.name, err)
+			continue
+		}
+		for name, want := range test.want {
+			if got := shapes.Lookup(name).GetDims(); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: shape of %s = %v, want %v", test.name, name, got, want)
+			}
+		}
+	}
+}
+
+func TestInferShapesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     *OperatorDef
+		inputs map[string][]int64
+	}{
+		{"kernel too large", newOp("MaxPool", []string{"x"}, []string{"y"}, intArg("kernel", 5)), map[string][]int64{"x": {1, 1, 3, 3}}},
+		{"reshape size", newOp("Reshape", []string{"x"}, []string{"y"}, intsArg("shape", 7)), map[string][]int64{"x": {2, 3}}},
+		{"concat rank", newOp("Concat", []string{"a", "b"}, []string{"y"}), map[string][]int64{"a": {1, 2}, "b": {1, 2, 3}}},
+		{"fc axis_w", newOp("FC", []string{"x", "w", "b"}, []string{"y"}, intArg("axis_w", -3)), map[string][]int64{"x": {2, 12}, "w": {10, 12}, "b": {10}}},
+		{"fc axis_w rank", newOp("FC", []string{"x", "w", "b"}, []string{"y"}, intArg("axis_w", 3)), map[string][]int64{"x": {2, 12}, "w": {10, 12}, "b": {10}}},
+	}
+	for _, test := range tests {
+		var inputs []string
+		for name := range test.inputs {
+			inputs = append(inputs, name)
+		}
+		net := &NetDef{ExternalInput: inputs, Op: []*OperatorDef{test.op}}
+		if _, err := InferShapes(net, test.inputs); err == nil {
+			t.Errorf("%s: InferShapes succeeded", test.name)
+		}
+	}
+
+	for opType := range shapeInferenceFuncs {
+		net := &NetDef{Op: []*OperatorDef{newOp(opType, nil, []string{"y"})}}
+		if _, err := InferShapes(net, nil); err == nil || !strings.Contains(err.Error(), "has no input") {
+			t.Errorf("InferShapes of a %s without input = %v", opType, err)
+		}
+	}
+}
+
+func TestInferShapesConvKernelFromFilter(t *testing.T) {
+	net := testNet()
+	net.Op[0] = newOp("Conv", []string{"data", "conv_w", "conv_b"}, []string{"conv"}, intArg("pad", 1))
+	inputShapes := InitNetShapes(testInitNet())
+	inputShapes["data"] = []int64{2, 3, 8, 8}
+	shapes := inferShapes(t, net, inputShapes)
+	if conv := shapes.Lookup("conv"); !reflect.DeepEqual(conv.GetDims(), []int64{2, 4, 8, 8}) {
+		t.Errorf("shape of conv without kernel argument = %v, want [2 4 8 8]", conv)
+	}
+}
@@ -0,0 +1,323 @@
+package caffe2
+
+import (
+	"github.com/pkg/errors"
+)
+
+// OperatorStats holds the cost of a single operator of a net.
+type OperatorStats struct {
+	Index        int       `json:"index"`
+	Name         string    `json:"name,omitempty"`
+	Type         string    `json:"type"`
+	Outputs      []string  `json:"outputs"`
+	OutputShapes [][]int64 `json:"output_shapes"`
+	// MultiplyAdds is the number of multiply-accumulate operations.
+	MultiplyAdds int64 `json:"multiply_adds"`
+	// FLOPs counts every floating point operation, a multiply-add counts as two.
+	FLOPs           int64 `json:"flops"`
+	Parameters      int64 `json:"parameters"`
+	ParameterBytes  int64 `json:"parameter_bytes"`
+	ActivationBytes int64 `json:"activation_bytes"`
+	// Unknown is set when the cost could not be computed because of
+	// missing shapes or an unsupported operator.
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// NetStats holds the per operator and total cost of a net.
+type NetStats struct {
+	Name                 string          `json:"name"`
+	Operators            []OperatorStats `json:"operators"`
+	TotalMultiplyAdds    int64           `json:"total_multiply_adds"`
+	TotalFLOPs           int64           `json:"total_flops"`
+	TotalParameters      int64           `json:"total_parameters"`
+	TotalParameterBytes  int64           `json:"total_parameter_bytes"`
+	TotalActivationBytes int64           `json:"total_activation_bytes"`
+}
+
+// activationElementSize is the size of the float32 activations
+const activationElementSize = 4
+
+type operatorCostFunc func(op *OperatorDef, inputs, outputs [][]int64) (multiplyAdds int64, flops int64, err error)
+
+var operatorCostFuncs = map[string]operatorCostFunc{
+	"Conv":        convCost,
+	"FC":          fcCost,
+	"MaxPool":     poolCost,
+	"AveragePool": poolCost,
+	"Relu":        elementwiseCost(1),
+	"SpatialBN":   elementwiseCost(2),
+	"Softmax":     elementwiseCost(3),
+	"LRN":         lrnCost,
+	"Sum":         sumCost,
+	"Dropout":     elementwiseCost(0),
+	"Concat":      elementwiseCost(0),
+	"Flatten":     elementwiseCost(0),
+	"Reshape":     elementwiseCost(0),
+}
+
+// AnalyzeNet computes the multiply-adds, FLOPs, parameter and activation
+// sizes of every operator of the net. The parameter sizes are read from the
+// fill operators of the init net and inputShapes gives the shape of the data
+// inputs, e.g. {"data": {1, 3, 224, 224}}.
+func AnalyzeNet(net *NetDef, init *NetDef, inputShapes map[string][]int64) (*NetStats, error) {
+	params := InitNetParameters(init)
+
+	allShapes := InitNetShapes(init)
+	for name, dims := range inputShapes {
+		allShapes[name] = dims
+	}
+
+	shapes, err := perOperatorShapes(net, allShapes)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &NetStats{Name: net.GetName()}
+	counted := map[string]bool{}
+	for ii, op := range net.GetOp() {
+		opStats := OperatorStats{
+			Index:   ii,
+			Name:    op.GetName(),
+			Type:    op.GetType(),
+			Outputs: op.GetOutput(),
+		}
+
+		inputs := shapes[ii].inputs
+		outputs := shapes[ii].outputs
+		opStats.OutputShapes = outputs
+
+		for _, input := range op.GetInput() {
+			param, ok := params[input]
+			if !ok || counted[input] {
+				continue
+			}
+			// shared weights are only accounted for once
+			counted[input] = true
+			opStats.Parameters += param.Elements
+			opStats.ParameterBytes += param.Bytes
+		}
+
+		for _, output := range outputs {
+			elements := dimsProduct(output)
+			if output == nil || elements < 0 {
+				opStats.Unknown = true
+				continue
+			}
+			opStats.ActivationBytes += elements * activationElementSize
+		}
+
+		cost, ok := operatorCostFuncs[op.GetType()]
+		if !ok || !allDimsKnown(inputs) || !allDimsKnown(outputs) {
+			opStats.Unknown = true
+		} else {
+			opStats.MultiplyAdds, opStats.FLOPs, err = cost(op, inputs, outputs)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to compute the cost of the %s operator %s", op.GetType(), op.GetName())
+			}
+		}
+
+		stats.TotalMultiplyAdds += opStats.MultiplyAdds
+		stats.TotalFLOPs += opStats.FLOPs
+		stats.TotalParameters += opStats.Parameters
+		stats.TotalParameterBytes += opStats.ParameterBytes
+		stats.TotalActivationBytes += opStats.ActivationBytes
+		stats.Operators = append(stats.Operators, opStats)
+	}
+
+	return stats, nil
+}
+
+// ParameterInfo describes a blob filled by an init net.
+type ParameterInfo struct {
+	Name     string  `json:"name"`
+	Shape    []int64 `json:"shape"`
+	Elements int64   `json:"elements"`
+	Bytes    int64   `json:"bytes"`
+}
+
+// InitNetParameters returns the size of every blob produced by the fill
+// operators (GivenTensorFill, ConstantFill, ...) of an init net.
+func InitNetParameters(init *NetDef) map[string]ParameterInfo {
+	params := map[string]ParameterInfo{}
+	for _, op := range init.GetOp() {
+		elements := int64(-1)
+		shape := op.GetArgInts("shape")
+		if op.HasArgument("shape") {
+			elements = dimsProduct(shape)
+		} else if values := op.GetArgument("values"); values != nil {
+			elements = int64(len(values.GetFloats()) + len(values.GetInts()) + len(values.GetStrings()))
+			shape = []int64{elements}
+		}
+		if elements < 0 {
+			continue
+		}
+		size := fillElementSize(op)
+		for _, output := range op.GetOutput() {
+			params[output] = ParameterInfo{
+				Name:     output,
+				Shape:    shape,
+				Elements: elements,
+				Bytes:    elements * size,
+			}
+		}
+	}
+	return params
+}
+
+func fillElementSize(op *OperatorDef) int64 {
+	switch op.GetType() {
+	case "GivenTensorFill", "GivenTensorIntFill", "XavierFill", "MSRAFill", "GaussianFill", "UniformFill":
+		return 4
+	case "GivenTensorInt64Fill", "GivenTensorDoubleFill":
+		return 8
+	case "GivenTensorBoolFill", "GivenTensorByteStringToUInt8Fill":
+		return 1
+	case "GivenTensorInt16Fill":
+		return 2
+	}
+	if op.HasArgument("dtype") {
+		if size := DataTypeSize(TensorProto_DataType(op.GetArgInt("dtype", int64(TensorProto_FLOAT)))); size > 0 {
+			return size
+		}
+	}
+	return 4
+}
+
+// DataTypeSize returns the size in bytes of one element of the given type, or
+// 0 for variable sized types.
+func DataTypeSize(dtype TensorProto_DataType) int64 {
+	switch dtype {
+	case TensorProto_FLOAT, TensorProto_INT32:
+		return 4
+	case TensorProto_BYTE, TensorProto_BOOL, TensorProto_UINT8, TensorProto_INT8:
+		return 1
+	case TensorProto_UINT16, TensorProto_INT16, TensorProto_FLOAT16:
+		return 2
+	case TensorProto_INT64, TensorProto_DOUBLE:
+		return 8
+	}
+	return 0
+}
+
+type operatorShapes struct {
+	inputs  [][]int64
+	outputs [][]int64
+}
+
+// perOperatorShapes records the input and output shapes seen by each operator.
+// InferShapes only keeps the last shape of a blob, which is not enough once a
+// blob name is reused.
+func perOperatorShapes(net *NetDef, inputShapes map[string][]int64) ([]operatorShapes, error) {
+	res := make([]operatorShapes, len(net.GetOp()))
+	single := &NetDef{}
+	current := map[string][]int64{}
+	for name, dims := range inputShapes {
+		current[name] = dims
+	}
+	for ii, op := range net.GetOp() {
+		for _, input := range op.GetInput() {
+			res[ii].inputs = append(res[ii].inputs, current[input])
+		}
+		single.Op = []*OperatorDef{op}
+		single.ExternalInput = op.GetInput()
+		shapes, err := InferShapes(single, current)
+		if err != nil {
+			return nil, err
+		}
+		for _, output := range op.GetOutput() {
+			shape := shapes.Lookup(output)
+			if shape == nil || shape.GetUnknownShape() {
+				delete(current, output)
+				res[ii].outputs = append(res[ii].outputs, nil)
+				continue
+			}
+			current[output] = shape.GetDims()
+			res[ii].outputs = append(res[ii].outputs, shape.GetDims())
+		}
+	}
+	return res, nil
+}
+
+func allDimsKnown(shapes [][]int64) bool {
+	for _, dims := range shapes {
+		if dims == nil || dimsProduct(dims) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func convCost(op *OperatorDef, inputs, outputs [][]int64) (int64, int64, error) {
+	if len(inputs) < 2 {
+		return 0, 0, errors.New("missing filter input")
+	}
+	filter := inputs[1]
+	// the filter holds C_out x C_in/group x kernel
+	if len(filter) < 2 {
+		return 0, 0, errors.Errorf("invalid filter shape %v", filter)
+	}
+	perOutput := dimsProduct(filter[1:])
+	outElements := dimsProduct(outputs[0])
+	multiplyAdds := outElements * perOutput
+	flops := 2 * multiplyAdds
+	if len(inputs) > 2 {
+		flops += outElements
+	}
+	return multiplyAdds, flops, nil
+}
+
+func fcCost(op *OperatorDef, inputs, outputs [][]int64) (int64, int64, error) {
+	if len(inputs) < 2 {
+		return 0, 0, errors.New("missing weight input")
+	}
+	x := inputs[0]
+	axis := canonicalAxis(op.GetArgInt("axis", 1), len(x))
+	if axis < 0 || axis > len(x) {
+		return 0, 0, errors.Errorf("invalid axis %d for an input of rank %d", axis, len(x))
+	}
+	k := dimsProduct(x[axis:])
+	outElements := dimsProduct(outputs[0])
+	multiplyAdds := outElements * k
+	flops := 2 * multiplyAdds
+	if len(inputs) > 2 {
+		flops += outElements
+	}
+	return multiplyAdds, flops, nil
+}
+
+func poolCost(op *OperatorDef, inputs, outputs [][]int64) (int64, int64, error) {
+	args, err := ParseConvPoolArgs(op)
+	if err != nil {
+		return 0, 0, err
+	}
+	window := dimsProduct(args.Kernel)
+	if args.GlobalPooling {
+		x := inputs[0]
+		if len(x) < 3 {
+			return 0, 0, errors.Errorf("expecting a 4D input, got %v", x)
+		}
+		if args.Order == "NHWC" {
+			window = dimsProduct(x[1 : len(x)-1])
+		} else {
+			window = dimsProduct(x[2:])
+		}
+	}
+	return 0, dimsProduct(outputs[0]) * window, nil
+}
+
+func lrnCost(op *OperatorDef, inputs, outputs [][]int64) (int64, int64, error) {
+	size := op.GetArgInt("size", 5)
+	// square and accumulate over the window, then scale and power
+	elements := dimsProduct(outputs[0])
+	return elements * size, elements * (2*size + 3), nil
+}
+
+func sumCost(op *OperatorDef, inputs, outputs [][]int64) (int64, int64, error) {
+	return 0, int64(len(inputs)-1) * dimsProduct(outputs[0]), nil
+}
+
+func elementwiseCost(flopsPerElement int64) operatorCostFunc {
+	return func(op *OperatorDef, inputs, outputs [][]int64) (int64, int64, error) {
+		return 0, flopsPerElement * dimsProduct(outputs[0]), nil
+	}
+}
@@ -0,0 +1,117 @@
+package caffe2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeNet(t *testing.T) {
+	stats, err := AnalyzeNet(testNet(), testInitNet(), map[string][]int64{"data": {1, 3, 8, 8}})
+	if err != nil {
+		t.Fatalf("AnalyzeNet failed: %v", err)
+	}
+
+	want := []OperatorStats{
+		// 4x8x8 outputs of 3x3x3 multiply-adds plus the bias
+		{Type: "Conv", MultiplyAdds: 6912, FLOPs: 2*6912 + 256, Parameters: 108 + 4, ParameterBytes: 4 * 112, ActivationBytes: 4 * 256},
+		{Type: "Relu", FLOPs: 256, ActivationBytes: 4 * 256},
+		{Type: "MaxPool", FLOPs: 64 * 4, ActivationBytes: 4 * 64},
+		{Type: "FC", MultiplyAdds: 640, FLOPs: 2*640 + 10, Parameters: 640 + 10, ParameterBytes: 4 * 650, ActivationBytes: 4 * 10},
+		{Type: "Softmax", FLOPs: 3 * 10, ActivationBytes: 4 * 10},
+	}
+	if len(stats.Operators) != len(want) {
+		t.Fatalf("got the stats of %d operators, want %d", len(stats.Operators), len(want))
+	}
+	var total OperatorStats
+	for ii, got := range stats.Operators {
+		w := want[ii]
+		if got.Index != ii || got.Type != w.Type || got.Unknown ||
+			got.MultiplyAdds != w.MultiplyAdds || got.FLOPs != w.FLOPs ||
+			got.Parameters != w.Parameters || got.ParameterBytes != w.ParameterBytes ||
+			got.ActivationBytes != w.ActivationBytes {
+			t.Errorf("stats of operator %d = %+v, want %+v", ii, got, w)
+		}
+		total.MultiplyAdds += w.MultiplyAdds
+		total.FLOPs += w.FLOPs
+		total.Parameters += w.Parameters
+		total.ParameterBytes += w.ParameterBytes
+		total.ActivationBytes += w.ActivationBytes
+	}
+	if got, want := stats.Operators[2].OutputShapes, [][]int64{{1, 4, 4, 4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("output shapes of the pool = %v, want %v", got, want)
+	}
+	if stats.TotalMultiplyAdds != total.MultiplyAdds || stats.TotalFLOPs != total.FLOPs ||
+		stats.TotalParameters != total.Parameters || stats.TotalParameterBytes != total.ParameterBytes ||
+		stats.TotalActivationBytes != total.ActivationBytes {
+		t.Errorf("totals = %+v, want %+v", stats, total)
+	}
+}
+
+func TestAnalyzeNetSharedAndUnknown(t *testing.T) {
+	net := &NetDef{
+		ExternalInput: []string{"data", "w"},
+		Op: []*OperatorDef{
+			newOp("FC", []string{"data", "w"}, []string{"a"}),
+			newOp("FC", []string{"data", "w"}, []string{"b"}),
+			newOp("Custom", []string{"a", "b"}, []string{"c"}),
+		},
+	}
+	init := &NetDef{Op: []*OperatorDef{
+		newOp("GivenTensorInt64Fill", nil, []string{"w"}, intsArg("shape", 5, 4)),
+	}}
+	stats, err := AnalyzeNet(net, init, map[string][]int64{"data": {2, 4}})
+	if err != nil {
+		t.Fatalf("AnalyzeNet failed: %v", err)
+	}
+	if got := stats.TotalParameters; got != 20 {
+		t.Errorf("shared weights counted as %d parameters, want 20", got)
+	}
+	if got := stats.TotalParameterBytes; got != 8*20 {
+		t.Errorf("int64 weights take %d bytes, want %d", got, 8*20)
+	}
+	if got := stats.Operators[1].MultiplyAdds; got != 2*5*4 {
+		t.Errorf("multiply-adds of the second FC = %d, want %d", got, 2*5*4)
+	}
+	if custom := stats.Operators[2]; !custom.Unknown || custom.FLOPs != 0 {
+		t.Errorf("stats of an unsupported operator = %+v, want unknown", custom)
+	}
+}
+
+func TestOperatorCostErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      *OperatorDef
+		inputs  [][]int64
+		outputs [][]int64
+	}{
+		{"conv without filter shape", newOp("Conv", []string{"x", "w"}, []string{"y"}, intArg("kernel", 3)), [][]int64{{1, 3, 8, 8}, {}}, [][]int64{{1, 4, 6, 6}}},
+		{"conv filter of rank 1", newOp("Conv", []string{"x", "w"}, []string{"y"}, intArg("kernel", 3)), [][]int64{{1, 3, 8, 8}, {4}}, [][]int64{{1, 4, 6, 6}}},
+		{"conv without filter", newOp("Conv", []string{"x"}, []string{"y"}, intArg("kernel", 3)), [][]int64{{1, 3, 8, 8}}, [][]int64{{1, 4, 6, 6}}},
+		{"fc axis", newOp("FC", []string{"x", "w"}, []string{"y"}, intArg("axis", 3)), [][]int64{{2, 4}, {5, 4}}, [][]int64{{2, 5}}},
+		{"global pool rank", newOp("MaxPool", []string{"x"}, []string{"y"}, intArg("global_pooling", 1)), [][]int64{{4}}, [][]int64{{4}}},
+	}
+	for _, test := range tests {
+		cost := operatorCostFuncs[test.op.GetType()]
+		if _, _, err := cost(test.op, test.inputs, test.outputs); err == nil {
+			t.Errorf("%s: the cost was computed", test.name)
+		}
+	}
+}
+
+func TestInitNetParameters(t *testing.T) {
+	init := &NetDef{Op: []*OperatorDef{
+		newOp("GivenTensorFill", nil, []string{"values_only"}, floatsArg("values", 1, 2, 3)),
+		newOp("ConstantFill", nil, []string{"half"}, intsArg("shape", 2, 2), intArg("dtype", int64(TensorProto_FLOAT16))),
+		newOp("ConstantFill", nil, []string{"no_shape"}),
+	}}
+	params := InitNetParameters(init)
+	if got, want := params["values_only"], (ParameterInfo{Name: "values_only", Shape: []int64{3}, Elements: 3, Bytes: 12}); !reflect.DeepEqual(got, want) {
+		t.Errorf("values_only = %+v, want %+v", got, want)
+	}
+	if got, want := params["half"], (ParameterInfo{Name: "half", Shape: []int64{2, 2}, Elements: 4, Bytes: 8}); !reflect.DeepEqual(got, want) {
+		t.Errorf("half = %+v, want %+v", got, want)
+	}
+	if _, ok := params["no_shape"]; ok {
+		t.Error("a fill without shape has parameters")
+	}
+}
@@ -0,0 +1,399 @@
+package caffe2
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Tensor is a TensorProto decoded into a Go slice. Data holds one of
+// []float32 (FLOAT and FLOAT16), []float64, []int32, []int64, []int16,
+// []uint16, []int8, []uint8 (UINT8 and BYTE), []bool or []string depending on
+// DataType.
+type Tensor struct {
+	Name     string
+	DataType TensorProto_DataType
+	Dims     []int64
+	Data     interface{}
+}
+
+// Size returns the number of elements described by the dimensions.
+func (t *Tensor) Size() int64 {
+	return dimsProduct(t.Dims)
+}
+
+// Float32s returns the data of a numeric tensor converted to float32.
+func (t *Tensor) Float32s() ([]float32, error) {
+	switch data := t.Data.(type) {
+	case []float32:
+		return data, nil
+	case []float64:
+		res := make([]float32, len(data))
+		for ii, v := range data {
+			res[ii] = float32(v)
+		}
+		return res, nil
+	case []int32:
+		res := make([]float32, len(data))
+		for ii, v := range data {
+			res[ii] = float32(v)
+		}
+		return res, nil
+	case []int64:
+		res := make([]float32, len(data))
+		for ii, v := range data {
+			res[ii] = float32(v)
+		}
+		return res, nil
+	case []int16:
+		res := make([]float32, len(data))
+		for ii, v := range data {
+			res[ii] = float32(v)
+		}
+		return res, nil
+	case []uint16:
+		res := make([]float32, len(data))
+		for ii, v := range data {
+			res[ii] = float32(v)
+		}
+		return res, nil
+	case []int8:
+		res := make([]float32, len(data))
+		for ii, v := range data {
+			res[ii] = float32(v)
+		}
+		return res, nil
+	case []uint8:
+		res := make([]float32, len(data))
+		for ii, v := range data {
+			res[ii] = float32(v)
+		}
+		return res, nil
+	case []bool:
+		res := make([]float32, len(data))
+		for ii, v := range data {
+			if v {
+				res[ii] = 1
+			}
+		}
+		return res, nil
+	}
+	return nil, errors.Errorf("tensor %s of type %v is not numeric", t.Name, t.DataType)
+}
+
+// DecodeTensor decodes the data of a TensorProto according to its data type
+// and checks that the number of elements matches its dimensions.
+func DecodeTensor(proto *TensorProto) (*Tensor, error) {
+	t := &Tensor{
+		Name:     proto.GetName(),
+		DataType: proto.GetDataType(),
+		Dims:     proto.GetDims(),
+	}
+
+	var count int
+	switch t.DataType {
+	case TensorProto_FLOAT:
+		data := proto.GetFloatData()
+		t.Data, count = data, len(data)
+	case TensorProto_DOUBLE:
+		data := proto.GetDoubleData()
+		t.Data, count = data, len(data)
+	case TensorProto_INT32:
+		data := proto.GetInt32Data()
+		t.Data, count = data, len(data)
+	case TensorProto_INT64:
+		data := proto.GetInt64Data()
+		t.Data, count = data, len(data)
+	case TensorProto_BYTE:
+		data := proto.GetByteData()
+		t.Data, count = data, len(data)
+	case TensorProto_STRING:
+		raw := proto.GetStringData()
+		data := make([]string, len(raw))
+		for ii, s := range raw {
+			data[ii] = string(s)
+		}
+		t.Data, count = data, len(data)
+	case TensorProto_FLOAT16:
+		raw := proto.GetInt32Data()
+		data := make([]float32, len(raw))
+		for ii, v := range raw {
+			data[ii] = Float16ToFloat32(uint16(v))
+		}
+		t.Data, count = data, len(data)
+	case TensorProto_BOOL:
+		raw := proto.GetInt32Data()
+		data := make([]bool, len(raw))
+		for ii, v := range raw {
+			data[ii] = v != 0
+		}
+		t.Data, count = data, len(data)
+	case TensorProto_UINT8:
+		raw := proto.GetInt32Data()
+		data := make([]uint8, len(raw))
+		for ii, v := range raw {
+			data[ii] = uint8(v)
+		}
+		t.Data, count = data, len(data)
+	case TensorProto_INT8:
+		raw := proto.GetInt32Data()
+		data := make([]int8, len(raw))
+		for ii, v := range raw {
+			data[ii] = int8(v)
+		}
+		t.Data, count = data, len(data)
+	case TensorProto_UINT16:
+		raw := proto.GetInt32Data()
+		data := make([]uint16, len(raw))
+		for ii, v := range raw {
+			data[ii] = uint16(v)
+		}
+		t.Data, count = data, len(data)
+	case TensorProto_INT16:
+		raw := proto.GetInt32Data()
+		data := make([]int16, len(raw))
+		for ii, v := range raw {
+			data[ii] = int16(v)
+		}
+		t.Data, count = data, len(data)
+	default:
+		return nil, errors.Errorf("tensor %s has an unsupported data type %v", t.Name, t.DataType)
+	}
+
+	if expected := t.Size(); int64(count) != expected {
+		return nil, errors.Errorf("tensor %s has %d elements but its dimensions %v need %d", t.Name, count, t.Dims, expected)
+	}
+	return t, nil
+}
+
+// NewTensorProto builds a TensorProto from a Go slice, the data type is
+// inferred from the slice type ([]uint8 is stored as UINT8). The number of
+// elements must match the dimensions.
+func NewTensorProto(name string, dims []int64, data interface{}) (*TensorProto, error) {
+	var dtype TensorProto_DataType
+	switch data.(type) {
+	case []float32:
+		dtype = TensorProto_FLOAT
+	case []float64:
+		dtype = TensorProto_DOUBLE
+	case []int32:
+		dtype = TensorProto_INT32
+	case []int64:
+		dtype = TensorProto_INT64
+	case []int16:
+		dtype = TensorProto_INT16
+	case []uint16:
+		dtype = TensorProto_UINT16
+	case []int8:
+		dtype = TensorProto_INT8
+	case []uint8:
+		dtype = TensorProto_UINT8
+	case []bool:
+		dtype = TensorProto_BOOL
+	case []string, [][]byte:
+		dtype = TensorProto_STRING
+	default:
+		return nil, errors.Errorf("unsupported tensor data of type %T", data)
+	}
+	return NewTensorProtoWithType(name, dims, dtype, data)
+}
+
+// NewTensorProtoWithType builds a TensorProto of the given data type. It is
+// needed for the FLOAT16 ([]float32 data) and BYTE ([]uint8 data) types that
+// cannot be inferred from the Go type.
+func NewTensorProtoWithType(name string, dims []int64, dtype TensorProto_DataType, data interface{}) (*TensorProto, error) {
+	proto := &TensorProto{
+		Name:     name,
+		Dims:     append([]int64{}, dims...),
+		DataType: dtype.Enum(),
+	}
+
+	var count int
+	mismatch := func() error {
+		return errors.Errorf("cannot build a %v tensor from %T", dtype, data)
+	}
+
+	switch dtype {
+	case TensorProto_FLOAT:
+		vals, ok := data.([]float32)
+		if !ok {
+			return nil, mismatch()
+		}
+		proto.FloatData, count = vals, len(vals)
+	case TensorProto_DOUBLE:
+		vals, ok := data.([]float64)
+		if !ok {
+			return nil, mismatch()
+		}
+		proto.DoubleData, count = vals, len(vals)
+	case TensorProto_INT32:
+		vals, ok := data.([]int32)
+		if !ok {
+			return nil, mismatch()
+		}
+		proto.Int32Data, count = vals, len(vals)
+	case TensorProto_INT64:
+		vals, ok := data.([]int64)
+		if !ok {
+			return nil, mismatch()
+		}
+		proto.Int64Data, count = vals, len(vals)
+	case TensorProto_BYTE:
+		vals, ok := data.([]uint8)
+		if !ok {
+			return nil, mismatch()
+		}
+		proto.ByteData, count = vals, len(vals)
+	case TensorProto_STRING:
+		switch vals := data.(type) {
+		case []string:
+			proto.StringData = make([][]byte, len(vals))
+			for ii, s := range vals {
+				proto.StringData[ii] = []byte(s)
+			}
+			count = len(vals)
+		case [][]byte:
+			proto.StringData, count = vals, len(vals)
+		default:
+			return nil, mismatch()
+		}
+	case TensorProto_FLOAT16:
+		vals, ok := data.([]float32)
+		if !ok {
+			return nil, mismatch()
+		}
+		proto.Int32Data = make([]int32, len(vals))
+		for ii, v := range vals {
+			proto.Int32Data[ii] = int32(Float32ToFloat16(v))
+		}
+		count = len(vals)
+	case TensorProto_BOOL:
+		vals, ok := data.([]bool)
+		if !ok {
+			return nil, mismatch()
+		}
+		proto.Int32Data = make([]int32, len(vals))
+		for ii, v := range vals {
+			if v {
+				proto.Int32Data[ii] = 1
+			}
+		}
+		count = len(vals)
+	case TensorProto_UINT8:
+		vals, ok := data.([]uint8)
+		if !ok {
+			return nil, mismatch()
+		}
+		proto.Int32Data = make([]int32, len(vals))
+		for ii, v := range vals {
+			proto.Int32Data[ii] = int32(v)
+		}
+		count = len(vals)
+	case TensorProto_INT8:
+		vals, ok := data.([]int8)
+		if !ok {
+			return nil, mismatch()
+		}
+		proto.Int32Data = make([]int32, len(vals))
+		for ii, v := range vals {
+			proto.Int32Data[ii] = int32(v)
+		}
+		count = len(vals)
+	case TensorProto_UINT16:
+		vals, ok := data.([]uint16)
+		if !ok {
+			return nil, mismatch()
+		}
+		proto.Int32Data = make([]int32, len(vals))
+		for ii, v := range vals {
+			proto.Int32Data[ii] = int32(v)
+		}
+		count = len(vals)
+	case TensorProto_INT16:
+		vals, ok := data.([]int16)
+		if !ok {
+			return nil, mismatch()
+		}
+		proto.Int32Data = make([]int32, len(vals))
+		for ii, v := range vals {
+			proto.Int32Data[ii] = int32(v)
+		}
+		count = len(vals)
+	default:
+		return nil, errors.Errorf("unsupported tensor data type %v", dtype)
+	}
+
+	if expected := dimsProduct(dims); int64(count) != expected {
+		return nil, errors.Errorf("tensor %s has %d elements but its dimensions %v need %d", name, count, dims, expected)
+	}
+	return proto, nil
+}
+
+// Float16ToFloat32 decodes an IEEE 754 half precision number.
+func Float16ToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h) & 0x3ff
+
+	switch {
+	case exp == 0 && mant == 0:
+		return math.Float32frombits(sign)
+	case exp == 0:
+		// subnormal, normalize it
+		e := uint32(127 - 15 + 1)
+		for mant&0x400 == 0 {
+			mant <<= 1
+			e--
+		}
+		mant &= 0x3ff
+		return math.Float32frombits(sign | e<<23 | mant<<13)
+	case exp == 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+// Float32ToFloat16 encodes a float32 as an IEEE 754 half precision number,
+// rounding to the nearest even value.
+func Float32ToFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int32(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+
+	if exp == 0xff {
+		if mant != 0 {
+			// keep NaNs quiet
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	}
+
+	e := exp - 127 + 15
+	switch {
+	case e >= 0x1f:
+		return sign | 0x7c00
+	case e <= 0:
+		if e < -10 {
+			return sign
+		}
+		// subnormal: shift the mantissa including the implicit bit
+		mant |= 0x800000
+		shift := uint32(14 - e)
+		half := uint16(mant >> shift)
+		rem := mant & (1<<shift - 1)
+		mid := uint32(1) << (shift - 1)
+		if rem > mid || (rem == mid && half&1 == 1) {
+			half++
+		}
+		return sign | half
+	}
+
+	half := uint16(e)<<10 | uint16(mant>>13)
+	rem := mant & 0x1fff
+	if rem > 0x1000 || (rem == 0x1000 && half&1 == 1) {
+		// may carry into the exponent, which correctly rounds up to infinity
+		half++
+	}
+	return sign | half
+}
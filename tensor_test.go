@@ -0,0 +1,120 @@
+package caffe2
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestTensorRoundTrip(t *testing.T) {
+	dims := []int64{2, 2}
+	for _, data := range []interface{}{
+		[]float32{1.5, -2, 0, 3},
+		[]float64{1.5, -2, 0, 1e300},
+		[]int32{1, -2, 3, math.MaxInt32},
+		[]int64{1, -2, 3, math.MaxInt64},
+		[]int16{1, -2, 3, math.MinInt16},
+		[]uint16{1, 2, 3, math.MaxUint16},
+		[]int8{1, -2, 3, math.MinInt8},
+		[]uint8{1, 2, 3, math.MaxUint8},
+		[]bool{true, false, false, true},
+		[]string{"a", "b", "", "d"},
+	} {
+		proto, err := NewTensorProto("t", dims, data)
+		if err != nil {
+			t.Fatalf("NewTensorProto(%T): %v", data, err)
+		}
+		tensor, err := DecodeTensor(proto)
+		if err != nil {
+			t.Fatalf("DecodeTensor(%T): %v", data, err)
+		}
+		if !reflect.DeepEqual(tensor.Data, data) || !reflect.DeepEqual(tensor.Dims, dims) || tensor.Name != "t" {
+			t.Errorf("round trip of %T = %+v, want %v", data, tensor, data)
+		}
+	}
+}
+
+func TestTensorWithType(t *testing.T) {
+	half, err := NewTensorProtoWithType("h", []int64{3}, TensorProto_FLOAT16, []float32{1, -0.5, 65504})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int32{0x3c00, 0xb800, 0x7bff}; !reflect.DeepEqual(half.GetInt32Data(), want) {
+		t.Errorf("FLOAT16 storage = %#x, want %#x", half.GetInt32Data(), want)
+	}
+	tensor, err := DecodeTensor(half)
+	if err != nil {
+		t.Fatal(err)
+	}
+	floats, err := tensor.Float32s()
+	if err != nil || !reflect.DeepEqual(floats, []float32{1, -0.5, 65504}) {
+		t.Errorf("FLOAT16 decoded = %v, %v", floats, err)
+	}
+
+	bytes, err := NewTensorProtoWithType("b", []int64{2}, TensorProto_BYTE, []uint8{7, 8})
+	if err != nil || !reflect.DeepEqual(bytes.GetByteData(), []byte{7, 8}) {
+		t.Errorf("BYTE tensor = %v, %v", bytes, err)
+	}
+	if _, err := NewTensorProtoWithType("b", []int64{2}, TensorProto_INT32, []float32{1, 2}); err == nil {
+		t.Error("building an INT32 tensor from []float32 succeeded")
+	}
+}
+
+func TestTensorErrors(t *testing.T) {
+	if _, err := NewTensorProto("t", []int64{3}, []float32{1, 2}); err == nil {
+		t.Error("NewTensorProto with too few elements succeeded")
+	}
+	if _, err := NewTensorProto("t", []int64{1}, []complex64{1}); err == nil {
+		t.Error("NewTensorProto of []complex64 succeeded")
+	}
+	proto := &TensorProto{Name: "t", Dims: []int64{3}, DataType: TensorProto_FLOAT.Enum(), FloatData: []float32{1}}
+	if _, err := DecodeTensor(proto); err == nil {
+		t.Error("DecodeTensor with too few elements succeeded")
+	}
+	tensor := &Tensor{Name: "s", DataType: TensorProto_STRING, Data: []string{"a"}}
+	if _, err := tensor.Float32s(); err == nil {
+		t.Error("Float32s of a string tensor succeeded")
+	}
+}
+
+func TestFloat16(t *testing.T) {
+	tests := []struct {
+		f float32
+		h uint16
+	}{
+		{0, 0x0000},
+		{float32(math.Copysign(0, -1)), 0x8000},
+		{1, 0x3c00},
+		{-2, 0xc000},
+		{65504, 0x7bff},
+		{float32(math.Pow(2, -24)), 0x0001},
+		{float32(math.Pow(2, -14)), 0x0400},
+		{float32(math.Inf(1)), 0x7c00},
+		{float32(math.Inf(-1)), 0xfc00},
+	}
+	for _, test := range tests {
+		if got := Float32ToFloat16(test.f); got != test.h {
+			t.Errorf("Float32ToFloat16(%v) = %#04x, want %#04x", test.f, got, test.h)
+		}
+		if got := Float16ToFloat32(test.h); math.Float32bits(got) != math.Float32bits(test.f) {
+			t.Errorf("Float16ToFloat32(%#04x) = %v, want %v", test.h, got, test.f)
+		}
+	}
+
+	// rounding to the nearest even value, and to infinity past the range
+	for f, want := range map[float32]uint16{
+		1 + 1.0/2048:     0x3c00,
+		1 + 3.0/2048:     0x3c02,
+		65520:            0x7c00,
+		1e6:              0x7c00,
+		float32(1e-10):   0x0000,
+		-float32(1.5e-5): 0x80fc,
+	} {
+		if got := Float32ToFloat16(f); got != want {
+			t.Errorf("Float32ToFloat16(%v) = %#04x, want %#04x", f, got, want)
+		}
+	}
+	if got := Float16ToFloat32(Float32ToFloat16(float32(math.NaN()))); !math.IsNaN(float64(got)) {
+		t.Errorf("NaN round trip = %v", got)
+	}
+}
@@ -0,0 +1,330 @@
+package caffe2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ValidationSeverity ...
+type ValidationSeverity int
+
+const (
+	// ValidationWarning marks a suspicious but runnable construct.
+	ValidationWarning ValidationSeverity = iota
+	// ValidationError marks a construct that would fail at run time.
+	ValidationError
+)
+
+// String ...
+func (s ValidationSeverity) String() string {
+	if s == ValidationError {
+		return "error"
+	}
+	return "warning"
+}
+
+// ValidationIssue is a problem found by ValidateNet.
+type ValidationIssue struct {
+	Severity ValidationSeverity
+	// Net is either "predict" or "init".
+	Net string
+	// Op is the index of the offending operator or -1 for net level issues.
+	Op      int
+	OpType  string
+	Message string
+}
+
+// String ...
+func (i ValidationIssue) String() string {
+	if i.Op < 0 {
+		return fmt.Sprintf("%s: %s net: %s", i.Severity, i.Net, i.Message)
+	}
+	return fmt.Sprintf("%s: %s net: op #%d (%s): %s", i.Severity, i.Net, i.Op, i.OpType, i.Message)
+}
+
+// ValidationIssues ...
+type ValidationIssues []ValidationIssue
+
+// Errors returns the issues with an error severity.
+func (v ValidationIssues) Errors() ValidationIssues {
+	var res ValidationIssues
+	for _, issue := range v {
+		if issue.Severity == ValidationError {
+			res = append(res, issue)
+		}
+	}
+	return res
+}
+
+// Warnings returns the issues with a warning severity.
+func (v ValidationIssues) Warnings() ValidationIssues {
+	var res ValidationIssues
+	for _, issue := range v {
+		if issue.Severity == ValidationWarning {
+			res = append(res, issue)
+		}
+	}
+	return res
+}
+
+// Err returns an error describing all the errors, or nil if there are none.
+func (v ValidationIssues) Err() error {
+	errs := v.Errors()
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for ii, issue := range errs {
+		msgs[ii] = issue.String()
+	}
+	return errors.Errorf("invalid net (%d errors):\n  %s", len(errs), strings.Join(msgs, "\n  "))
+}
+
+// operatorSchema lists the requirements of the operators we know about.
+type operatorSchema struct {
+	minInputs  int
+	minOutputs int
+	// required lists groups of arguments, at least one argument of each
+	// group must be present
+	required [][]string
+}
+
+var windowArguments = []string{"kernel", "kernel_h", "kernels", "global_pooling"}
+
+var operatorSchemas = map[string]operatorSchema{
+	// the kernel of a Conv defaults to the spatial dimensions of its filter
+	"Conv":                  {minInputs: 2, minOutputs: 1},
+	"MaxPool":               {minInputs: 1, minOutputs: 1, required: [][]string{windowArguments}},
+	"AveragePool":           {minInputs: 1, minOutputs: 1, required: [][]string{windowArguments}},
+	"FC":                    {minInputs: 2, minOutputs: 1},
+	"Relu":                  {minInputs: 1, minOutputs: 1},
+	"Dropout":               {minInputs: 1, minOutputs: 1},
+	"LRN":                   {minInputs: 1, minOutputs: 1, required: [][]string{{"size"}}},
+	"Softmax":               {minInputs: 1, minOutputs: 1},
+	"Sum":                   {minInputs: 1, minOutputs: 1},
+	"SpatialBN":             {minInputs: 5, minOutputs: 1},
+	"Concat":                {minInputs: 1, minOutputs: 1},
+	"Flatten":               {minInputs: 1, minOutputs: 1},
+	"Reshape":               {minInputs: 1, minOutputs: 1},
+	"GivenTensorFill":       {minOutputs: 1, required: [][]string{{"values"}, {"shape"}}},
+	"GivenTensorIntFill":    {minOutputs: 1, required: [][]string{{"values"}, {"shape"}}},
+	"GivenTensorInt16Fill":  {minOutputs: 1, required: [][]string{{"values"}, {"shape"}}},
+	"GivenTensorInt64Fill":  {minOutputs: 1, required: [][]string{{"values"}, {"shape"}}},
+	"GivenTensorDoubleFill": {minOutputs: 1, required: [][]string{{"values"}, {"shape"}}},
+	"GivenTensorBoolFill":   {minOutputs: 1, required: [][]string{{"values"}, {"shape"}}},
+	"ConstantFill":          {minOutputs: 1},
+}
+
+// ValidateNet statically checks a predict net, optionally together with the
+// init net providing its weights. It reports blobs read before being written,
+// dangling external outputs, missing operator arguments and unknown control
+// inputs as errors, and unused outputs, duplicate operator names and
+// inconsistent device options as warnings.
+func ValidateNet(net *NetDef, init *NetDef) ValidationIssues {
+	if init == nil {
+		return ValidatePredictNet(net, nil)
+	}
+	issues := validateOperators("init", init, map[string]bool{}, nil)
+	weights := InitNetShapes(init)
+	for _, op := range init.GetOp() {
+		for _, output := range op.GetOutput() {
+			if _, ok := weights[output]; !ok {
+				weights[output] = nil
+			}
+		}
+	}
+	return append(issues, ValidatePredictNet(net, weights)...)
+}
+
+// ValidatePredictNet is ValidateNet for a predict net whose init net is not
+// checked, only the blobs it fills and their shapes are given, as returned by
+// ReadInitNetShapes.
+func ValidatePredictNet(net *NetDef, weights map[string][]int64) ValidationIssues {
+	available := map[string]bool{}
+	for name := range weights {
+		available[name] = true
+	}
+	for _, input := range net.GetExternalInput() {
+		available[input] = true
+	}
+
+	issues := validateOperators("predict", net, available, weights)
+
+	// external outputs must be produced by the net
+	produced := map[string]bool{}
+	for _, op := range net.GetOp() {
+		for _, output := range op.GetOutput() {
+			produced[output] = true
+		}
+	}
+	for _, output := range net.GetExternalOutput() {
+		if !produced[output] && !available[output] {
+			issues = append(issues, ValidationIssue{
+				Severity: ValidationError,
+				Net:      "predict",
+				Op:       -1,
+				Message:  fmt.Sprintf("external output %s is never produced", output),
+			})
+		}
+	}
+
+	// outputs that are never read nor exported
+	exported := map[string]bool{}
+	for _, output := range net.GetExternalOutput() {
+		exported[output] = true
+	}
+	consumers := net.Consumers()
+	for ii, op := range net.GetOp() {
+		for _, output := range op.GetOutput() {
+			if exported[output] || consumedAfter(consumers[output], ii) {
+				continue
+			}
+			if isAuxiliaryOutput(op, output) {
+				continue
+			}
+			issues = append(issues, ValidationIssue{
+				Severity: ValidationWarning,
+				Net:      "predict",
+				Op:       ii,
+				OpType:   op.GetType(),
+				Message:  fmt.Sprintf("output %s is never used", output),
+			})
+		}
+	}
+
+	return issues
+}
+
+// validateOperators checks the operators of a net, available holds the blobs
+// set before it runs and shapes the known shapes of some of them.
+func validateOperators(netName string, net *NetDef, available map[string]bool, shapes map[string][]int64) ValidationIssues {
+	var issues ValidationIssues
+	report := func(severity ValidationSeverity, ii int, op *OperatorDef, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{
+			Severity: severity,
+			Net:      netName,
+			Op:       ii,
+			OpType:   op.GetType(),
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	names := map[string]int{}
+	netDevice := net.GetDeviceOption()
+	var cudaDevice *DeviceOption
+
+	for ii, op := range net.GetOp() {
+		for _, input := range op.GetInput() {
+			if !available[input] {
+				report(ValidationError, ii, op, "input %s is used before being produced", input)
+			}
+		}
+		for _, control := range op.GetControlInput() {
+			if !available[control] {
+				report(ValidationError, ii, op, "control input %s refers to an unknown blob", control)
+			}
+		}
+
+		if name := op.GetName(); name != "" {
+			if prev, ok := names[name]; ok {
+				report(ValidationWarning, ii, op, "duplicate operator name %s (first used by op #%d)", name, prev)
+			} else {
+				names[name] = ii
+			}
+		}
+
+		if schema, ok := operatorSchemas[op.GetType()]; ok {
+			if len(op.GetInput()) < schema.minInputs {
+				report(ValidationError, ii, op, "expecting at least %d inputs, got %d", schema.minInputs, len(op.GetInput()))
+			}
+			if len(op.GetOutput()) < schema.minOutputs {
+				report(ValidationError, ii, op, "expecting at least %d outputs, got %d", schema.minOutputs, len(op.GetOutput()))
+			}
+			for _, group := range schema.required {
+				if !hasAnyArgument(op, group) {
+					report(ValidationError, ii, op, "missing required argument %s", strings.Join(group, " or "))
+				}
+			}
+		}
+		if op.GetType() == "Conv" && !hasAnyArgument(op, windowArguments) && len(op.GetInput()) >= 2 {
+			if filter := shapes[op.GetInput()[1]]; len(filter) != 4 {
+				report(ValidationWarning, ii, op, "has no kernel argument and the shape of its filter %s is unknown", op.GetInput()[1])
+			}
+		}
+		if op.GetType() == "Reshape" && !op.HasArgument("shape") && len(op.GetInput()) < 2 {
+			report(ValidationError, ii, op, "missing the new shape, either as the shape argument or as a second input")
+		}
+		if shape := op.GetArgument("shape"); shape != nil && strings.HasPrefix(op.GetType(), "GivenTensor") {
+			values := op.GetArgument("values")
+			count := int64(len(values.GetFloats()) + len(values.GetInts()) + len(values.GetStrings()))
+			if values.GetS() != nil {
+				count = int64(len(values.GetS()))
+			}
+			if expected := dimsProduct(shape.GetInts()); values != nil && count != expected {
+				report(ValidationError, ii, op, "has %d values but its shape %v needs %d", count, shape.GetInts(), expected)
+			}
+		}
+
+		// caffe2 copies the blobs between devices, the mismatches are only
+		// suspicious
+		if device := op.GetDeviceOption(); device != nil {
+			if netDevice != nil && device.GetDeviceType() != netDevice.GetDeviceType() {
+				report(ValidationWarning, ii, op, "runs on %s while the net runs on %s", formatDeviceOption(device), formatDeviceOption(netDevice))
+			}
+			if device.GetDeviceType() == int32(DeviceType_CUDA) {
+				if cudaDevice != nil && cudaDevice.GetCudaGpuId() != device.GetCudaGpuId() {
+					report(ValidationWarning, ii, op, "runs on %s while other operators run on %s", formatDeviceOption(device), formatDeviceOption(cudaDevice))
+				} else {
+					cudaDevice = device
+				}
+			}
+		}
+		effective := op.GetDeviceOption()
+		if effective == nil {
+			effective = netDevice
+		}
+		if strings.HasPrefix(op.GetEngine(), "CUDNN") && effective.GetDeviceType() != int32(DeviceType_CUDA) {
+			report(ValidationWarning, ii, op, "uses the %s engine on %s", op.GetEngine(), formatDeviceOption(effective))
+		}
+
+		for _, output := range op.GetOutput() {
+			available[output] = true
+		}
+	}
+
+	return issues
+}
+
+func hasAnyArgument(op *OperatorDef, names []string) bool {
+	for _, name := range names {
+		if op.HasArgument(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func consumedAfter(consumers []int, index int) bool {
+	for _, consumer := range consumers {
+		if consumer > index {
+			return true
+		}
+	}
+	return false
+}
+
+// isAuxiliaryOutput returns true for the outputs that operators produce as a
+// side effect and that are commonly left unused.
+func isAuxiliaryOutput(op *OperatorDef, output string) bool {
+	outputs := op.GetOutput()
+	if len(outputs) == 0 || outputs[0] == output {
+		return false
+	}
+	switch op.GetType() {
+	case "Dropout", "Concat", "Reshape", "SpatialBN", "MaxPool":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package caffe2
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateNet(t *testing.T) {
+	cuda := int32(DeviceType_CUDA)
+	tests := []struct {
+		name     string
+		modify   func(net, init *NetDef)
+		errors   []string
+		warnings []string
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name: "used before produced",
+			modify: func(net, init *NetDef) {
+				net.Op[3].Input[0] = "pooled"
+			},
+			errors:   []string{"input pooled is used before being produced"},
+			warnings: []string{"output pool is never used"},
+		},
+		{
+			name: "dangling external output",
+			modify: func(net, init *NetDef) {
+				net.ExternalOutput = append(net.ExternalOutput, "missing")
+			},
+			errors: []string{"external output missing is never produced"},
+		},
+		{
+			name: "unused output",
+			modify: func(net, init *NetDef) {
+				net.ExternalOutput = nil
+			},
+			warnings: []string{"output prob is never used"},
+		},
+		{
+			name: "duplicate operator name",
+			modify: func(net, init *NetDef) {
+				net.Op[0].Name = "layer"
+				net.Op[1].Name = "layer"
+			},
+			warnings: []string{"duplicate operator name layer (first used by op #0)"},
+		},
+		{
+			name: "missing pool window",
+			modify: func(net, init *NetDef) {
+				net.Op[2].Arg = []*Argument{intArg("stride", 2)}
+			},
+			errors: []string{"missing required argument kernel or kernel_h or kernels or global_pooling"},
+		},
+		{
+			name: "missing LRN size",
+			modify: func(net, init *NetDef) {
+				net.Op[1] = newOp("LRN", []string{"conv"}, []string{"conv"}, floatArg("alpha", 1e-4))
+			},
+			errors: []string{"missing required argument size"},
+		},
+		{
+			name: "conv kernel from the filter shape",
+			modify: func(net, init *NetDef) {
+				net.Op[0].Arg = []*Argument{intArg("pad", 1)}
+			},
+		},
+		{
+			name: "conv kernel with an unknown filter shape",
+			modify: func(net, init *NetDef) {
+				net.Op[0].Arg = []*Argument{intArg("pad", 1)}
+				init.Op[0] = newOp("UniformFill", nil, []string{"conv_w"})
+			},
+			warnings: []string{"has no kernel argument and the shape of its filter conv_w is unknown"},
+		},
+		{
+			name: "unknown control input",
+			modify: func(net, init *NetDef) {
+				net.Op[4].ControlInput = []string{"barrier"}
+			},
+			errors: []string{"control input barrier refers to an unknown blob"},
+		},
+		{
+			name: "values count",
+			modify: func(net, init *NetDef) {
+				init.Op[0].Arg[1].Floats = init.Op[0].Arg[1].Floats[1:]
+			},
+			errors: []string{"has 107 values but its shape [4 3 3 3] needs 108"},
+		},
+		{
+			name: "device mismatch",
+			modify: func(net, init *NetDef) {
+				net.Op[0].DeviceOption = &DeviceOption{DeviceType: &cuda}
+			},
+			warnings: []string{"runs on CUDA:0 while the net runs on CPU"},
+		},
+	}
+	for _, test := range tests {
+		net, init := testNet(), testInitNet()
+		net.DeviceOption = &DeviceOption{}
+		if test.modify != nil {
+			test.modify(net, init)
+		}
+		issues := ValidateNet(net, init)
+		checkIssues(t, test.name+" errors", issues.Errors(), test.errors)
+		checkIssues(t, test.name+" warnings", issues.Warnings(), test.warnings)
+		if err := issues.Err(); (err != nil) != (len(test.errors) != 0) {
+			t.Errorf("%s: Err() = %v", test.name, err)
+		}
+	}
+}
+
+func TestValidatePredictNet(t *testing.T) {
+	net := testNet()
+	weights := map[string][]int64{"conv_w": {4, 3, 3, 3}, "conv_b": nil, "fc_w": nil, "fc_b": nil}
+	net.ExternalInput = []string{"data"}
+	net.Op[0].Arg = nil
+	if issues := ValidatePredictNet(net, weights); len(issues) != 0 {
+		t.Errorf("ValidatePredictNet with the init net shapes = %v, want no issue", issues)
+	}
+	delete(weights, "fc_b")
+	checkIssues(t, "missing weight", ValidatePredictNet(net, weights).Errors(), []string{"input fc_b is used before being produced"})
+}
+
+func checkIssues(t *testing.T, name string, issues ValidationIssues, want []string) {
+	if len(issues) != len(want) {
+		t.Errorf("%s: got %v, want %v", name, issues, want)
+		return
+	}
+	for ii, issue := range issues {
+		if !strings.HasSuffix(issue.String(), want[ii]) {
+			t.Errorf("%s: got %q, want it to end with %q", name, issue.String(), want[ii])
+		}
+	}
+}